@@ -33,6 +33,21 @@ const previewProvider = new class implements TextDocumentContentProvider {
 };
 
 
+// stdlibProvider serves the read-only documents of stdlib functions, which go to definition
+// jumps to. The documents are generated by the language server.
+const stdlibProvider = new class implements TextDocumentContentProvider {
+	uriScheme = 'jsonnet-stdlib';
+
+	async provideTextDocumentContent(uri: Uri): Promise<string> {
+		const result: StdlibDocumentResult = await client.sendRequest(ExecuteCommandRequest.type, {
+			command: "jsonnet.lsp.stdlibDocument",
+			arguments: [JSON.stringify({ uri: uri.toString() })]
+		});
+		return result.contents;
+	}
+};
+
+
 async function startClient(binaryPath: string, cfg: WorkspaceConfiguration): Promise<void> {
 
 	const executable: Executable = {
@@ -48,6 +63,11 @@ async function startClient(binaryPath: string, cfg: WorkspaceConfiguration): Pro
 
 	const clientOptions: LanguageClientOptions = {
 		documentSelector: [{ scheme: 'file', language: 'jsonnet' }],
+		synchronize: {
+			// keeps the workspace symbol index and directory configurations up to date with changes
+			// outside of the editor
+			fileEvents: workspace.createFileSystemWatcher('**/{*.jsonnet,*.libsonnet,.jsonnet-lsp.json,.gitignore}'),
+		},
 	};
 
 	client = new LanguageClient(
@@ -71,6 +91,19 @@ type EvaluateResult = {
 	output: string;
 };
 
+type StdlibDocumentResult = {
+	contents: string;
+};
+
+type ListOutputsResult = {
+	outputs: string[];
+	error?: string;
+};
+
+type FormatPreviewResult = {
+	diff: string;
+};
+
 export async function activate(context: ExtensionContext) {
 	let cfg = workspace.getConfiguration('jsonnet.lsp');
 
@@ -94,6 +127,7 @@ export async function activate(context: ExtensionContext) {
 			await startClient(binaryPath, cfg);
 		}),
 		workspace.registerTextDocumentContentProvider(previewProvider.uriScheme, previewProvider),
+		workspace.registerTextDocumentContentProvider(stdlibProvider.uriScheme, stdlibProvider),
 		commands.registerCommand('jsonnet.lsp.evaluate', async function (): Promise<void> {
 			const editor = window.activeTextEditor;
 			if (editor === undefined) {
@@ -122,7 +156,137 @@ export async function activate(context: ExtensionContext) {
 			
 			const doc = { ...(await workspace.openTextDocument(previewProvider.previewPaneURI)), languageId: "json" };
 			await window.showTextDocument(doc, ViewColumn.Beside, true);
-		})
+		}),
+		commands.registerCommand('jsonnet.lsp.evaluateOutput', async function (): Promise<void> {
+			const editor = window.activeTextEditor;
+			if (editor === undefined) {
+				window.showErrorMessage("jsonnet: cannot evaluate file, no active editor");
+				return;
+			}
+
+			// do nothing if it's not a jsonnet file
+			if (editor.document.languageId !== "jsonnet") {
+				return;
+			}
+
+			if (!client.isRunning()) {
+				window.showErrorMessage("jsonnet: cannot evaluate file, language server not running");
+				return;
+			}
+
+			const textDocument = { uri: editor.document.uri.toString() };
+			const outputs: ListOutputsResult = await client.sendRequest(ExecuteCommandRequest.type, {
+				command: "jsonnet.lsp.listOutputs",
+				arguments: [JSON.stringify({ textDocument })]
+			}).catch(err => window.showErrorMessage(`jsonnet: failed to evaluate file ${err}`));
+
+			if (outputs.error) {
+				window.showErrorMessage(`jsonnet: cannot list outputs: ${outputs.error}`);
+				return;
+			}
+
+			const output = await window.showQuickPick(outputs.outputs, { placeHolder: "Output to evaluate" });
+			if (output === undefined) {
+				return;
+			}
+
+			const result: EvaluateResult = await client.sendRequest(ExecuteCommandRequest.type, {
+				command: "jsonnet.lsp.evaluate",
+				arguments: [JSON.stringify({ textDocument, output })]
+			}).catch(err => window.showErrorMessage(`jsonnet: failed to evaluate file ${err}`));
+
+			previewProvider.previewDidChange(result.output);
+
+			const doc = { ...(await workspace.openTextDocument(previewProvider.previewPaneURI)), languageId: "json" };
+			await window.showTextDocument(doc, ViewColumn.Beside, true);
+		}),
+		commands.registerCommand('jsonnet.lsp.formatSubtree', async function (): Promise<void> {
+			const editor = window.activeTextEditor;
+			if (editor === undefined || editor.document.languageId !== "jsonnet") {
+				return;
+			}
+
+			if (!client.isRunning()) {
+				window.showErrorMessage("jsonnet: cannot format, language server not running");
+				return;
+			}
+
+			const result = await client.sendRequest(ExecuteCommandRequest.type, {
+				command: "jsonnet.lsp.formatSubtree",
+				arguments: [JSON.stringify({
+					textDocument: { uri: editor.document.uri.toString() },
+					position: client.code2ProtocolConverter.asPosition(editor.selection.active)
+				})]
+			}).catch(err => window.showErrorMessage(`jsonnet: failed to format ${err}`));
+			if (!result) {
+				return;
+			}
+
+			await workspace.applyEdit(await client.protocol2CodeConverter.asWorkspaceEdit(result));
+		}),
+		commands.registerCommand('jsonnet.lsp.formatPreview', async function (): Promise<void> {
+			const editor = window.activeTextEditor;
+			if (editor === undefined || editor.document.languageId !== "jsonnet") {
+				return;
+			}
+
+			if (!client.isRunning()) {
+				window.showErrorMessage("jsonnet: cannot preview formatting, language server not running");
+				return;
+			}
+
+			const result: FormatPreviewResult = await client.sendRequest(ExecuteCommandRequest.type, {
+				command: "jsonnet.lsp.formatPreview",
+				arguments: [JSON.stringify({
+					textDocument: { uri: editor.document.uri.toString() },
+					tabSize: typeof editor.options.tabSize === "number" ? editor.options.tabSize : undefined
+				})]
+			}).catch(err => window.showErrorMessage(`jsonnet: failed to preview formatting ${err}`));
+			if (!result) {
+				return;
+			}
+			if (!result.diff) {
+				window.showInformationMessage("jsonnet: file is already formatted");
+				return;
+			}
+
+			const doc = await workspace.openTextDocument({ language: "diff", content: result.diff });
+			await window.showTextDocument(doc, ViewColumn.Beside, true);
+		}),
+		commands.registerCommand('jsonnet.lsp.exportSchema', async function (): Promise<void> {
+			const editor = window.activeTextEditor;
+			if (editor === undefined || editor.document.languageId !== "jsonnet") {
+				return;
+			}
+
+			if (!client.isRunning()) {
+				window.showErrorMessage("jsonnet: cannot export schema, language server not running");
+				return;
+			}
+
+			const schema = await client.sendRequest(ExecuteCommandRequest.type, {
+				command: "jsonnet.lsp.exportSchema",
+				arguments: [JSON.stringify({ textDocument: { uri: editor.document.uri.toString() } })]
+			}).catch(err => window.showErrorMessage(`jsonnet: failed to export schema ${err}`));
+			if (!schema) {
+				return;
+			}
+
+			const doc = await workspace.openTextDocument({ language: "json", content: JSON.stringify(schema, null, 2) });
+			await window.showTextDocument(doc, ViewColumn.Beside, true);
+		}),
+		...[["jsonnet.lsp.toggleLinter", "linter"], ["jsonnet.lsp.toggleEvaluate", "evaluation"]].map(([command, name]) =>
+			commands.registerCommand(command, async function (): Promise<void> {
+				if (!client.isRunning()) {
+					window.showErrorMessage("jsonnet: cannot toggle diagnostics, language server not running");
+					return;
+				}
+
+				await client.sendRequest(ExecuteCommandRequest.type, { command, arguments: [] }).then(
+					enabled => window.showInformationMessage(`jsonnet: ${name} diagnostics ${enabled ? "enabled" : "disabled"}`),
+					err => window.showErrorMessage(`jsonnet: failed to toggle ${name} ${err}`));
+			})
+		)
 	);
 
 	await client.sendNotification(DidChangeConfigurationNotification.type, {settings: cfg});
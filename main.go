@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"os"
 	"sort"
@@ -16,7 +17,9 @@ type cmd struct {
 }
 
 var subcommands = map[string]cmd{
-	"lsp": {Fn: doLSP, Help: "Run the jsonnet language server. Uses stdin/stdout for communication."},
+	"lsp":    {Fn: doLSP, Help: "Run the jsonnet language server. Uses stdin/stdout for communication."},
+	"doctor": {Fn: doDoctor, Help: "Report the workspace the server would detect, and where the imports of a file resolve. Usage: doctor [-root dir] [-jpath dir]... [file]"},
+	"lint":   {Fn: doLint, Help: "Report the linter diagnostics of files, as text or SARIF. Usage: lint [-root dir] [-jpath dir]... [-format text|sarif] file..."},
 }
 
 func fmtUsage(cmds map[string]cmd) string {
@@ -59,6 +62,69 @@ func doLSP(args []string) error {
 	return lsp.RunServer(ctx, oldout)
 }
 
+// stringList is a flag that can be given multiple times
+type stringList []string
+
+func (l *stringList) String() string     { return strings.Join(*l, ",") }
+func (l *stringList) Set(v string) error { *l = append(*l, v); return nil }
+
+// stringMap is a `key=value` flag that can be given multiple times
+type stringMap map[string]string
+
+func (m *stringMap) String() string {
+	res := []string{}
+	for k, v := range *m {
+		res = append(res, k+"="+v)
+	}
+	sort.Strings(res)
+	return strings.Join(res, ",")
+}
+
+func (m *stringMap) Set(v string) error {
+	key, value, ok := strings.Cut(v, "=")
+	if !ok {
+		return fmt.Errorf("expected key=value, got '%s'", v)
+	}
+	if *m == nil {
+		*m = stringMap{}
+	}
+	(*m)[key] = value
+	return nil
+}
+
+func doDoctor(args []string) error {
+	params := lsp.DoctorParams{}
+	flags := flag.NewFlagSet("doctor", flag.ContinueOnError)
+	flags.StringVar(&params.Root, "root", "", "workspace root (default: nearest directory with a root marker)")
+	flags.Var((*stringList)(&params.JPaths), "jpath", "additional import search path, can be repeated")
+	flags.Var((*stringMap)(&params.ImportRoots), "import-root", "map absolute imports with a prefix to a directory, as prefix=dir, can be repeated")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() > 1 {
+		return fmt.Errorf("expected at most one file, got %d", flags.NArg())
+	}
+	params.File = flags.Arg(0)
+	return lsp.Doctor(os.Stdout, params)
+}
+
+func doLint(args []string) error {
+	params := lsp.LintParams{}
+	flags := flag.NewFlagSet("lint", flag.ContinueOnError)
+	flags.StringVar(&params.Root, "root", "", "workspace root (default: nearest directory with a root marker)")
+	flags.Var((*stringList)(&params.JPaths), "jpath", "additional import search path, can be repeated")
+	flags.Var((*stringMap)(&params.ImportRoots), "import-root", "map absolute imports with a prefix to a directory, as prefix=dir, can be repeated")
+	flags.StringVar(&params.Format, "format", lsp.LintFormatText, "output format, text or sarif")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		return fmt.Errorf("expected at least one file")
+	}
+	params.Files = flags.Args()
+	return lsp.Lint(os.Stdout, params)
+}
+
 func main() {
 	if err := dispatch(os.Args[1:], subcommands); err != nil {
 		fmt.Fprintf(os.Stderr, "error: %v\n", err)
@@ -102,6 +102,13 @@ func walkStack(node ast.Node, stk []ast.Node, fn func(n ast.Node, stk []ast.Node
 		walkStack(a.Body, stk, fn)
 	case *ast.InSuper:
 		walkStack(a.Index, stk, fn)
+	case *ast.Slice:
+		// only present in ASTs that are not desugared, slices desugar to `$std.slice(...)`
+		for _, n := range []ast.Node{a.Target, a.BeginIndex, a.EndIndex, a.Step} {
+			if n != nil {
+				walkStack(n, stk, fn)
+			}
+		}
 	case *ast.SuperIndex:
 		walkStack(a.Index, stk, fn)
 	case *ast.Index:
@@ -140,17 +147,7 @@ func walkStack(node ast.Node, stk []ast.Node, fn func(n ast.Node, stk []ast.Node
 }
 
 func locInNode(n ast.Node, pos ast.Location) bool {
-	start, end := n.Loc().Begin, n.Loc().End
-	if pos.Line < start.Line || pos.Line > end.Line {
-		return false
-	}
-	if pos.Line == start.Line && pos.Column < start.Column {
-		return false
-	}
-	if pos.Line == end.Line && pos.Column > end.Column {
-		return false
-	}
-	return true
+	return LocInRange(*n.Loc(), pos)
 }
 
 func unwindLocals(root ast.Node, locs []ast.Node) ([]ast.Node, ast.Node) {
@@ -188,6 +185,14 @@ func StackAtLoc(root ast.Node, loc ast.Location) (res []ast.Node) {
 			if !locInNode(nt, loc) && !locInNode(nt.Body, loc) {
 				return false
 			}
+		case *ast.Conditional:
+			// Desugared assertions have no location, but the rest of the file is in their branches
+			if !nt.LocRange.IsSet() {
+				return true
+			}
+			if !locInNode(n, loc) {
+				return false
+			}
 		default:
 			if !locInNode(n, loc) {
 				return false
@@ -213,6 +218,71 @@ func StackAtNode(root ast.Node, find ast.Node) (found []ast.Node) {
 	return StackAtLoc(root, loc)
 }
 
+// NodeRange returns the location range of a node. Functions defined as object fields
+// do not have a location, so the range of their body is used instead.
+func NodeRange(n ast.Node) ast.LocationRange {
+	if n == nil || n.Loc() == nil {
+		return ast.LocationRange{}
+	}
+	if fn, ok := n.(*ast.Function); ok && !fn.LocRange.IsSet() && fn.Body != nil && fn.Body.Loc() != nil {
+		return *fn.Body.Loc()
+	}
+	return *n.Loc()
+}
+
+// FieldNameAt finds the field of an object whose name is at `loc`. Identifier field names are
+// desugared without a location, so the name is taken as the start of the field up to its body.
+func FieldNameAt(obj *ast.DesugaredObject, loc ast.Location) *ast.DesugaredObjectField {
+	for i := range obj.Fields {
+		fld := &obj.Fields[i]
+		if !fld.LocRange.IsSet() || !LocInRange(fld.LocRange, loc) {
+			continue
+		}
+		if body := NodeRange(fld.Body); body.IsSet() && LocInRange(body, loc) {
+			continue
+		}
+		return fld
+	}
+	return nil
+}
+
+// NamedArgAt returns the named argument of the call whose name is at the location. Argument names
+// have no location in the AST, so the name is taken to be anything between the end of the
+// previous argument and the start of the argument's value.
+func NamedArgAt(call *ast.Apply, loc ast.Location) *ast.NamedArgument {
+	prevEnd := NodeRange(call.Target).End
+	if n := len(call.Arguments.Positional); n > 0 {
+		prevEnd = NodeRange(call.Arguments.Positional[n-1].Expr).End
+	}
+	for i := range call.Arguments.Named {
+		arg := &call.Arguments.Named[i]
+		argRange := NodeRange(arg.Arg)
+		if !argRange.IsSet() {
+			return nil
+		}
+		if LocInRange(ast.LocationRange{Begin: prevEnd, End: argRange.Begin}, loc) {
+			return arg
+		}
+		prevEnd = argRange.End
+	}
+	return nil
+}
+
+// LocInRange checks if the position is within the range (inclusive)
+func LocInRange(r ast.LocationRange, pos ast.Location) bool {
+	start, end := r.Begin, r.End
+	if pos.Line < start.Line || pos.Line > end.Line {
+		return false
+	}
+	if pos.Line == start.Line && pos.Column < start.Column {
+		return false
+	}
+	if pos.Line == end.Line && pos.Column > end.Column {
+		return false
+	}
+	return true
+}
+
 type VarMap map[string]*Var
 
 func (v VarMap) Names() []string {
@@ -238,6 +308,8 @@ type Var struct {
 	Loc  ast.LocationRange
 	Node ast.Node
 	Type ValueType
+	// Set for function parameters, where Node is only the default of the parameter
+	Param bool
 	// The position in the stack, used for sorting most
 	// relevant autocomplete responses.
 	StackPos int
@@ -280,6 +352,13 @@ func StackVars(stk []ast.Node) VarMap {
 				firstObject = n
 			}
 			res["self"] = &Var{Name: "self", Loc: n.LocRange, Node: n, Type: ObjectType}
+			// `super` is only known when the object is the right hand side of a merge
+			delete(res, "super")
+			if pos > 0 {
+				if merge, ok := stk[pos-1].(*ast.Binary); ok && merge.Op == ast.BopPlus && merge.Right == n {
+					res["super"] = &Var{Name: "super", Loc: *merge.Left.Loc(), Node: merge.Left, Type: ObjectType}
+				}
+			}
 		case *ast.Function:
 			for _, p := range n.Parameters {
 				name := string(p.Name)
@@ -287,6 +366,7 @@ func StackVars(stk []ast.Node) VarMap {
 					Name:     name,
 					Loc:      p.LocRange,
 					Node:     p.DefaultArg,
+					Param:    true,
 					StackPos: pos,
 				}
 			}
@@ -0,0 +1,77 @@
+package analysis
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/google/go-jsonnet"
+)
+
+// FSImporter imports data from the filesystem.
+type FSImporter struct {
+	FS      fs.FS
+	JPaths  []string
+	fsCache map[string]*fsCacheEntry
+}
+
+type fsCacheEntry struct {
+	contents jsonnet.Contents
+	exists   bool
+}
+
+func (importer *FSImporter) tryPath(dir, importedPath string) (found bool, contents jsonnet.Contents, foundHere string, err error) {
+	if importer.fsCache == nil {
+		importer.fsCache = make(map[string]*fsCacheEntry)
+	}
+	var absPath string
+	if filepath.IsAbs(importedPath) {
+		absPath = importedPath
+	} else {
+		absPath = filepath.Join(dir, importedPath)
+	}
+	var entry *fsCacheEntry
+	if cacheEntry, isCached := importer.fsCache[absPath]; isCached {
+		entry = cacheEntry
+	} else {
+		contentBytes, err := fs.ReadFile(importer.FS, absPath)
+		if err != nil {
+			if os.IsNotExist(err) {
+				entry = &fsCacheEntry{
+					exists: false,
+				}
+			} else {
+				return false, jsonnet.Contents{}, "", err
+			}
+		} else {
+			entry = &fsCacheEntry{
+				exists:   true,
+				contents: jsonnet.MakeContentsRaw(contentBytes),
+			}
+		}
+		importer.fsCache[absPath] = entry
+	}
+	return entry.exists, entry.contents, absPath, nil
+}
+
+// This is copied from go-jsonnet and modified to support fs.FS
+func (importer *FSImporter) Import(importedFrom, importedPath string) (contents jsonnet.Contents, foundAt string, err error) {
+	dir, _ := filepath.Split(importedFrom)
+	found, content, foundHere, err := importer.tryPath(dir, importedPath)
+	if err != nil {
+		return jsonnet.Contents{}, "", err
+	}
+
+	for i := len(importer.JPaths) - 1; !found && i >= 0; i-- {
+		found, content, foundHere, err = importer.tryPath(importer.JPaths[i], importedPath)
+		if err != nil {
+			return jsonnet.Contents{}, "", err
+		}
+	}
+
+	if !found {
+		return jsonnet.Contents{}, "", fmt.Errorf("couldn't open import %#v: no match locally or in the Jsonnet library paths", importedPath)
+	}
+	return content, foundHere, nil
+}
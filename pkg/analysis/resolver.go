@@ -0,0 +1,107 @@
+package analysis
+
+import (
+	"fmt"
+	"io/fs"
+
+	"github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/ast"
+)
+
+// ImportFunc returns the AST of the file at `path` imported from the file `from`, or nil if it
+// cannot be imported.
+type ImportFunc func(from, path string) ast.Node
+
+// RootResolver resolves nodes and variables in a root AST, and in any files imported from it.
+type RootResolver struct {
+	root ast.Node
+	// A map of filenames from node.Loc().Filename to the root AST node
+	// This is used to find the root AST node of any node.
+	roots      map[string]ast.Node
+	stackCache map[ast.Node][]ast.Node
+	importAST  ImportFunc
+	// values of the external variables read with `std.extVar`
+	extVars map[string]string
+}
+
+var _ = (Resolver)(new(RootResolver))
+var _ = (ExtVarResolver)(new(RootResolver))
+
+func NewRootResolver(root ast.Node, importAST ImportFunc) *RootResolver {
+	return &RootResolver{
+		root:       root,
+		roots:      map[string]ast.Node{root.Loc().FileName: root},
+		stackCache: map[ast.Node][]ast.Node{},
+		importAST:  importAST,
+	}
+}
+
+// NewFileResolver parses `filename` from `fsys`, and resolves its imports relative to the
+// importing file and then the library paths `jpaths` (the last having the highest precedence).
+// This is the same analysis used by the language server, without an editor.
+func NewFileResolver(fsys fs.FS, jpaths []string, filename string) (*RootResolver, error) {
+	vm := jsonnet.MakeVM()
+	vm.Importer(&FSImporter{FS: fsys, JPaths: jpaths})
+	root, _, err := vm.ImportAST("", filename)
+	if err != nil {
+		return nil, err
+	}
+	return NewRootResolver(root, func(from, path string) ast.Node {
+		node, _, err := vm.ImportAST(from, path)
+		if err != nil {
+			return nil
+		}
+		return node
+	}), nil
+}
+
+// SetExtVars sets the values of the external variables read with `std.extVar`
+func (r *RootResolver) SetExtVars(vars map[string]string) {
+	r.extVars = vars
+}
+
+func (r *RootResolver) ExtVar(name string) (string, bool) {
+	val, ok := r.extVars[name]
+	return val, ok
+}
+
+// Root is the AST of the file being resolved
+func (r *RootResolver) Root() ast.Node {
+	return r.root
+}
+
+func (r *RootResolver) NodeAt(loc ast.Location) (node ast.Node, stack []ast.Node) {
+	stack = StackAtLoc(r.root, loc)
+	if len(stack) == 0 {
+		return nil, nil
+	}
+	node = stack[len(stack)-1]
+	r.stackCache[node] = stack
+	return node, stack
+}
+
+func (r *RootResolver) Vars(from ast.Node) VarMap {
+	if from == nil || from.Loc() == nil {
+		return VarMap{}
+	}
+	root := r.roots[from.Loc().FileName]
+	if root == nil {
+		panic(fmt.Errorf("invariant: resolving var from %s where no root was imported", FmtNode(from)))
+	}
+	if stk := r.stackCache[from]; len(stk) > 0 {
+		return StackVars(stk)
+	}
+	stk := StackAtNode(root, from)
+	return StackVars(stk)
+}
+
+func (r *RootResolver) Import(from, path string) ast.Node {
+	if r.importAST == nil {
+		return nil
+	}
+	root := r.importAST(from, path)
+	if root != nil {
+		r.roots[root.Loc().FileName] = root
+	}
+	return root
+}
@@ -0,0 +1,38 @@
+package analysis_test
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/carlverge/jsonnet-lsp/pkg/analysis"
+	"github.com/google/go-jsonnet/ast"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileResolver(t *testing.T) {
+	fsys := fstest.MapFS{
+		"app/main.jsonnet":          {Data: []byte("local util = import 'util.libsonnet';\nutil.config\n")},
+		"vendor/util.libsonnet":     {Data: []byte("{config: {port: 8080}}\n")},
+		"app/broken.jsonnet":        {Data: []byte("{")},
+		"app/missing_imp.jsonnet":   {Data: []byte("import 'missing.libsonnet'")},
+		"vendor/unused.libsonnet":   {Data: []byte("{}")},
+		"vendor/nested/x.libsonnet": {Data: []byte("{}")},
+	}
+
+	resolver, err := analysis.NewFileResolver(fsys, []string{"vendor"}, "app/main.jsonnet")
+	require.NoError(t, err)
+
+	node, _ := resolver.NodeAt(ast.Location{Line: 2, Column: 7})
+	val := analysis.NodeToValue(node, resolver)
+	require.NotNil(t, val.Object)
+	assert.Equal(t, "port", val.Object.Fields[0].Name)
+	assert.Equal(t, "vendor/util.libsonnet", val.Range.FileName)
+
+	_, err = analysis.NewFileResolver(fsys, nil, "app/broken.jsonnet")
+	assert.Error(t, err)
+
+	resolver, err = analysis.NewFileResolver(fsys, nil, "app/missing_imp.jsonnet")
+	require.NoError(t, err)
+	assert.Nil(t, resolver.Import("app/missing_imp.jsonnet", "missing.libsonnet"))
+}
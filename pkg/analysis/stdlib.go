@@ -3,6 +3,7 @@ package analysis
 import (
 	"sort"
 
+	"github.com/carlverge/jsonnet-lsp/pkg/typing/annotation"
 	"github.com/google/go-jsonnet/ast"
 )
 
@@ -726,6 +727,25 @@ var StdLibFunctions = map[string]*Function{
 	"acos":     {ReturnType: NumberType, Params: []Param{{Name: "x", Type: NumberType}}},
 	"atan":     {ReturnType: NumberType, Params: []Param{{Name: "x", Type: NumberType}}},
 	"round":    {ReturnType: NumberType, Params: []Param{{Name: "x", Type: NumberType}}},
+
+	"deepJoin":            {Comment: []string{"Concatenates a nested array of strings into a single string."}, ReturnType: StringType, Params: []Param{{Name: "arr", Type: ArrayType}}},
+	"equals":              {Comment: []string{"Returns whether `a` and `b` are equal, the same as `a == b`."}, ReturnType: BooleanType, Params: []Param{{Name: "a"}, {Name: "b"}}},
+	"escapeStringXML":     {Comment: []string{"Convert `str` to allow it to be embedded in XML (or HTML)."}, ReturnType: StringType, Params: []Param{{Name: "str", Type: StringType}}},
+	"id":                  {Comment: []string{"The identity function, returns `x`."}, ReturnType: AnyType, Params: []Param{{Name: "x"}}},
+	"isEmpty":             {Comment: []string{"Returns true if the given string is of zero length."}, ReturnType: BooleanType, Params: []Param{{Name: "str", Type: StringType}}},
+	"manifestJson":        {Comment: []string{"Convert the given object to a JSON form, indented with four spaces."}, ReturnType: StringType, Params: []Param{{Name: "value"}}},
+	"manifestToml":        {Comment: []string{"Convert the given object to a TOML form, indented with two spaces."}, ReturnType: StringType, Params: []Param{{Name: "toml", Type: ObjectType}}},
+	"modulo":              {Comment: []string{"The modulo of `x` by `y`, the same as `x % y` for numbers."}, ReturnType: NumberType, Params: []Param{{Name: "x", Type: NumberType}, {Name: "y", Type: NumberType}}},
+	"native":              {Comment: []string{"Returns the native function registered with the given name."}, ReturnType: FunctionType, Params: []Param{{Name: "name", Type: StringType}}},
+	"objectFieldsEx":      {Comment: []string{"Returns the fields of the object, including hidden fields if `hidden` is true."}, ReturnType: ArrayType, Params: []Param{{Name: "obj", Type: ObjectType}, {Name: "hidden", Type: BooleanType}}},
+	"objectHasEx":         {Comment: []string{"Returns whether the object has the field, including hidden fields if `hidden` is true."}, ReturnType: BooleanType, Params: []Param{{Name: "obj", Type: ObjectType}, {Name: "fname", Type: StringType}, {Name: "hidden", Type: BooleanType}}},
+	"objectKeysValues":    {Comment: []string{"Returns an array of objects from the given object, each object having two fields: `key` (string) and `value` (object). Does not include hidden fields."}, ReturnType: ArrayType, Params: []Param{{Name: "o", Type: ObjectType}}},
+	"objectKeysValuesAll": {Comment: []string{"As `std.objectKeysValues` but also includes hidden fields."}, ReturnType: ArrayType, Params: []Param{{Name: "o", Type: ObjectType}}},
+	"primitiveEquals":     {Comment: []string{"Returns whether two primitive (non-object, non-array) values are equal."}, ReturnType: BooleanType, Params: []Param{{Name: "a"}, {Name: "b"}}},
+	"resolvePath":         {Comment: []string{"Replaces the last segment of the path `f` with `r`."}, ReturnType: StringType, Params: []Param{{Name: "f", Type: StringType}, {Name: "r", Type: StringType}}},
+	"sum":                 {Comment: []string{"Return sum of all element in `arr`."}, ReturnType: NumberType, Params: []Param{{Name: "arr", Type: ArrayType}}},
+	"xnor":                {Comment: []string{"Returns the xnor of the two given booleans."}, ReturnType: BooleanType, Params: []Param{{Name: "x", Type: BooleanType}, {Name: "y", Type: BooleanType}}},
+	"xor":                 {Comment: []string{"Returns the xor of the two given booleans."}, ReturnType: BooleanType, Params: []Param{{Name: "x", Type: BooleanType}, {Name: "y", Type: BooleanType}}},
 }
 
 var StdLibValue = func(fns map[string]*Function) *Value {
@@ -754,3 +774,33 @@ var StdLibValue = func(fns map[string]*Function) *Value {
 	}
 	return res
 }(StdLibFunctions)
+
+// CallParams returns the parameters of the function for the call. Stdlib functions with parameter
+// types that depend on the other arguments are specialized: the `arr` of `std.join(sep, arr)` is
+// an `array[string]` if `sep` is a string, or an `array[array]` if `sep` is an array.
+func CallParams(fn *Function, call *ast.Apply, resolver Resolver) []Param {
+	if fn == nil {
+		return nil
+	}
+	if fn != StdLibFunctions["join"] {
+		return fn.Params
+	}
+	sep := callArgument(call, fn, "sep")
+	if sep == nil {
+		return fn.Params
+	}
+	sepType := NodeToValue(sep, resolver).Type
+	var elem annotation.Node
+	switch sepType {
+	case StringType:
+		elem = &annotation.StringNode{}
+	case ArrayType:
+		elem = &annotation.ArrayNode{}
+	default:
+		return fn.Params
+	}
+	params := append([]Param{}, fn.Params...)
+	params[0].Type = sepType
+	params[1].TypeHint = &annotation.ArrayNode{ElementType: elem}
+	return params
+}
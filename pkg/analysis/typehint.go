@@ -0,0 +1,149 @@
+package analysis
+
+import (
+	"strings"
+
+	"github.com/carlverge/jsonnet-lsp/pkg/typing/annotation"
+	"github.com/google/go-jsonnet/ast"
+)
+
+// maxTypeHintDepth limits how deep into nested arrays and objects values are compared to hints
+const maxTypeHintDepth = 5
+
+// CommentsToTypeHint parses the first `/*:type*/` annotation in the comments, or returns nil
+func CommentsToTypeHint(comments []string) annotation.Node {
+	for _, c := range comments {
+		if !(strings.HasPrefix(c, "/*:") && strings.HasSuffix(c, "*/")) {
+			continue
+		}
+		typeComment := strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(c, "/*:"), "*/"))
+		if hint, err := annotation.Parse(typeComment); err == nil && hint != nil {
+			return hint
+		}
+	}
+	return nil
+}
+
+// ElementType returns the type of the elements of an `array[type]` hint, if it is a simple type
+func ElementType(hint annotation.Node) ValueType {
+	arr, ok := hint.(*annotation.ArrayNode)
+	if !ok {
+		return AnyType
+	}
+	switch arr.ElementType.(type) {
+	case *annotation.StringNode:
+		return StringType
+	case *annotation.NumberNode:
+		return NumberType
+	case *annotation.BooleanNode:
+		return BooleanType
+	case *annotation.NullNode:
+		return NullType
+	case *annotation.FunctionNode:
+		return FunctionType
+	case *annotation.ArrayNode:
+		return ArrayType
+	case *annotation.ObjectNode:
+		return ObjectType
+	}
+	return AnyType
+}
+
+// CheckTypeHint compares a value to a type hint. If the value does not match, returns false and
+// a description of the value's type (like `array[number]`). Values and hints that cannot be
+// resolved statically, like type parameters and named types, always match.
+func CheckTypeHint(v *Value, hint annotation.Node, resolver Resolver) (got string, ok bool) {
+	if isSubtypeOf(v, hint, resolver, 0) {
+		return "", true
+	}
+	return describeValueType(v, resolver, 0), false
+}
+
+func isSubtypeOf(v *Value, hint annotation.Node, resolver Resolver, depth int) bool {
+	if v == nil || hint == nil || v.Type == AnyType || depth > maxTypeHintDepth {
+		return true
+	}
+
+	switch hint := hint.(type) {
+	case *annotation.StringNode:
+		return v.Type == StringType
+	case *annotation.NumberNode:
+		return v.Type == NumberType
+	case *annotation.BooleanNode:
+		return v.Type == BooleanType
+	case *annotation.NullNode:
+		return v.Type == NullType
+	case *annotation.FunctionNode:
+		return v.Type == FunctionType
+	case *annotation.UnionNode:
+		for _, t := range hint.Types {
+			if isSubtypeOf(v, t, resolver, depth) {
+				return true
+			}
+		}
+		return false
+	case *annotation.ArrayNode:
+		if v.Type != ArrayType {
+			return false
+		}
+		arr, _ := v.Node.(*ast.Array)
+		if hint.ElementType == nil || arr == nil {
+			return true
+		}
+		for _, elem := range arr.Elements {
+			if !isSubtypeOf(nodeToValue(elem.Expr, resolver, depth+1), hint.ElementType, resolver, depth+1) {
+				return false
+			}
+		}
+		return true
+	case *annotation.ObjectNode:
+		if v.Type != ObjectType {
+			return false
+		}
+		if v.Object == nil {
+			return true
+		}
+		if hint.ElementType != nil {
+			for _, fld := range v.Object.Fields {
+				if !isSubtypeOf(nodeToValue(fld.Node, resolver, depth+1), hint.ElementType, resolver, depth+1) {
+					return false
+				}
+			}
+		}
+		for _, hintFld := range hint.Fields {
+			fld := v.Object.FieldMap[hintFld.Name]
+			if fld == nil {
+				continue
+			}
+			if !isSubtypeOf(nodeToValue(fld.Node, resolver, depth+1), hintFld.Type, resolver, depth+1) {
+				return false
+			}
+		}
+		return true
+	default:
+		// `any`, type parameters, and references to named types
+		return true
+	}
+}
+
+// describeValueType formats the type of a value, including the element type of arrays where
+// every element has the same type
+func describeValueType(v *Value, resolver Resolver, depth int) string {
+	arr, _ := v.Node.(*ast.Array)
+	if v.Type != ArrayType || arr == nil || len(arr.Elements) == 0 || depth > maxTypeHintDepth {
+		return v.Type.String()
+	}
+
+	elemType := ""
+	for _, elem := range arr.Elements {
+		t := describeValueType(nodeToValue(elem.Expr, resolver, depth+1), resolver, depth+1)
+		if elemType != "" && t != elemType {
+			return v.Type.String()
+		}
+		elemType = t
+	}
+	if elemType == AnyType.String() {
+		return v.Type.String()
+	}
+	return v.Type.String() + "[" + elemType + "]"
+}
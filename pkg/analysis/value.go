@@ -2,9 +2,14 @@ package analysis
 
 import (
 	"fmt"
+	"io"
+	"math"
+	"sort"
 	"strconv"
 	"strings"
 
+	"github.com/carlverge/jsonnet-lsp/pkg/typing/annotation"
+	"github.com/google/go-jsonnet"
 	"github.com/google/go-jsonnet/ast"
 )
 
@@ -72,7 +77,9 @@ type Param struct {
 	Comment []string          `json:"comment,omitempty"`
 	Range   ast.LocationRange `json:"-"`
 	Type    ValueType         `json:"type"`
-	Default ast.Node          `json:"-"`
+	// TypeHint is the full `/*:type*/` annotation of the parameter, if any
+	TypeHint annotation.Node `json:"-"`
+	Default  ast.Node        `json:"-"`
 }
 
 func (p *Param) String() string {
@@ -114,7 +121,11 @@ type Field struct {
 	Range   ast.LocationRange `json:"-"`
 	Comment []string          `json:"comment,omitempty"`
 	Hidden  bool              `json:"hidden,omitempty"`
-	Node    ast.Node          `json:"-"`
+	// Only present in some of the objects the value can be, like one branch of a conditional
+	Partial bool     `json:"partial,omitempty"`
+	Node    ast.Node `json:"-"`
+	// Value of fields without a node, like the fields of the elements of `std.objectKeysValues`
+	Value *Value `json:"-"`
 }
 
 type Object struct {
@@ -129,10 +140,17 @@ type Value struct {
 	Comment []string          `json:"comment,omitempty"`
 	Node    ast.Node          `json:"-"`
 
-	StringValue *string
+	// Statically known values of literals and constant expressions
+	StringValue  *string
+	NumberValue  *float64
+	BooleanValue *bool
 
 	Object   *Object   `json:"object,omitempty"`
 	Function *Function `json:"function,omitempty"`
+	// Element is the value of every element of an array, if they are all alike
+	Element *Value `json:"-"`
+	// Elements are the values of an array without a node, like the documents of `std.parseYaml`
+	Elements []*Value `json:"-"`
 }
 
 func foddersToComment(node ast.Node, fodders ...ast.Fodder) []string {
@@ -189,17 +207,69 @@ func functionToValue(node *ast.Function) *Value {
 		}
 
 		res.Function.Params[i] = Param{
-			Name:    string(param.Name),
-			Default: param.DefaultArg,
-			Range:   param.LocRange,
-			Comment: comments,
-			Type:    commentsToType(comments),
+			Name:     string(param.Name),
+			Default:  param.DefaultArg,
+			Range:    param.LocRange,
+			Comment:  comments,
+			Type:     commentsToType(comments),
+			TypeHint: CommentsToTypeHint(comments),
 		}
 	}
 
 	return res
 }
 
+// IsStdCall checks if the call is to `$std.name`, which syntax is desugared to. For example
+// slicing `target[begin:end:step]` is desugared to `$std.slice(target, begin, end, step)`.
+func IsStdCall(app *ast.Apply, name string) bool {
+	idx, _ := app.Target.(*ast.Index)
+	if idx == nil {
+		return false
+	}
+	lhs, _ := idx.Target.(*ast.Var)
+	rhs, _ := idx.Index.(*ast.LiteralString)
+	return lhs != nil && lhs.Id == "$std" && rhs != nil && rhs.Value == name
+}
+
+// sliceToValue resolves `target[begin:end:step]`, which is an array or a string like the target
+func sliceToValue(node ast.Node, target ast.Node, resolver Resolver, stackDepth int) *Value {
+	res := defaultToValue(node)
+	if tp := nodeToValue(target, resolver, stackDepth+1).Type; tp == ArrayType || tp == StringType {
+		res.Type = tp
+	}
+	return res
+}
+
+// indexFieldToValue resolves the field `name` of the target of an index
+func indexFieldToValue(node *ast.Index, target *Value, name string, resolver Resolver, stackDepth int) *Value {
+	// Hardcoded access of stdlib
+	if target == StdLibValue {
+		stdfn := StdLibFunctions[name]
+		if stdfn != nil {
+			return &Value{Type: FunctionType, Comment: stdfn.Comment, Function: stdfn}
+		}
+		return defaultToValue(node)
+	}
+
+	// object dotted access
+	if target.Object != nil && target.Object.FieldMap[name] != nil {
+		return fieldToValue(target.Object.FieldMap[name], resolver, stackDepth+1)
+	}
+	return defaultToValue(node)
+}
+
+func fieldToValue(fld *Field, resolver Resolver, stackDepth int) *Value {
+	switch {
+	case fld.Node != nil:
+		return nodeToValue(fld.Node, resolver, stackDepth)
+	case fld.Value != nil:
+		return fld.Value
+	default:
+		// only the type is known, like fields with a different value in each branch
+		return &Value{Type: fld.Type}
+	}
+}
+
 func objectToValue(node *ast.DesugaredObject, resolver Resolver) *Value {
 	res := &Value{
 		Type:    ObjectType,
@@ -282,8 +352,11 @@ func simpleToValueType(node ast.Node) (typ ValueType, isLeaf bool) {
 	switch node := node.(type) {
 	case *ast.LiteralNull:
 		return NullType, true
-	case *ast.ImportStr, *ast.ImportBin:
+	case *ast.ImportStr:
 		return StringType, true
+	case *ast.ImportBin:
+		// importbin is an array of bytes, see nodeToValue for the type of its elements
+		return ArrayType, false
 	case *ast.Apply:
 		return knownApply(node)
 	case *ast.Binary:
@@ -291,6 +364,8 @@ func simpleToValueType(node ast.Node) (typ ValueType, isLeaf bool) {
 			return kt, true
 		}
 		return AnyType, false
+	case *ast.InSuper:
+		return BooleanType, true
 	case *ast.LiteralBoolean:
 		return BooleanType, false
 	case *ast.LiteralNumber:
@@ -335,7 +410,7 @@ func defaultToValue(node ast.Node) *Value {
 		Comment: foddersToComment(node),
 	}
 	res.Type, _ = simpleToValueType(node)
-	if node.Loc() != nil {
+	if node != nil && node.Loc() != nil {
 		res.Range = *node.Loc()
 	}
 	return res
@@ -353,16 +428,19 @@ func mergeObjectValues(lhs, rhs *Value) *Value {
 			AllFieldsKnown: lhs.Object.AllFieldsKnown && rhs.Object.AllFieldsKnown,
 		},
 	}
-	for name, fld := range lhs.Object.FieldMap {
+	// iterate the fields rather than the map to keep the source order of each operand
+	for i := range lhs.Object.Fields {
+		fld := &lhs.Object.Fields[i]
 		// add only if not in the RHS
-		if rhv := rhs.Object.FieldMap[name]; rhv == nil {
+		if rhv := rhs.Object.FieldMap[fld.Name]; rhv == nil {
 			res.Object.Fields = append(res.Object.Fields, *fld)
-			res.Object.FieldMap[name] = fld
+			res.Object.FieldMap[fld.Name] = fld
 		}
 	}
-	for name, fld := range rhs.Object.FieldMap {
+	for i := range rhs.Object.Fields {
+		fld := &rhs.Object.Fields[i]
 		res.Object.Fields = append(res.Object.Fields, *fld)
-		res.Object.FieldMap[name] = fld
+		res.Object.FieldMap[fld.Name] = fld
 	}
 	return res
 }
@@ -376,6 +454,12 @@ type Resolver interface {
 	Import(from, path string) ast.Node
 }
 
+// ExtVarResolver is implemented by resolvers that know the values of external variables, to
+// resolve `std.extVar(name)` to a constant string
+type ExtVarResolver interface {
+	ExtVar(name string) (string, bool)
+}
+
 var maxStackDepth = 300
 
 func nodeToValue(node ast.Node, resolver Resolver, stackDepth int) (res *Value) {
@@ -405,18 +489,23 @@ func nodeToValue(node ast.Node, resolver Resolver, stackDepth int) (res *Value)
 			StringValue: &node.Value,
 		}
 	case *ast.LiteralNumber:
-		return &Value{
+		res := &Value{
 			Type:    NumberType,
 			Node:    node,
 			Range:   node.LocRange,
 			Comment: []string{node.OriginalString},
 		}
+		if num, err := strconv.ParseFloat(node.OriginalString, 64); err == nil {
+			res.NumberValue = &num
+		}
+		return res
 	case *ast.LiteralBoolean:
 		return &Value{
-			Type:    BooleanType,
-			Node:    node,
-			Range:   node.LocRange,
-			Comment: []string{strconv.FormatBool(node.Value)},
+			Type:         BooleanType,
+			Node:         node,
+			Range:        node.LocRange,
+			Comment:      []string{strconv.FormatBool(node.Value)},
+			BooleanValue: &node.Value,
 		}
 	case *ast.Local:
 		// ignore varbinds when getting the value
@@ -432,24 +521,89 @@ func nodeToValue(node ast.Node, resolver Resolver, stackDepth int) (res *Value)
 
 		v := resolver.Vars(node).Get(string(node.Id))
 		if v != nil && v.Node != nil {
+			if selfReferencing(node, v.Node) {
+				return defaultToValue(node)
+			}
 			return nodeToValue(v.Node, resolver, stackDepth + 1)
 		}
 		return defaultToValue(node)
+	case *ast.Self:
+		// fields are lazy, so `self` has every field of the object regardless of order
+		v := resolver.Vars(node).Get("self")
+		if v == nil || v.Node == nil {
+			return defaultToValue(node)
+		}
+		res := nodeToValue(v.Node, resolver, stackDepth+1)
+		if res.Object != nil {
+			// objects inheriting from this one may add fields to `self`
+			cpy, obj := *res, *res.Object
+			obj.AllFieldsKnown = false
+			cpy.Object = &obj
+			res = &cpy
+		}
+		return res
+	case *ast.SuperIndex:
+		// `super` is the left hand side of the merge the object is in
+		v := resolver.Vars(node).Get("super")
+		idx, _ := node.Index.(*ast.LiteralString)
+		if v == nil || v.Node == nil || idx == nil {
+			return defaultToValue(node)
+		}
+		sup := nodeToValue(v.Node, resolver, stackDepth+1)
+		if sup.Object == nil || sup.Object.FieldMap[idx.Value] == nil {
+			return defaultToValue(node)
+		}
+		return nodeToValue(sup.Object.FieldMap[idx.Value].Node, resolver, stackDepth+1)
 	case *ast.Apply:
 		targfn := nodeToValue(node.Target, resolver, stackDepth + 1)
+		if fn := targfn.Function; fn != nil && (fn == StdLibFunctions["foldl"] || fn == StdLibFunctions["foldr"]) {
+			return foldToValue(node, fn, resolver, stackDepth)
+		}
+		if fn := targfn.Function; fn != nil && fn == StdLibFunctions["extVar"] {
+			if res := extVarToValue(node, fn, resolver, stackDepth); res != nil {
+				return res
+			}
+		}
+		if fn := targfn.Function; fn != nil && fn == StdLibFunctions["parseYaml"] {
+			if res := parseYamlToValue(node, fn, resolver, stackDepth); res != nil {
+				return res
+			}
+		}
+		if fn := targfn.Function; (IsStdCall(node, "slice") || (fn != nil && fn == StdLibFunctions["slice"])) && len(node.Arguments.Positional) > 0 {
+			return sliceToValue(node, node.Arguments.Positional[0].Expr, resolver, stackDepth)
+		}
+		if fn := targfn.Function; fn != nil && (fn == StdLibFunctions["objectValues"] || fn == StdLibFunctions["objectValuesAll"] ||
+			fn == StdLibFunctions["objectKeysValues"] || fn == StdLibFunctions["objectKeysValuesAll"]) {
+			return objectValuesToValue(node, fn, resolver, stackDepth)
+		}
+		if fn := targfn.Function; fn != nil && (fn == StdLibFunctions["prune"] || fn == StdLibFunctions["mergePatch"] || fn == StdLibFunctions["mapWithKey"]) {
+			return objectCallToValue(node, fn, resolver, stackDepth)
+		}
 		if targfn.Function == nil || targfn.Function.Return == nil {
-			return defaultToValue(node)
+			res := defaultToValue(node)
+			// stdlib functions only have a declared return type
+			if targfn.Function != nil && res.Type == AnyType {
+				res.Type = targfn.Function.ReturnType
+			}
+			return res
 		}
 		return nodeToValue(targfn.Function.Return, resolver, stackDepth + 1)
+	case *ast.Slice:
+		return sliceToValue(node, node.Target, resolver, stackDepth)
 	case *ast.Index:
+		target := nodeToValue(node.Target, resolver, stackDepth + 1)
 		switch idx := node.Index.(type) {
 		case *ast.LiteralNumber:
 			// Number index of an array
-
-			target := nodeToValue(node.Target, resolver, stackDepth + 1)
 			idxInt, intErr := strconv.ParseInt(idx.OriginalString, 10, 64)
 			targArr, _ := target.Node.(*ast.Array)
 
+			if targArr == nil && intErr == nil && idxInt >= 0 && int(idxInt) < len(target.Elements) {
+				return target.Elements[idxInt]
+			}
+			if targArr == nil && target.Element != nil {
+				return target.Element
+			}
 			if targArr == nil || intErr != nil || int(idxInt) >= len(targArr.Elements) {
 				return defaultToValue(node)
 			}
@@ -457,20 +611,14 @@ func nodeToValue(node ast.Node, resolver Resolver, stackDepth int) (res *Value)
 			return nodeToValue(targArr.Elements[idxInt].Expr, resolver, stackDepth + 1)
 		case *ast.LiteralString:
 			// String index of an object
-			lhs := nodeToValue(node.Target, resolver, stackDepth + 1)
-
-			// Hardcoded access of stdlib
-			if lhs == StdLibValue {
-				stdfn := StdLibFunctions[idx.Value]
-				if stdfn != nil {
-					return &Value{Type: FunctionType, Comment: stdfn.Comment, Function: stdfn}
-				}
-				return defaultToValue(node)
+			return indexFieldToValue(node, target, idx.Value, resolver, stackDepth)
+		default:
+			// Index by a constant string, like `obj[key]` where `key` is a string local
+			if key := nodeToValue(idx, resolver, stackDepth+1); key.StringValue != nil {
+				return indexFieldToValue(node, target, *key.StringValue, resolver, stackDepth)
 			}
-
-			// object dotted access
-			if lhs.Object != nil && lhs.Object.FieldMap[idx.Value] != nil {
-				return nodeToValue(lhs.Object.FieldMap[idx.Value].Node, resolver, stackDepth + 1)
+			if target.Element != nil {
+				return target.Element
 			}
 		}
 		return defaultToValue(node)
@@ -493,18 +641,516 @@ func nodeToValue(node ast.Node, resolver Resolver, stackDepth int) (res *Value)
 				}
 			}
 		}
+		if res := foldNumbers(node, resolver, stackDepth); res != nil {
+			return res
+		}
 		return defaultToValue(node)
+	case *ast.Unary:
+		return foldUnary(node, resolver, stackDepth)
+	case *ast.Conditional:
+		return conditionalToValue(node, resolver, stackDepth)
 	case *ast.DesugaredObject:
 		return objectToValue(node, resolver)
 	case *ast.Function:
 		return functionToValue(node)
 	case *ast.Import:
 		return importToValue(node, resolver)
+	case *ast.ImportBin:
+		// elements of binary imports are numbers (bytes)
+		res := defaultToValue(node)
+		res.Element = &Value{Type: NumberType}
+		return res
 	default:
 		return defaultToValue(node)
 	}
 }
 
+// conditionalToValue resolves `if c then a else b` to the type of the branches if they agree.
+// Objects resolve to the union of the fields of both branches. A branch raising an error, like
+// a desugared assertion, never produces a value, so the other branch is used.
+func conditionalToValue(node *ast.Conditional, resolver Resolver, stackDepth int) *Value {
+	if _, ok := node.BranchFalse.(*ast.Error); ok {
+		return nodeToValue(node.BranchTrue, resolver, stackDepth+1)
+	}
+	if _, ok := node.BranchTrue.(*ast.Error); ok {
+		return nodeToValue(node.BranchFalse, resolver, stackDepth+1)
+	}
+
+	res := defaultToValue(node)
+	t, f := nodeToValue(node.BranchTrue, resolver, stackDepth+1), nodeToValue(node.BranchFalse, resolver, stackDepth+1)
+	if t.Type != f.Type {
+		return res
+	}
+	res.Type = t.Type
+	if t.Object != nil && f.Object != nil {
+		res.Object = unionObjects(t.Object, f.Object)
+	}
+	return res
+}
+
+// unionObjects returns the fields of either object. Fields missing from one of the objects are
+// marked as partial, and fields of different types in each are of any type. Fields with a
+// different value in each only keep their type, as neither value is the value of the field.
+func unionObjects(lhs, rhs *Object) *Object {
+	res := &Object{
+		FieldMap:       map[string]*Field{},
+		AllFieldsKnown: lhs.AllFieldsKnown && rhs.AllFieldsKnown,
+	}
+	for _, fld := range lhs.Fields {
+		if other := rhs.FieldMap[fld.Name]; other == nil {
+			fld.Partial = true
+		} else if other.Node != fld.Node || other.Value != fld.Value {
+			fld = Field{Name: fld.Name, Type: fld.Type, Hidden: fld.Hidden}
+			if other.Type != fld.Type {
+				fld.Type = AnyType
+			}
+		}
+		res.Fields = append(res.Fields, fld)
+	}
+	for _, fld := range rhs.Fields {
+		if lhs.FieldMap[fld.Name] == nil {
+			fld.Partial = true
+			res.Fields = append(res.Fields, fld)
+		}
+	}
+	for i := range res.Fields {
+		res.FieldMap[res.Fields[i].Name] = &res.Fields[i]
+	}
+	return res
+}
+
+// selfReferencing checks if a variable is used in the body of its own binding, like
+// `local x = x + 1`. Functions and objects are lazy, so they can refer to themselves, but any
+// other recursive binding can never be resolved statically.
+func selfReferencing(v *ast.Var, body ast.Node) bool {
+	switch body.(type) {
+	case *ast.Function, *ast.DesugaredObject:
+		return false
+	}
+	rng := NodeRange(body)
+	return rng.IsSet() && rng.FileName == v.LocRange.FileName && LocInRange(rng, v.LocRange.Begin)
+}
+
+// callArgument returns the argument given for the parameter `name` of `fn`, either positionally
+// or by name
+func callArgument(call *ast.Apply, fn *Function, name string) ast.Node {
+	for i, p := range fn.Params {
+		if p.Name == name && i < len(call.Arguments.Positional) {
+			return call.Arguments.Positional[i].Expr
+		}
+	}
+	for _, arg := range call.Arguments.Named {
+		if string(arg.Name) == name {
+			return arg.Arg
+		}
+	}
+	return nil
+}
+
+// foldToValue resolves `std.foldl(func, arr, init)` and `std.foldr` to the return value of the
+// accumulator function if it is known, otherwise to the type of `init`.
+func foldToValue(call *ast.Apply, fold *Function, resolver Resolver, stackDepth int) *Value {
+	res := defaultToValue(call)
+
+	if fnNode := callArgument(call, fold, "func"); fnNode != nil {
+		accfn := nodeToValue(fnNode, resolver, stackDepth+1)
+		if accfn.Function != nil && accfn.Function.Return != nil {
+			ret := nodeToValue(accfn.Function.Return, resolver, stackDepth+1)
+			if ret.Type != AnyType {
+				return ret
+			}
+		}
+	}
+
+	initNode := callArgument(call, fold, "init")
+	if initNode == nil {
+		return res
+	}
+	init := nodeToValue(initNode, resolver, stackDepth+1)
+	res.Type = init.Type
+	if init.Object != nil {
+		// the accumulator function may add fields to the initial object
+		obj := *init.Object
+		obj.AllFieldsKnown = false
+		res.Object = &obj
+	}
+	return res
+}
+
+// extVarToValue resolves `std.extVar(name)` to the value of the external variable, if the resolver
+// knows it. Returns nil otherwise.
+func extVarToValue(call *ast.Apply, fn *Function, resolver Resolver, stackDepth int) *Value {
+	ext, ok := resolver.(ExtVarResolver)
+	nameNode := callArgument(call, fn, "x")
+	if !ok || nameNode == nil {
+		return nil
+	}
+	name := nodeToValue(nameNode, resolver, stackDepth+1)
+	if name.StringValue == nil {
+		return nil
+	}
+	val, ok := ext.ExtVar(*name.StringValue)
+	if !ok {
+		return nil
+	}
+	return &Value{
+		Type:        StringType,
+		Range:       call.LocRange,
+		Node:        call,
+		StringValue: &val,
+	}
+}
+
+// parseYamlToValue resolves `std.parseYaml(str)` of a constant string to the parsed value. Like
+// the stdlib, a string with `---` separators is a stream and parses to an array of its documents.
+// Returns nil if the string is not constant or is not valid YAML.
+func parseYamlToValue(call *ast.Apply, fn *Function, resolver Resolver, stackDepth int) *Value {
+	strNode := callArgument(call, fn, "str")
+	if strNode == nil {
+		return nil
+	}
+	str := nodeToValue(strNode, resolver, stackDepth+1)
+	if str.StringValue == nil {
+		return nil
+	}
+
+	docs := []interface{}{}
+	dec := jsonnet.NewYAMLToJSONDecoder(strings.NewReader(*str.StringValue))
+	for {
+		var doc interface{}
+		if err := dec.Decode(&doc); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil
+		}
+		docs = append(docs, doc)
+	}
+	if len(docs) == 0 {
+		return nil
+	}
+
+	res := jsonToValue(docs[0], call.LocRange)
+	if strings.Contains(*str.StringValue, "---") {
+		res = jsonToValue(docs, call.LocRange)
+	}
+	res.Node = call
+	return res
+}
+
+// jsonToValue converts a decoded JSON value to a Value, with every part of it at the given range
+func jsonToValue(v interface{}, rng ast.LocationRange) *Value {
+	res := &Value{Type: AnyType, Range: rng}
+	switch v := v.(type) {
+	case nil:
+		res.Type = NullType
+	case bool:
+		res.Type = BooleanType
+		res.BooleanValue = &v
+	case float64:
+		res.Type = NumberType
+		res.NumberValue = &v
+	case string:
+		res.Type = StringType
+		res.StringValue = &v
+	case []interface{}:
+		res.Type = ArrayType
+		for _, elem := range v {
+			res.Elements = append(res.Elements, jsonToValue(elem, rng))
+		}
+	case map[string]interface{}:
+		res.Type = ObjectType
+		res.Object = &Object{AllFieldsKnown: true, FieldMap: map[string]*Field{}}
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			val := jsonToValue(v[k], rng)
+			res.Object.Fields = append(res.Object.Fields, Field{Name: k, Type: val.Type, Range: rng, Value: val})
+		}
+		for i := range res.Object.Fields {
+			res.Object.FieldMap[res.Object.Fields[i].Name] = &res.Object.Fields[i]
+		}
+	}
+	return res
+}
+
+// objectCallToValue resolves the stdlib functions returning an object with the fields of their
+// argument: `std.prune(a)`, `std.mergePatch(target, patch)` and `std.mapWithKey(func, obj)`.
+// These only keep the visible fields of their argument, see pruneToValue and mergePatchToValue.
+func objectCallToValue(call *ast.Apply, fn *Function, resolver Resolver, stackDepth int) *Value {
+	res := defaultToValue(call)
+	res.Type = fn.ReturnType
+	arg := func(name string) *Value {
+		if n := callArgument(call, fn, name); n != nil {
+			return nodeToValue(n, resolver, stackDepth+1)
+		}
+		return nil
+	}
+	visibleFields := func(obj *Object, skip func(fld Field) bool) {
+		for _, fld := range obj.Fields {
+			if !fld.Hidden && !skip(fld) {
+				res.Object.Fields = append(res.Object.Fields, fld)
+			}
+		}
+	}
+
+	switch fn {
+	case StdLibFunctions["prune"]:
+		// empty members are removed, but no fields are added
+		a := arg("a")
+		if a == nil || (a.Type != ObjectType && a.Type != ArrayType) {
+			return res
+		}
+		res.Type = a.Type
+		if a.Object != nil {
+			res.Object = pruneToValue(a, resolver, stackDepth+1).Object
+		}
+		return res
+	case StdLibFunctions["mergePatch"]:
+		patch := arg("patch")
+		if patch == nil || patch.Type == AnyType {
+			return res
+		}
+		merged := mergePatchToValue(arg("target"), patch, resolver, stackDepth+1)
+		res.Type, res.Object = merged.Type, merged.Object
+		return res
+	case StdLibFunctions["mapWithKey"]:
+		obj := arg("obj")
+		if obj == nil || obj.Object == nil {
+			return res
+		}
+		res.Object = &Object{FieldMap: map[string]*Field{}, AllFieldsKnown: obj.Object.AllFieldsKnown}
+		visibleFields(obj.Object, func(Field) bool { return false })
+		// every field is the return value of the function, if it is known
+		var ret ast.Node
+		if f := arg("func"); f != nil && f.Function != nil {
+			ret = f.Function.Return
+		}
+		for i := range res.Object.Fields {
+			fld := &res.Object.Fields[i]
+			fld.Node, fld.Type = ret, AnyType
+			if ret != nil {
+				fld.Type = nodeToValue(ret, resolver, stackDepth+1).Type
+			}
+		}
+	}
+
+	if res.Object != nil {
+		for i := range res.Object.Fields {
+			res.Object.FieldMap[res.Object.Fields[i].Name] = &res.Object.Fields[i]
+		}
+	}
+	return res
+}
+
+// pruneToValue returns the object without its null fields, recursively like `std.prune`. Nested
+// objects left without any field are removed as well.
+func pruneToValue(obj *Value, resolver Resolver, stackDepth int) *Value {
+	res := &Value{Type: ObjectType, Object: &Object{FieldMap: map[string]*Field{}, AllFieldsKnown: obj.Object.AllFieldsKnown}}
+	for _, fld := range obj.Object.Fields {
+		if fld.Hidden {
+			continue
+		}
+		val := fieldToValue(&fld, resolver, stackDepth+1)
+		switch {
+		case val.Type == NullType:
+			continue
+		case val.Type == ObjectType && val.Object != nil:
+			pruned := pruneToValue(val, resolver, stackDepth+1)
+			if pruned.Object.AllFieldsKnown && len(pruned.Object.Fields) == 0 {
+				continue
+			}
+			fld = Field{Name: fld.Name, Type: ObjectType, Range: fld.Range, Comment: fld.Comment, Value: pruned}
+		}
+		res.Object.Fields = append(res.Object.Fields, fld)
+	}
+	for i := range res.Object.Fields {
+		res.Object.FieldMap[res.Object.Fields[i].Name] = &res.Object.Fields[i]
+	}
+	return res
+}
+
+// mergePatchToValue merges the patch into the target like `std.mergePatch` (RFC 7386). A patch
+// that is not an object replaces the target. The fields of an object patch are merged into the
+// visible fields of the target recursively, where null fields remove the field of the target.
+func mergePatchToValue(target, patch *Value, resolver Resolver, stackDepth int) *Value {
+	if patch.Type != ObjectType {
+		return patch
+	}
+	if patch.Object == nil || stackDepth > maxStackDepth {
+		return &Value{Type: ObjectType}
+	}
+
+	res := &Value{Type: ObjectType, Object: &Object{FieldMap: map[string]*Field{}, AllFieldsKnown: patch.Object.AllFieldsKnown}}
+	var targetFields *Object
+	if target != nil && target.Type == ObjectType && target.Object != nil {
+		targetFields = target.Object
+		res.Object.AllFieldsKnown = res.Object.AllFieldsKnown && target.Object.AllFieldsKnown
+		for _, fld := range target.Object.Fields {
+			if !fld.Hidden && patch.Object.FieldMap[fld.Name] == nil {
+				res.Object.Fields = append(res.Object.Fields, fld)
+			}
+		}
+	}
+	for _, fld := range patch.Object.Fields {
+		if fld.Hidden {
+			continue
+		}
+		val := fieldToValue(&fld, resolver, stackDepth+1)
+		switch val.Type {
+		case NullType:
+			// null fields of the patch remove the field
+			continue
+		case ObjectType:
+			var targetVal *Value
+			if targetFields != nil && targetFields.FieldMap[fld.Name] != nil && !targetFields.FieldMap[fld.Name].Hidden {
+				targetVal = fieldToValue(targetFields.FieldMap[fld.Name], resolver, stackDepth+1)
+			}
+			merged := mergePatchToValue(targetVal, val, resolver, stackDepth+1)
+			fld = Field{Name: fld.Name, Type: merged.Type, Range: fld.Range, Comment: fld.Comment, Value: merged}
+		}
+		res.Object.Fields = append(res.Object.Fields, fld)
+	}
+	for i := range res.Object.Fields {
+		res.Object.FieldMap[res.Object.Fields[i].Name] = &res.Object.Fields[i]
+	}
+	return res
+}
+
+// objectValuesToValue resolves `std.objectValues(o)` and `std.objectKeysValues(o)` (and their
+// `All` variants) to an array with the values of the fields of `o` as elements, or objects with
+// the `key` and `value` of the fields. The elements are only known if every field is of the same
+// type, and the fields of object elements are those of any of the field values.
+func objectValuesToValue(call *ast.Apply, fn *Function, resolver Resolver, stackDepth int) *Value {
+	res := defaultToValue(call)
+	res.Type = ArrayType
+	objNode := callArgument(call, fn, "o")
+	if objNode == nil {
+		return res
+	}
+	obj := nodeToValue(objNode, resolver, stackDepth+1)
+	if obj.Object == nil {
+		return res
+	}
+
+	withHidden := fn == StdLibFunctions["objectValuesAll"] || fn == StdLibFunctions["objectKeysValuesAll"]
+	var elem *Value
+	for _, fld := range obj.Object.Fields {
+		if fld.Hidden && !withHidden {
+			continue
+		}
+		val := fieldToValue(&fld, resolver, stackDepth+1)
+		switch {
+		case val.Type == AnyType:
+			return res
+		case elem == nil:
+			elem = val
+		case elem.Type != val.Type:
+			return res
+		case elem.Object != nil && val.Object != nil:
+			elem = &Value{Type: elem.Type, Object: unionObjects(elem.Object, val.Object)}
+		default:
+			elem = &Value{Type: elem.Type}
+		}
+	}
+	if elem == nil {
+		return res
+	}
+
+	if fn == StdLibFunctions["objectKeysValues"] || fn == StdLibFunctions["objectKeysValuesAll"] {
+		kv := &Object{
+			AllFieldsKnown: true,
+			Fields: []Field{
+				{Name: "key", Type: StringType, Value: &Value{Type: StringType}},
+				{Name: "value", Type: elem.Type, Value: elem},
+			},
+		}
+		kv.FieldMap = map[string]*Field{"key": &kv.Fields[0], "value": &kv.Fields[1]}
+		elem = &Value{Type: ObjectType, Object: kv}
+	}
+	res.Element = elem
+	return res
+}
+
+// foldNumbers resolves arithmetic on numbers that are statically known, like `8080 + 1`
+func foldNumbers(node *ast.Binary, resolver Resolver, stackDepth int) *Value {
+	lhs := nodeToValue(node.Left, resolver, stackDepth+1)
+	if lhs.NumberValue == nil {
+		return nil
+	}
+	rhs := nodeToValue(node.Right, resolver, stackDepth+1)
+	if rhs.NumberValue == nil {
+		return nil
+	}
+
+	l, r := *lhs.NumberValue, *rhs.NumberValue
+	var num float64
+	switch node.Op {
+	case ast.BopPlus:
+		num = l + r
+	case ast.BopMinus:
+		num = l - r
+	case ast.BopMult:
+		num = l * r
+	case ast.BopDiv:
+		if r == 0 {
+			return nil
+		}
+		num = l / r
+	default:
+		return nil
+	}
+	if math.IsInf(num, 0) || math.IsNaN(num) {
+		return nil
+	}
+
+	res := defaultToValue(node)
+	res.Type = NumberType
+	res.NumberValue = &num
+	return res
+}
+
+// foldUnary resolves unary operators applied to statically known numbers and booleans
+func foldUnary(node *ast.Unary, resolver Resolver, stackDepth int) *Value {
+	res := defaultToValue(node)
+	expr := nodeToValue(node.Expr, resolver, stackDepth+1)
+	switch {
+	case expr.NumberValue != nil && (node.Op == ast.UopMinus || node.Op == ast.UopPlus):
+		num := *expr.NumberValue
+		if node.Op == ast.UopMinus {
+			num = -num
+		}
+		res.Type = NumberType
+		res.NumberValue = &num
+	case expr.BooleanValue != nil && node.Op == ast.UopNot:
+		b := !*expr.BooleanValue
+		res.Type = BooleanType
+		res.BooleanValue = &b
+	}
+	return res
+}
+
+// ConstantString formats the statically known value of a string, number or boolean as jsonnet
+func (v *Value) ConstantString() (string, bool) {
+	switch {
+	case v.StringValue != nil:
+		return strconv.Quote(*v.StringValue), true
+	case v.NumberValue != nil:
+		return strconv.FormatFloat(*v.NumberValue, 'g', -1, 64), true
+	case v.BooleanValue != nil:
+		return strconv.FormatBool(*v.BooleanValue), true
+	}
+	return "", false
+}
+
 func NodeToValue(node ast.Node, resolver Resolver) (res *Value) {
 	return nodeToValue(node, resolver, 0)
 }
+
+// FieldToValue resolves the value of a field of an object. Fields without a node, like the
+// fields of a union of objects, only have a value or a type.
+func FieldToValue(fld *Field, resolver Resolver) *Value {
+	return fieldToValue(fld, resolver, 0)
+}
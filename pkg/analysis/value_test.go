@@ -27,6 +27,8 @@ func TestBasicType(t *testing.T) {
 		{"null", "null", NullType, true},
 		{"Number", "1234", NumberType, false},
 		{"String", "\"asdf\"", StringType, false},
+		{"ImportStr", "importstr 'file.txt'", StringType, true},
+		{"ImportBin", "importbin 'file.bin'", ArrayType, false},
 		{"Array Literal", "[1,2,3]", ArrayType, false},
 		{"Array Comprehension", "[f for f in [1,2,3]]", ArrayType, true},
 		{"Object Comprehension", "{[f]: f for f in [1,2,3]}", ObjectType, true},
@@ -117,6 +119,21 @@ var valuesCases = []valueCase{
 			Comment: []string{"false"},
 		},
 	},
+	{
+		Name: "LocalAfterAssert",
+		Expect: valueResult{
+			Type:    NumberType,
+			Range:   valueRange{1, 11, 1, 12},
+			Comment: []string{"1"},
+		},
+	},
+	{
+		Name: "ImportBinIndex",
+		// the element of a binary import is not located anywhere in the file
+		Expect: valueResult{
+			Type: NumberType,
+		},
+	},
 	{
 		Name: "FunctionBasic",
 		Expect: valueResult{
@@ -124,6 +141,121 @@ var valuesCases = []valueCase{
 			Range: valueRange{1, 7, 1, 29},
 		},
 	},
+	{
+		Name: "ConstantStringIndex",
+		Expect: valueResult{
+			Type:    BooleanType,
+			Range:   valueRange{2, 20, 2, 24},
+			Comment: []string{"true"},
+		},
+	},
+	{
+		Name: "FoldInit",
+		Expect: valueResult{
+			Type:  ObjectType,
+			Range: valueRange{2, 1, 2, 31},
+		},
+	},
+	{
+		Name: "FoldReturn",
+		Expect: valueResult{
+			Type:  ArrayType,
+			Range: valueRange{1, 24, 1, 32},
+		},
+	},
+	{
+		Name: "StdLibReturn",
+		Expect: valueResult{
+			Type:  NumberType,
+			Range: valueRange{2, 1, 2, 16},
+		},
+	},
+	{
+		Name: "ConditionalObject",
+		Expect: valueResult{
+			Type: StringType,
+		},
+	},
+	{
+		Name: "InSuper",
+		Expect: valueResult{
+			Type:  BooleanType,
+			Range: valueRange{2, 31, 2, 43},
+		},
+	},
+	{
+		Name: "StdPrune",
+		Expect: valueResult{
+			Type:    StringType,
+			Range:   valueRange{1, 28, 1, 31},
+			Comment: []string{"x"},
+		},
+	},
+	{
+		Name: "StdMergePatch",
+		Expect: valueResult{
+			Type:    StringType,
+			Range:   valueRange{1, 49, 1, 52},
+			Comment: []string{"x"},
+		},
+	},
+	{
+		// nested objects are merged, so the fields of the target missing from the patch are kept
+		Name: "StdMergePatchNested",
+		Expect: valueResult{
+			Type:    NumberType,
+			Range:   valueRange{1, 38, 1, 39},
+			Comment: []string{"1"},
+		},
+	},
+	{
+		// null fields of nested patches remove the field
+		Name: "StdMergePatchNull",
+		Expect: valueResult{
+			Type:  AnyType,
+			Range: valueRange{2, 1, 2, 8},
+		},
+	},
+	{
+		Name: "StdPruneNull",
+		Expect: valueResult{
+			Type:  AnyType,
+			Range: valueRange{2, 1, 2, 8},
+		},
+	},
+	{
+		Name: "Slice",
+		Expect: valueResult{
+			Type:  StringType,
+			Range: valueRange{3, 1, 3, 14},
+		},
+	},
+	{
+		Name: "StdMapWithKey",
+		Expect: valueResult{
+			Type:  ArrayType,
+			Range: valueRange{1, 43, 1, 49},
+		},
+	},
+	{
+		Name: "StdObjectValues",
+		Expect: valueResult{
+			Type: StringType,
+		},
+	},
+	{
+		Name: "StdParseYamlStream",
+		Expect: valueResult{
+			Type:  NumberType,
+			Range: valueRange{1, 14, 7, 5},
+		},
+	},
+	{
+		Name: "StdObjectKeysValues",
+		Expect: valueResult{
+			Type: NumberType,
+		},
+	},
 }
 
 func TestNodeToValue(t *testing.T) {
@@ -1,5 +1,12 @@
 package linter
 
+import (
+	"strings"
+	"unicode"
+
+	"github.com/google/go-jsonnet/ast"
+)
+
 type DiagCode string
 
 const (
@@ -10,4 +17,105 @@ const (
 	UnknownField        DiagCode = "UnknownField"
 	UnknownArgument     DiagCode = "UnknownArgument"
 	ArgumentCardinality DiagCode = "ArgumentCardinality"
+	InvalidSelf         DiagCode = "InvalidSelf"
+	Indentation         DiagCode = "Indentation"
+	// Imports that only resolve by reading outside of the workspace and configured jpaths
+	ImportOutsideWorkspace DiagCode = "ImportOutsideWorkspace"
+	// Locals redefined by a following local of the same name before being used
+	ShadowedVar DiagCode = "ShadowedVar"
+	// Lines indented with both tabs and spaces
+	MixedIndentation DiagCode = "MixedIndentation"
+	// Conditionals nested deeper than the configured limit
+	NestedTernary DiagCode = "NestedTernary"
+	// Uses of deprecated stdlib functions
+	Deprecated DiagCode = "Deprecated"
+	// Parameter defaults referring to each other
+	ParamDefaultCycle DiagCode = "ParamDefaultCycle"
+	// Object comprehensions over constant arrays producing the same field more than once
+	DuplicateField DiagCode = "DuplicateField"
+	// Strings built from more `+` concatenations than the configured limit
+	StringConcat DiagCode = "StringConcat"
+	// Locals and parameters named `std`, which hide the standard library
+	ShadowedStd DiagCode = "ShadowedStd"
 )
+
+// Explanations of the diagnostic codes, with examples and how to fix them. Shown when hovering
+// a diagnostic.
+var Explanations = map[DiagCode]string{
+	ImportNotFound: "The imported file was not found relative to the importing file, the workspace root or any jpath.\n" +
+		"Check the path for typos, or add the directory it is in to the `jpaths` setting.\n" +
+		"Imports that are generated or only exist at build time can be ignored with `// jsonnet-lsp:ignore ImportNotFound`.",
+	UnusedVar: "The local is never used, so it can be removed.\n" +
+		"Example: `local unused = 1; {}`",
+	TypeMismatch: "The value has a type that cannot be used here, and will fail when evaluated.\n" +
+		"Example: `'port: ' + 80` is fine, but `std.length(80)` expects a string, array, object or function.\n" +
+		"Convert the value explicitly, like `std.toString(80)`, or check the type of the parameter with `/*:type*/` annotations.",
+	RedundantCondition: "The condition always has the same result, so one of the branches is never used.\n" +
+		"Example: `if true then a else b` is always `a`.",
+	UnknownField: "The object does not have the field, and accessing it fails when evaluated.\n" +
+		"Example: `{name: 'x'}.nmae`\n" +
+		"Check the field name for typos, or use `std.get(obj, 'field', default)` if the field is optional.",
+	UnknownArgument: "The function does not have a parameter with the name of the argument.\n" +
+		"Example: `local f(a) = a; f(b=1)`",
+	ArgumentCardinality: "The function is called with too many or too few arguments, or the same argument twice.\n" +
+		"Example: `local f(a, b) = a + b; f(1)`\n" +
+		"Pass a value for every parameter without a default, and each parameter once.",
+	InvalidSelf: "`self`, `super` and `$` can only be used inside of an object.\n" +
+		"Example: `local x = self.name; {name: 'x'}`",
+	Indentation: "The indentation is not a multiple of the formatter indent. Formatting the file fixes it.",
+	ImportOutsideWorkspace: "The import is only found by reading outside of the workspace and the configured jpaths, " +
+		"so it depends on the layout of the local filesystem and usually breaks elsewhere.\n" +
+		"Add the directory to the `jpaths` setting, or move the file into the workspace.",
+	ShadowedVar: "The local is redefined before it is used, so the first definition is never used.\n" +
+		"Example: `local name = 'a'; local name = 'b'; name`",
+	MixedIndentation: "The line is indented with both tabs and spaces, which looks different depending on the editor. " +
+		"Formatting the file fixes it.",
+	NestedTernary: "Conditionals nested this deep are hard to read.\n" +
+		"Example: `if a then (if b then 1 else 2) else 3`\n" +
+		"Move the inner conditionals into locals, or use a lookup object like `{a: 1, b: 2}[key]`.",
+	Deprecated: "The stdlib function is deprecated and may be removed from future versions of jsonnet. " +
+		"The diagnostic suggests what to use instead.",
+	ParamDefaultCycle: "The defaults of the parameters refer to each other, which recurses forever when neither argument is given.\n" +
+		"Example: `function(a=b, b=a) a`\n" +
+		"Give one of the parameters a default that does not depend on the others.",
+	DuplicateField: "The object comprehension produces the same field more than once, which fails when evaluated.\n" +
+		"Example: `{[k]: 1 for k in ['a', 'a']}`\n" +
+		"Remove the duplicates from the array, for example with `std.set(arr)`.",
+	StringConcat: "The string is built from many concatenations, which makes it hard to see the result and easy to miss a space.\n" +
+		"Example: `'host ' + name + ':' + port + ' in ' + region`\n" +
+		"Use `std.format`, like `'host %s:%s in %s' % [name, port, region]`.",
+	ShadowedStd: "The local or parameter is named `std`, which hides the standard library where it is in scope, " +
+		"so calls like `std.length(x)` use the variable instead.\n" +
+		"Example: `local std = {}; std.length([])`\n" +
+		"Rename the variable.",
+}
+
+const ignoreDirective = "jsonnet-lsp:ignore"
+
+// isSuppressed returns true if a `// jsonnet-lsp:ignore <Code>...` comment naming the code is on
+// the first line of the range, or on its own line above it
+func isSuppressed(r ast.LocationRange, code DiagCode) bool {
+	if r.File == nil || r.Begin.Line <= 0 {
+		return false
+	}
+	for line := r.Begin.Line - 1; line >= r.Begin.Line-2 && line >= 0; line-- {
+		if line >= len(r.File.Lines) {
+			continue
+		}
+		text := r.File.Lines[line]
+		idx := strings.Index(text, ignoreDirective)
+		if idx < 0 {
+			continue
+		}
+		// a trailing comment on the line above applies to that line only
+		if trimmed := strings.TrimSpace(text); line < r.Begin.Line-1 && !strings.HasPrefix(trimmed, "//") && !strings.HasPrefix(trimmed, "#") && !strings.HasPrefix(trimmed, "/*") {
+			continue
+		}
+		for _, c := range strings.FieldsFunc(text[idx+len(ignoreDirective):], func(r rune) bool { return r == ',' || unicode.IsSpace(r) }) {
+			if DiagCode(strings.TrimSuffix(c, "*/")) == code {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,107 @@
+package linter
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/carlverge/jsonnet-lsp/pkg/analysis"
+	"github.com/google/go-jsonnet/ast"
+	"go.lsp.dev/protocol"
+)
+
+// LintIndentation flags object fields and array elements starting a line with an indentation
+// that is not a multiple of `indent`. An element is considered to start a line if nothing else in
+// its container ends on that line before it, and only whitespace is before it in `contents`.
+// Tabs advance to the next multiple of `indent`.
+func LintIndentation(root ast.Node, contents string, indent int) []Diagnostic {
+	diags := []Diagnostic{}
+	if indent <= 0 {
+		return diags
+	}
+
+	lines := strings.Split(contents, "\n")
+	// width returns the indentation before the column of the line, false if it is not whitespace
+	width := func(line, column int) (int, bool) {
+		if line < 1 || line > len(lines) || column-1 > len(lines[line-1]) {
+			return 0, false
+		}
+		res := 0
+		for _, c := range lines[line-1][:column-1] {
+			switch c {
+			case ' ':
+				res++
+			case '\t':
+				res += indent - res%indent
+			default:
+				return 0, false
+			}
+		}
+		return res, true
+	}
+
+	check := func(container ast.LocationRange, elems []ast.LocationRange) {
+		prevLine := container.Begin.Line
+		for _, r := range elems {
+			if !r.IsSet() {
+				continue
+			}
+			if r.Begin.Line > prevLine {
+				if width, ok := width(r.Begin.Line, r.Begin.Column); ok && width%indent != 0 {
+					diags = append(diags, Diagnostic{
+						Range:    rangeToProto(ast.LocationRange{Begin: ast.Location{Line: r.Begin.Line, Column: 1}, End: r.Begin}),
+						Code:     Indentation,
+						Severity: protocol.DiagnosticSeverityInformation,
+						Message:  fmt.Sprintf("indentation of %d is not a multiple of %d", width, indent),
+					})
+				}
+			}
+			prevLine = r.End.Line
+		}
+	}
+
+	analysis.WalkStack(root, func(n ast.Node, _ []ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.DesugaredObject:
+			elems := make([]ast.LocationRange, len(n.Fields))
+			for i, fld := range n.Fields {
+				elems[i] = fld.LocRange
+			}
+			check(n.LocRange, elems)
+		case *ast.Array:
+			elems := make([]ast.LocationRange, len(n.Elements))
+			for i, elem := range n.Elements {
+				if elem.Expr != nil && elem.Expr.Loc() != nil {
+					elems[i] = *elem.Expr.Loc()
+				}
+			}
+			check(n.LocRange, elems)
+		}
+		return true
+	})
+
+	// containers are checked before their children, report in document order
+	sort.SliceStable(diags, func(i, j int) bool {
+		return diags[i].Range.Start.Line < diags[j].Range.Start.Line
+	})
+	return diags
+}
+
+// LintMixedIndentation flags lines of the source indented with both tabs and spaces. Only the
+// text is used, so it can run on files that do not parse.
+func LintMixedIndentation(contents string) []Diagnostic {
+	diags := []Diagnostic{}
+	for i, line := range strings.Split(contents, "\n") {
+		indent := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+		if !strings.Contains(indent, " ") || !strings.Contains(indent, "\t") {
+			continue
+		}
+		diags = append(diags, Diagnostic{
+			Range:    rangeToProto(ast.LocationRange{Begin: ast.Location{Line: i + 1, Column: 1}, End: ast.Location{Line: i + 1, Column: len(indent) + 1}}),
+			Code:     MixedIndentation,
+			Severity: protocol.DiagnosticSeverityInformation,
+			Message:  "indentation mixes tabs and spaces",
+		})
+	}
+	return diags
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"sort"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/carlverge/jsonnet-lsp/pkg/analysis"
 	"github.com/google/go-jsonnet/ast"
@@ -59,6 +60,37 @@ type varbindInfo struct {
 	loc   ast.LocationRange
 	body  ast.Node
 	param bool
+	// a later local in the same chain of locals binding the same name
+	shadowedBy *ast.LocalBind
+}
+
+// bindRange is the range of a local bind. Function binds like `f(a) = a` lose theirs when
+// desugared, but the function is located from the name.
+func bindRange(b ast.LocalBind) ast.LocationRange {
+	if !b.LocRange.IsSet() && b.Body != nil && b.Body.Loc() != nil {
+		return *b.Body.Loc()
+	}
+	return b.LocRange
+}
+
+// nameRange narrows the range of a bind, like `x = 1` or `f(a) = a`, which starts at its name, to
+// just the name
+func nameRange(loc ast.LocationRange, name string) ast.LocationRange {
+	loc.End = ast.Location{Line: loc.Begin.Line, Column: loc.Begin.Column + utf8.RuneCountInString(name)}
+	return loc
+}
+
+// shadowingBind finds a bind of the same name in the locals directly following `local`, like
+// `local x = 1; local y = 2; local x = 3;`
+func shadowingBind(local *ast.Local, name ast.Identifier) *ast.LocalBind {
+	for next, ok := local.Body.(*ast.Local); ok; next, ok = next.Body.(*ast.Local) {
+		for i := range next.Binds {
+			if next.Binds[i].Variable == name {
+				return &next.Binds[i]
+			}
+		}
+	}
+	return nil
 }
 
 func findVarbindInStack(v string, stack []ast.Node) *varbind {
@@ -87,6 +119,40 @@ func findVarbindInStack(v string, stack []ast.Node) *varbind {
 	return nil
 }
 
+// inObjectScope checks if the last node of the stack is inside of an object, where `self` and
+// `super` can be used. Field names are evaluated outside of their object, so they do not count.
+func inObjectScope(stack []ast.Node) bool {
+	for i := len(stack) - 2; i >= 0; i-- {
+		obj, ok := stack[i].(*ast.DesugaredObject)
+		if !ok {
+			continue
+		}
+		inName := false
+		for _, fld := range obj.Fields {
+			if fld.Name == stack[i+1] {
+				inName = true
+				break
+			}
+		}
+		if !inName {
+			return true
+		}
+	}
+	return false
+}
+
+func checkObjectScope(n ast.Node, keyword string, stack []ast.Node) []Diagnostic {
+	if inObjectScope(stack) {
+		return nil
+	}
+	return []Diagnostic{{
+		Range:    rangeToProto(*n.Loc()),
+		Code:     InvalidSelf,
+		Severity: protocol.DiagnosticSeverityError,
+		Message:  fmt.Sprintf("cannot use '%s' outside of an object", keyword),
+	}}
+}
+
 func sortDiags(diags []Diagnostic) []Diagnostic {
 	sort.Slice(diags, func(i, j int) bool {
 		if diags[i].Range.Start.Line != diags[j].Range.Start.Line {
@@ -146,45 +212,84 @@ func checkFunctionCall(fn *analysis.Value, call *ast.Apply, resolver analysis.Re
 		return diags
 	}
 
-	// too many arguments
-	if args, nparam := (len(call.Arguments.Named) + len(call.Arguments.Positional)), len(fn.Function.Params); args > nparam {
+	params := analysis.CallParams(fn.Function, call, resolver)
+	paramsByName := map[string]*analysis.Param{}
+	for i := range params {
+		paramsByName[params[i].Name] = &params[i]
+	}
+
+	// The slots filled by the call, counting a parameter given both positionally and by name once
+	filled := map[string]bool{}
+	for idx := range call.Arguments.Positional {
+		if idx < len(params) {
+			filled[params[idx].Name] = true
+		}
+	}
+	for _, arg := range call.Arguments.Named {
+		if paramsByName[string(arg.Name)] != nil {
+			filled[string(arg.Name)] = true
+		}
+	}
+
+	// The arguments as written in the call, for the messages
+	nargs := len(call.Arguments.Positional) + len(call.Arguments.Named)
+
+	// Positional arguments fill the leading parameters, so only they can overflow the parameter
+	// list. Named arguments either fill a remaining slot, or are reported individually below.
+	if nparam := len(params); len(call.Arguments.Positional) > nparam {
 		diags = append(diags, Diagnostic{
 			Range:    rangeToProto(call.LocRange),
 			Code:     ArgumentCardinality,
 			Severity: protocol.DiagnosticSeverityError,
-			Message:  fmt.Sprintf("too many arguments in function call (%d arguments for %d parameters)", args, nparam),
+			Message:  fmt.Sprintf("too many arguments in function call (%d arguments for %d parameters)", nargs, nparam),
 		})
 	}
 
-	paramsByName := map[string]*analysis.Param{}
-	{
-		minArgs := 0
-		for _, param := range fn.Function.Params {
-			paramsByName[param.Name] = &param
-			if param.Default == nil {
-				minArgs++
-			}
+	minArgs := 0
+	missing := []string{}
+	for _, param := range params {
+		if param.Default != nil {
+			continue
 		}
+		minArgs++
+		if !filled[param.Name] {
+			missing = append(missing, param.Name)
+		}
+	}
 
-		// too few arguments
-		if args := len(call.Arguments.Named) + len(call.Arguments.Positional); args < minArgs {
+	// too few arguments
+	if nargs < minArgs {
+		diags = append(diags, Diagnostic{
+			Range:    rangeToProto(call.LocRange),
+			Code:     ArgumentCardinality,
+			Severity: protocol.DiagnosticSeverityError,
+			Message:  fmt.Sprintf("too few arguments in function call (%d arguments for %d required parameters)", nargs, minArgs),
+		})
+	} else {
+		// enough arguments were given, but some went to parameters with defaults instead
+		for _, name := range missing {
 			diags = append(diags, Diagnostic{
 				Range:    rangeToProto(call.LocRange),
 				Code:     ArgumentCardinality,
 				Severity: protocol.DiagnosticSeverityError,
-				Message:  fmt.Sprintf("too few arguments in function call (%d arguments for %d required parameters)", args, minArgs),
+				Message:  fmt.Sprintf("missing argument for required parameter '%s'", name),
 			})
 		}
 	}
 
 	usedParams := map[string]bool{}
-	params := fn.Function.Params
+	positionalParams := map[string]bool{}
 	for idx, arg := range call.Arguments.Positional {
 		if idx >= len(params) {
 			break
 		}
 		param := params[idx]
 		usedParams[param.Name] = true
+		positionalParams[param.Name] = true
+		if param.TypeHint != nil {
+			diags = append(diags, checkTypeHint(&param, arg.Expr, call, resolver)...)
+			continue
+		}
 		if param.Type == analysis.AnyType {
 			continue
 		}
@@ -206,7 +311,14 @@ func checkFunctionCall(fn *analysis.Value, call *ast.Apply, resolver analysis.Re
 
 	for _, arg := range call.Arguments.Named {
 
-		if usedParams[string(arg.Name)] {
+		if positionalParams[string(arg.Name)] {
+			diags = append(diags, Diagnostic{
+				Range:    rangeToProto(call.LocRange),
+				Code:     ArgumentCardinality,
+				Severity: protocol.DiagnosticSeverityError,
+				Message:  fmt.Sprintf("argument '%s' already given positionally", arg.Name),
+			})
+		} else if usedParams[string(arg.Name)] {
 			diags = append(diags, Diagnostic{
 				Range:    rangeToProto(call.LocRange),
 				Code:     ArgumentCardinality,
@@ -227,6 +339,10 @@ func checkFunctionCall(fn *analysis.Value, call *ast.Apply, resolver analysis.Re
 			continue
 		}
 
+		if param.TypeHint != nil {
+			diags = append(diags, checkTypeHint(param, arg.Arg, call, resolver)...)
+			continue
+		}
 		if param.Type == analysis.AnyType {
 			continue
 		}
@@ -249,6 +365,93 @@ func checkFunctionCall(fn *analysis.Value, call *ast.Apply, resolver analysis.Re
 	return diags
 }
 
+// checkTypeHint compares an argument to the `/*:type*/` annotation of its parameter, which can
+// describe element types like `array[string]`
+func checkTypeHint(param *analysis.Param, arg ast.Node, call *ast.Apply, resolver analysis.Resolver) []Diagnostic {
+	argVal := analysis.NodeToValue(arg, resolver)
+	if argVal.Type == analysis.AnyType {
+		return nil
+	}
+	got, ok := analysis.CheckTypeHint(argVal, param.TypeHint, resolver)
+	if ok {
+		return nil
+	}
+	return []Diagnostic{{
+		Range:    rangeToProto(call.LocRange),
+		Code:     TypeMismatch,
+		Severity: protocol.DiagnosticSeverityWarning,
+		Message:  fmt.Sprintf("mismatched argument type for '%s' expected '%s' got '%s'", param.Name, param.TypeHint, got),
+	}}
+}
+
+// checkTemplateFields compares the fields of an object literal merged onto a template, like
+// `template + {port: 80}`, to the `/*:type*/` annotations of the template fields they override
+func checkTemplateFields(lhs *analysis.Value, node *ast.Binary, resolver analysis.Resolver) []Diagnostic {
+	obj, ok := node.Right.(*ast.DesugaredObject)
+	if node.Op != ast.BopPlus || !ok || lhs.Object == nil {
+		return nil
+	}
+	diags := []Diagnostic{}
+	for _, fld := range obj.Fields {
+		name, ok := fld.Name.(*ast.LiteralString)
+		// `field+:` merges with the template value, so it is not a replacement of the same type
+		if !ok || fld.PlusSuper || lhs.Object.FieldMap[name.Value] == nil {
+			continue
+		}
+		hint := analysis.CommentsToTypeHint(lhs.Object.FieldMap[name.Value].Comment)
+		if hint == nil {
+			continue
+		}
+		val := analysis.NodeToValue(fld.Body, resolver)
+		if val.Type == analysis.AnyType {
+			continue
+		}
+		if got, ok := analysis.CheckTypeHint(val, hint, resolver); !ok {
+			diags = append(diags, Diagnostic{
+				Range:    rangeToProto(fld.LocRange),
+				Code:     TypeMismatch,
+				Severity: protocol.DiagnosticSeverityWarning,
+				Message:  fmt.Sprintf("mismatched type for template field '%s' expected '%s' got '%s'", name.Value, hint, got),
+			})
+		}
+	}
+	return diags
+}
+
+// checkUnknownTemplateFields flags fields of an object literal merged onto a template whose
+// fields are all known, like `template + {prot: 80}`, that the template does not declare
+func checkUnknownTemplateFields(lhs *analysis.Value, node *ast.Binary) []Diagnostic {
+	obj, ok := node.Right.(*ast.DesugaredObject)
+	if node.Op != ast.BopPlus || !ok || lhs.Object == nil || !lhs.Object.AllFieldsKnown || lhs.Object.FieldMap == nil {
+		return nil
+	}
+	names := make([]string, len(lhs.Object.Fields))
+	for i := range lhs.Object.Fields {
+		names[i] = lhs.Object.Fields[i].Name
+	}
+	diags := []Diagnostic{}
+	for _, fld := range obj.Fields {
+		name, ok := fld.Name.(*ast.LiteralString)
+		if !ok {
+			continue
+		}
+		if _, hasfld := lhs.Object.FieldMap[name.Value]; hasfld {
+			continue
+		}
+		msg := fmt.Sprintf("template has no field '%s'", name.Value)
+		if suggest := closestName(name.Value, names); suggest != "" {
+			msg += fmt.Sprintf(", did you mean '%s'?", suggest)
+		}
+		diags = append(diags, Diagnostic{
+			Range:    rangeToProto(fld.LocRange),
+			Code:     UnknownField,
+			Severity: protocol.DiagnosticSeverityWarning,
+			Message:  msg,
+		})
+	}
+	return diags
+}
+
 func checkUnaryOp(lhs *analysis.Value, node *ast.Unary) []Diagnostic {
 	if lhs.Type == analysis.AnyType {
 		return nil
@@ -275,6 +478,231 @@ func checkUnaryOp(lhs *analysis.Value, node *ast.Unary) []Diagnostic {
 	return diags
 }
 
+// editDistance is the Levenshtein distance between two strings
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev, cur := make([]int, len(rb)+1), make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = prev[j-1] + cost
+			if prev[j]+1 < cur[j] {
+				cur[j] = prev[j] + 1
+			}
+			if cur[j-1]+1 < cur[j] {
+				cur[j] = cur[j-1] + 1
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
+
+// closestName suggests the candidate most similar to name, if any is close enough to be a typo
+func closestName(name string, candidates []string) string {
+	best, bestDist := "", len(name)/3+1
+	for _, c := range candidates {
+		if d := editDistance(strings.ToLower(name), strings.ToLower(c)); d <= bestDist && (best == "" || d < bestDist) {
+			best, bestDist = c, d
+		}
+	}
+	return best
+}
+
+// checkParamDefaults flags parameter defaults that refer to each other, like `function(a=b, b=a)`,
+// which recurse forever when neither argument is given. Defaults are lazy, so defaults referring to
+// any other parameter are fine. Variables bound inside of the default itself are skipped.
+func checkParamDefaults(fn *ast.Function) []Diagnostic {
+	type paramRef struct {
+		from string
+		v    *ast.Var
+	}
+	params := map[string]bool{}
+	for _, p := range fn.Parameters {
+		params[string(p.Name)] = true
+	}
+	refs := []paramRef{}
+	deps := map[string][]string{}
+	for _, param := range fn.Parameters {
+		if param.DefaultArg == nil {
+			continue
+		}
+		from := string(param.Name)
+		analysis.WalkStack(param.DefaultArg, func(n ast.Node, stack []ast.Node) bool {
+			v, ok := n.(*ast.Var)
+			if !ok || !params[string(v.Id)] || findVarbindInStack(string(v.Id), stack) != nil {
+				return true
+			}
+			refs = append(refs, paramRef{from: from, v: v})
+			deps[from] = append(deps[from], string(v.Id))
+			return true
+		})
+	}
+
+	// reaches checks if the default of `from` depends on `to`, directly or through other defaults
+	reaches := func(from, to string) bool {
+		seen := map[string]bool{}
+		queue := []string{from}
+		for len(queue) > 0 {
+			cur := queue[0]
+			queue = queue[1:]
+			if cur == to {
+				return true
+			}
+			if seen[cur] {
+				continue
+			}
+			seen[cur] = true
+			queue = append(queue, deps[cur]...)
+		}
+		return false
+	}
+
+	diags := []Diagnostic{}
+	for _, ref := range refs {
+		to := string(ref.v.Id)
+		if !reaches(to, ref.from) || isSuppressed(ref.v.LocRange, ParamDefaultCycle) {
+			continue
+		}
+		msg := fmt.Sprintf("default of parameter '%s' refers to parameter '%s', whose default refers back to it", ref.from, to)
+		if to == ref.from {
+			msg = fmt.Sprintf("default of parameter '%s' refers to itself", ref.from)
+		}
+		diags = append(diags, Diagnostic{
+			Range:    rangeToProto(ref.v.LocRange),
+			Code:     ParamDefaultCycle,
+			Severity: protocol.DiagnosticSeverityWarning,
+			Message:  msg,
+		})
+	}
+	return diags
+}
+
+// foldString folds a string expression where the variable `param` is `val`, like `k + '_suffix'`
+func foldString(node ast.Node, param ast.Identifier, val string) (string, bool) {
+	switch n := node.(type) {
+	case *ast.LiteralString:
+		return n.Value, true
+	case *ast.Var:
+		return val, n.Id == param
+	case *ast.Binary:
+		if n.Op != ast.BopPlus {
+			return "", false
+		}
+		lhs, lok := foldString(n.Left, param, val)
+		rhs, rok := foldString(n.Right, param, val)
+		return lhs + rhs, lok && rok
+	}
+	return "", false
+}
+
+// checkComprehensionKeys flags object comprehensions over a constant array of strings that
+// produce the same field more than once, like `{[k]: 1 for k in ['a', 'a']}`. Comprehensions are
+// desugared to `$std.$objectFlatMerge($std.flatMap(function(k) [{[k]: ...}], arr))`, and only
+// those with a single `for` and no `if` are checked.
+func checkComprehensionKeys(app *ast.Apply, resolver analysis.Resolver) []Diagnostic {
+	if !analysis.IsStdCall(app, "$objectFlatMerge") || len(app.Arguments.Positional) != 1 || isSuppressed(app.LocRange, DuplicateField) {
+		return nil
+	}
+	flatMap, ok := app.Arguments.Positional[0].Expr.(*ast.Apply)
+	if !ok || !analysis.IsStdCall(flatMap, "flatMap") || len(flatMap.Arguments.Positional) != 2 {
+		return nil
+	}
+	fn, ok := flatMap.Arguments.Positional[0].Expr.(*ast.Function)
+	if !ok || len(fn.Parameters) != 1 {
+		return nil
+	}
+	body, ok := fn.Body.(*ast.Array)
+	if !ok || len(body.Elements) != 1 {
+		return nil
+	}
+	obj, ok := body.Elements[0].Expr.(*ast.DesugaredObject)
+	if !ok || len(obj.Fields) != 1 {
+		return nil
+	}
+	source, ok := analysis.NodeToValue(flatMap.Arguments.Positional[1].Expr, resolver).Node.(*ast.Array)
+	if !ok {
+		return nil
+	}
+
+	seen := map[string]bool{}
+	for _, elem := range source.Elements {
+		if _, isNull := elem.Expr.(*ast.LiteralNull); isNull {
+			// null field names are omitted
+			continue
+		}
+		val, ok := elem.Expr.(*ast.LiteralString)
+		if !ok {
+			return nil
+		}
+		key, ok := foldString(obj.Fields[0].Name, fn.Parameters[0].Name, val.Value)
+		if !ok {
+			return nil
+		}
+		if seen[key] {
+			return []Diagnostic{{
+				Range:    rangeToProto(app.LocRange),
+				Code:     DuplicateField,
+				Severity: protocol.DiagnosticSeverityError,
+				Message:  fmt.Sprintf("duplicate field '%s' in object comprehension", key),
+			}}
+		}
+		seen[key] = true
+	}
+	return nil
+}
+
+// Deprecation describes a deprecated stdlib function
+type Deprecation struct {
+	// Suggestion of what to use instead, shown in the diagnostic
+	Suggestion string
+	// Replacement is a stdlib function that takes the same arguments and can be used instead,
+	// offered as a quick fix. Empty if uses cannot be replaced mechanically.
+	Replacement string
+}
+
+// DeprecatedStdlib are the deprecated stdlib functions by name
+var DeprecatedStdlib = map[string]Deprecation{
+	"base64Decode": {Suggestion: "use `std.base64DecodeBytes` and decode the string explicitly (e.g. with `std.decodeUTF8`) instead"},
+}
+
+// checkDeprecated flags uses of deprecated stdlib functions. If the function can be replaced,
+// the diagnostic data is the TextEdit replacing its name.
+func checkDeprecated(target *analysis.Value, node *ast.Index) []Diagnostic {
+	name, ok := node.Index.(*ast.LiteralString)
+	if !ok || target != analysis.StdLibValue || isSuppressed(node.LocRange, Deprecated) {
+		return nil
+	}
+	dep, ok := DeprecatedStdlib[name.Value]
+	if !ok {
+		return nil
+	}
+	diag := Diagnostic{
+		Range:    rangeToProto(node.LocRange),
+		Code:     Deprecated,
+		Severity: protocol.DiagnosticSeverityInformation,
+		Tags:     []protocol.DiagnosticTag{protocol.DiagnosticTagDeprecated},
+		Message:  fmt.Sprintf("'std.%s' is deprecated, %s", name.Value, dep.Suggestion),
+	}
+	// `std.name` ends with the name, the indexed form `std['name']` is left alone
+	if dep.Replacement != "" && !name.LocRange.IsSet() && node.LocRange.Begin.Line == node.LocRange.End.Line {
+		end := node.LocRange.End
+		begin := ast.Location{Line: end.Line, Column: end.Column - len(name.Value)}
+		diag.Data = protocol.TextEdit{
+			Range:   rangeToProto(ast.LocationRange{Begin: begin, End: end}),
+			NewText: dep.Replacement,
+		}
+	}
+	return []Diagnostic{diag}
+}
+
 func checkIndex(target, idx *analysis.Value, node *ast.Index) []Diagnostic {
 	if target.Type == analysis.AnyType || idx.Type == analysis.AnyType || target.Type == analysis.NullType {
 		return nil
@@ -302,11 +730,22 @@ func checkIndex(target, idx *analysis.Value, node *ast.Index) []Diagnostic {
 		}
 		if sl, ok := idx.Node.(*ast.LiteralString); ok && target.Object != nil && target.Object.AllFieldsKnown && target.Object.FieldMap != nil {
 			if _, hasfld := target.Object.FieldMap[sl.Value]; !hasfld {
+				msg := fmt.Sprintf("object has no field '%s'", sl.Value)
+				if target == analysis.StdLibValue {
+					msg = fmt.Sprintf("std has no member '%s'", sl.Value)
+				}
+				names := make([]string, len(target.Object.Fields))
+				for i := range target.Object.Fields {
+					names[i] = target.Object.Fields[i].Name
+				}
+				if suggest := closestName(sl.Value, names); suggest != "" {
+					msg += fmt.Sprintf(", did you mean '%s'?", suggest)
+				}
 				diags = append(diags, Diagnostic{
 					Range:    rangeToProto(node.LocRange),
 					Code:     UnknownField,
 					Severity: protocol.DiagnosticSeverityWarning,
-					Message:  fmt.Sprintf("object has no field '%s'", sl.Value),
+					Message:  msg,
 				})
 			}
 		}
@@ -331,7 +770,62 @@ func checkIndex(target, idx *analysis.Value, node *ast.Index) []Diagnostic {
 	return diags
 }
 
+// checkUnorderedOperands returns diagnostics for objects and functions used in ordered
+// comparisons, which always fail at runtime regardless of the other operand.
+func checkUnorderedOperands(lhs, rhs *analysis.Value, node *ast.Binary) []Diagnostic {
+	switch node.Op {
+	case ast.BopLess, ast.BopLessEq, ast.BopGreater, ast.BopGreaterEq:
+	default:
+		return nil
+	}
+
+	diags := []Diagnostic{}
+	for _, side := range []struct {
+		name string
+		val  *analysis.Value
+		node ast.Node
+	}{{"lhs", lhs, node.Left}, {"rhs", rhs, node.Right}} {
+		if side.val.Type != analysis.ObjectType && side.val.Type != analysis.FunctionType {
+			continue
+		}
+		diags = append(diags, Diagnostic{
+			Range:    rangeToProto(*side.node.Loc()),
+			Code:     TypeMismatch,
+			Severity: protocol.DiagnosticSeverityWarning,
+			Message:  fmt.Sprintf("%s of operator '%s' cannot be ordered, got type '%s' (expected number, array, or string)", side.name, node.Op, side.val.Type),
+		})
+	}
+	return diags
+}
+
+// checkObjectAsserts flags object assertions with a constant false condition, which fail
+// whenever the object is manifested
+func checkObjectAsserts(obj *ast.DesugaredObject, resolver analysis.Resolver) []Diagnostic {
+	diags := []Diagnostic{}
+	for _, assert := range obj.Asserts {
+		// asserts are desugared to `if cond then null else error msg`
+		cond, ok := assert.(*ast.Conditional)
+		if !ok {
+			continue
+		}
+		val := analysis.NodeToValue(cond.Cond, resolver)
+		if val.BooleanValue == nil || *val.BooleanValue || !cond.Cond.Loc().IsSet() {
+			continue
+		}
+		diags = append(diags, Diagnostic{
+			Range:    rangeToProto(*cond.Cond.Loc()),
+			Code:     RedundantCondition,
+			Severity: protocol.DiagnosticSeverityWarning,
+			Message:  "object assertion is always false",
+		})
+	}
+	return diags
+}
+
 func checkBinaryOp(lhs, rhs *analysis.Value, node *ast.Binary) []Diagnostic {
+	if diags := checkUnorderedOperands(lhs, rhs, node); len(diags) > 0 {
+		return diags
+	}
 	if lhs.Type == analysis.AnyType || rhs.Type == analysis.AnyType {
 		return nil
 	}
@@ -413,7 +907,121 @@ func checkBinaryOp(lhs, rhs *analysis.Value, node *ast.Binary) []Diagnostic {
 	return diags
 }
 
+// isAssertion checks for `if cond then rest else error msg`, which is how `assert` is desugared
+func isAssertion(n *ast.Conditional) bool {
+	_, ok := n.BranchFalse.(*ast.Error)
+	return ok
+}
+
+// isElseIf checks if the conditional at the top of the stack is the `else if` of another
+func isElseIf(stack []ast.Node) bool {
+	if len(stack) < 2 {
+		return false
+	}
+	parent, ok := stack[len(stack)-2].(*ast.Conditional)
+	return ok && parent.BranchFalse == stack[len(stack)-1] && !isAssertion(parent)
+}
+
+// ternaryDepth counts the conditionals nested in each other at the top of the stack, up to the
+// enclosing object or function. Assertions and `else if` chains, which read as a flat list of
+// cases, do not add to the depth.
+func ternaryDepth(stack []ast.Node) int {
+	depth := 0
+	for i := len(stack) - 1; i >= 0; i-- {
+		switch n := stack[i].(type) {
+		case *ast.DesugaredObject, *ast.Function:
+			return depth
+		case *ast.Conditional:
+			if !isAssertion(n) && !isElseIf(stack[:i+1]) {
+				depth++
+			}
+		}
+	}
+	return depth
+}
+
+// checkTernaryDepth warns about the outermost conditional nested deeper than `max`
+func checkTernaryDepth(node *ast.Conditional, stack []ast.Node, max int) []Diagnostic {
+	if max <= 0 || isAssertion(node) || isElseIf(stack) || isSuppressed(node.LocRange, NestedTernary) {
+		return nil
+	}
+	depth := ternaryDepth(stack)
+	if depth != max+1 {
+		return nil
+	}
+	return []Diagnostic{{
+		Range:    rangeToProto(node.LocRange),
+		Code:     NestedTernary,
+		Severity: protocol.DiagnosticSeverityWarning,
+		Message:  fmt.Sprintf("conditional is nested %d deep (max %d), consider extracting a local or a lookup object", depth, max),
+	}}
+}
+
+// concatOperands appends the operands of a chain of `+`, like `a + b + c`
+func concatOperands(node ast.Node, res []ast.Node) []ast.Node {
+	if bin, ok := node.(*ast.Binary); ok && bin.Op == ast.BopPlus {
+		return concatOperands(bin.Right, concatOperands(bin.Left, res))
+	}
+	return append(res, node)
+}
+
+// checkStringConcat suggests std.format for the outermost `+` of a string concatenation with more
+// than `max` operators. Concatenations of only string literals, like a long string split over
+// lines, are left alone.
+func checkStringConcat(node *ast.Binary, stack []ast.Node, resolver analysis.Resolver, max int) []Diagnostic {
+	if max <= 0 || node.Op != ast.BopPlus || isSuppressed(node.LocRange, StringConcat) {
+		return nil
+	}
+	if len(stack) >= 2 {
+		if parent, ok := stack[len(stack)-2].(*ast.Binary); ok && parent.Op == ast.BopPlus {
+			return nil
+		}
+	}
+	operands := concatOperands(node, nil)
+	if len(operands)-1 <= max {
+		return nil
+	}
+	isString, isConstant := false, true
+	for _, op := range operands {
+		if analysis.NodeToValue(op, resolver).Type == analysis.StringType {
+			isString = true
+		}
+		if _, ok := op.(*ast.LiteralString); !ok {
+			isConstant = false
+		}
+	}
+	if !isString || isConstant {
+		return nil
+	}
+	return []Diagnostic{{
+		Range:    rangeToProto(node.LocRange),
+		Code:     StringConcat,
+		Severity: protocol.DiagnosticSeverityWarning,
+		Message:  fmt.Sprintf("string is built from %d concatenations (max %d), consider std.format", len(operands)-1, max),
+	}}
+}
+
+// Options enables or disables individual lints
+type Options struct {
+	// Warn about imports that cannot be resolved
+	ImportNotFound bool
+	// Warn about conditionals nested deeper than this, zero for no limit
+	MaxTernaryDepth int
+	// Suggest std.format for strings built from more `+` than this, zero for no limit
+	MaxStringConcat int
+	// Flag fields merged onto a template that the template does not declare
+	StrictTemplateFields bool
+}
+
+func DefaultOptions() Options {
+	return Options{ImportNotFound: true}
+}
+
 func LintAST(root ast.Node, resolver analysis.Resolver) []Diagnostic {
+	return LintASTWithOptions(root, resolver, DefaultOptions())
+}
+
+func LintASTWithOptions(root ast.Node, resolver analysis.Resolver, opts Options) []Diagnostic {
 	diags := []Diagnostic{}
 	declaredVars := map[varbind]*varbindInfo{}
 
@@ -421,24 +1029,30 @@ func LintAST(root ast.Node, resolver analysis.Resolver) []Diagnostic {
 		switch n := n.(type) {
 		case *ast.Local:
 			for _, b := range n.Binds {
-				declaredVars[varbind{n, string(b.Variable)}] = &varbindInfo{loc: b.LocRange, body: b.Body}
+				declaredVars[varbind{n, string(b.Variable)}] = &varbindInfo{loc: bindRange(b), body: b.Body, shadowedBy: shadowingBind(n, b.Variable)}
 			}
 		case *ast.DesugaredObject:
 			// add $
 			declaredVars[varbind{n, "self"}] = &varbindInfo{loc: n.LocRange, body: n}
 			for _, b := range n.Locals {
-				declaredVars[varbind{n, string(b.Variable)}] = &varbindInfo{loc: b.LocRange, body: b.Body}
+				declaredVars[varbind{n, string(b.Variable)}] = &varbindInfo{loc: bindRange(b), body: b.Body}
 			}
+			diags = append(diags, checkObjectAsserts(n, resolver)...)
 		case *ast.Function:
 			for _, b := range n.Parameters {
 				declaredVars[varbind{n, string(b.Name)}] = &varbindInfo{loc: b.LocRange, body: b.DefaultArg, param: true}
 			}
+			diags = append(diags, checkParamDefaults(n)...)
 		case *ast.Var:
 			// unknown variables references result in AST errors, so this should always succeed
 			if bound := findVarbindInStack(string(n.Id), stack); bound != nil {
 				declaredVars[*bound].refs++
 			}
 		case *ast.Import:
+			// generated files may only exist at build time
+			if !opts.ImportNotFound || isSuppressed(n.LocRange, ImportNotFound) {
+				break
+			}
 			val := analysis.NodeToValue(n, resolver)
 			if val.Node == nil && val.Type == analysis.AnyType {
 				diags = append(diags, Diagnostic{
@@ -451,25 +1065,55 @@ func LintAST(root ast.Node, resolver analysis.Resolver) []Diagnostic {
 		case *ast.Apply:
 			targFn := analysis.NodeToValue(n.Target, resolver)
 			diags = append(diags, checkFunctionCall(targFn, n, resolver)...)
+			diags = append(diags, checkComprehensionKeys(n, resolver)...)
 		case *ast.Index:
 			target := analysis.NodeToValue(n.Target, resolver)
 			idx := analysis.NodeToValue(n.Index, resolver)
 			diags = append(diags, checkIndex(target, idx, n)...)
+			diags = append(diags, checkDeprecated(target, n)...)
 		case *ast.Unary:
 			lhs := analysis.NodeToValue(n.Expr, resolver)
 			diags = append(diags, checkUnaryOp(lhs, n)...)
+		case *ast.Self:
+			diags = append(diags, checkObjectScope(n, "self", stack)...)
+		case *ast.SuperIndex:
+			diags = append(diags, checkObjectScope(n, "super", stack)...)
+		case *ast.InSuper:
+			diags = append(diags, checkObjectScope(n, "super", stack)...)
 		case *ast.Binary:
 			lhs := analysis.NodeToValue(n.Left, resolver)
 			rhs := analysis.NodeToValue(n.Right, resolver)
 			diags = append(diags, checkBinaryOp(lhs, rhs, n)...)
+			diags = append(diags, checkTemplateFields(lhs, n, resolver)...)
+			if opts.StrictTemplateFields {
+				diags = append(diags, checkUnknownTemplateFields(lhs, n)...)
+			}
+			diags = append(diags, checkStringConcat(n, stack, resolver, opts.MaxStringConcat)...)
+		case *ast.Conditional:
+			diags = append(diags, checkTernaryDepth(n, stack, opts.MaxTernaryDepth)...)
 		}
 		return true
 	})
 
 	for bind, info := range declaredVars {
-		if info.refs == 0 && !info.param && !strings.HasPrefix(bind.name, "$") && bind.name != "self" {
+		if bind.name == "std" && !isSuppressed(info.loc, ShadowedStd) {
+			diags = append(diags, protocol.Diagnostic{
+				Range:    rangeToProto(nameRange(info.loc, bind.name)),
+				Code:     ShadowedStd,
+				Severity: protocol.DiagnosticSeverityWarning,
+				Message:  "'std' hides the standard library, consider renaming it",
+			})
+		}
+		if info.refs == 0 && info.shadowedBy != nil {
+			diags = append(diags, protocol.Diagnostic{
+				Range:    rangeToProto(nameRange(info.loc, bind.name)),
+				Code:     ShadowedVar,
+				Severity: protocol.DiagnosticSeverityWarning,
+				Message:  fmt.Sprintf("local variable '%s' is redefined on line %d before it is used", bind.name, info.shadowedBy.LocRange.Begin.Line),
+			})
+		} else if info.refs == 0 && !info.param && !strings.HasPrefix(bind.name, "$") && bind.name != "self" {
 			diags = append(diags, protocol.Diagnostic{
-				Range:    rangeToProto(info.loc),
+				Range:    rangeToProto(nameRange(info.loc, bind.name)),
 				Code:     UnusedVar,
 				Severity: protocol.DiagnosticSeverityWarning,
 				Message:  fmt.Sprintf("unused local variable '%s'", bind.name),
@@ -1,10 +1,9 @@
 package linter_test
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
-	"io/fs"
-	"os"
-	"path/filepath"
 	"strings"
 	"testing"
 
@@ -27,7 +26,37 @@ var linterCases = []linterCase{
 	{
 		File: "unused_vars.jsonnet",
 		Expect: []string{
-			"[Warning|UnusedVar|2:7-2:17] unused local variable 'x'",
+			"[Warning|UnusedVar|2:7-2:8] unused local variable 'x'",
+			"[Warning|UnusedVar|3:7-3:8] unused local variable 'f'",
+			"[Warning|UnusedVar|6:9-6:15] unused local variable 'helper'",
+		},
+	},
+	{
+		File: "shadowed_std.jsonnet",
+		Expect: []string{
+			"[Warning|ShadowedStd|1:13-1:16] 'std' hides the standard library, consider renaming it",
+			"[Warning|ShadowedStd|5:9-5:12] 'std' hides the standard library, consider renaming it",
+		},
+	},
+	{
+		File: "slices.jsonnet",
+		Expect: []string{
+			"[Warning|UnusedVar|4:7-4:13] unused local variable 'unused'",
+		},
+	},
+	{
+		File: "comprehension_keys.jsonnet",
+		Expect: []string{
+			"[Error|DuplicateField|3:8-3:41] duplicate field 'x' in object comprehension",
+			"[Error|DuplicateField|4:13-4:43] duplicate field 'p_a' in object comprehension",
+		},
+	},
+	{
+		File: "std_join.jsonnet",
+		Expect: []string{
+			"[Warning|TypeMismatch|5:12-5:33] mismatched argument type for 'arr' expected 'array[string]' got 'array[number]'",
+			"[Warning|TypeMismatch|6:12-6:37] mismatched argument type for 'arr' expected 'array[array]' got 'array[string]'",
+			"[Warning|TypeMismatch|7:10-7:38] mismatched argument type for 'arr' expected 'array[string]' got 'array[array[number]]'",
 		},
 	},
 	{
@@ -42,6 +71,104 @@ var linterCases = []linterCase{
 			"[Warning|TypeMismatch|9:26-9:43] mismatched argument type for 'b' expected 'number' got 'boolean'",
 		},
 	},
+	{
+		File: "unknown_fields.jsonnet",
+		Expect: []string{
+			"[Warning|UnknownField|1:11-1:21] std has no member 'lenght', did you mean 'length'?",
+			"[Warning|UnknownField|3:11-3:21] std has no member 'zzzzzz'",
+			"[Warning|UnknownField|5:11-5:19] object has no field 'nmae', did you mean 'name'?",
+		},
+	},
+	{
+		File: "template_fields.jsonnet",
+		Expect: []string{
+			"[Warning|TypeMismatch|9:16-9:23] mismatched type for template field 'name' expected 'string' got 'number'",
+			"[Warning|TypeMismatch|9:25-9:37] mismatched type for template field 'port' expected 'number' got 'string'",
+			"[Warning|TypeMismatch|10:14-10:23] mismatched type for template field 'tags' expected 'array[string]' got 'array[number]'",
+		},
+	},
+	{
+		File: "deprecated_stdlib.jsonnet",
+		Expect: []string{
+			"[Information|Deprecated|4:12-4:28] 'std.base64Decode' is deprecated, use `std.base64DecodeBytes` and decode the string explicitly (e.g. with `std.decodeUTF8`) instead",
+			"[Information|Deprecated|5:12-5:26] 'std.base64Decode' is deprecated, use `std.base64DecodeBytes` and decode the string explicitly (e.g. with `std.decodeUTF8`) instead",
+		},
+	},
+	{
+		File: "param_defaults.jsonnet",
+		Expect: []string{
+			"[Warning|ParamDefaultCycle|2:15-2:16] default of parameter 'a' refers to parameter 'b', whose default refers back to it",
+			"[Warning|ParamDefaultCycle|2:20-2:21] default of parameter 'b' refers to parameter 'a', whose default refers back to it",
+			"[Warning|ParamDefaultCycle|3:14-3:15] default of parameter 'a' refers to itself",
+		},
+	},
+	{
+		File: "object_asserts.jsonnet",
+		Expect: []string{
+			"[Warning|RedundantCondition|6:10-6:15] object assertion is always false",
+			"[Warning|RedundantCondition|7:10-7:15] object assertion is always false",
+		},
+	},
+	{
+		File: "shadowed_vars.jsonnet",
+		Expect: []string{
+			"[Warning|ShadowedVar|1:7-1:11] local variable 'name' is redefined on line 3 before it is used",
+			// locals are recursive, so the second `count` refers to itself
+			"[Warning|ShadowedVar|4:7-4:12] local variable 'count' is redefined on line 5 before it is used",
+		},
+	},
+	{
+		File: "comparisons.jsonnet",
+		Expect: []string{
+			"[Warning|TypeMismatch|5:12-5:15] lhs of operator '<' cannot be ordered, got type 'object' (expected number, array, or string)",
+			"[Warning|TypeMismatch|6:19-6:21] rhs of operator '>=' cannot be ordered, got type 'function' (expected number, array, or string)",
+			"[Warning|TypeMismatch|7:26-7:29] rhs of operator '<=' cannot be ordered, got type 'object' (expected number, array, or string)",
+		},
+	},
+	{
+		// fields of `self` may be provided by objects inheriting from it
+		File:   "self_fields.jsonnet",
+		Expect: []string{},
+	},
+	{
+		// variables used only in error and assertion messages are used
+		File: "error_messages.jsonnet",
+		Expect: []string{
+			"[Warning|UnusedVar|10:7-10:13] unused local variable 'unused'",
+		},
+	},
+	{
+		File: "ignored_imports.jsonnet",
+		Expect: []string{
+			"[Warning|ImportNotFound|1:17-1:43] import not found: 'missing.libsonnet'",
+			"[Warning|ImportNotFound|5:15-5:39] import not found: 'other.libsonnet'",
+		},
+	},
+	{
+		File: "type_hints.jsonnet",
+		Expect: []string{
+			"[Warning|TypeMismatch|8:3-8:20] mismatched argument type for 'arr' expected 'array[string]' got 'array[number]'",
+			"[Warning|TypeMismatch|9:3-9:28] mismatched argument type for 'arr' expected 'array[string]' got 'array[boolean]'",
+			"[Warning|TypeMismatch|13:3-13:13] mismatched argument type for 'nums' expected 'array[number] | null' got 'array[string]'",
+			"[Warning|TypeMismatch|15:3-15:41] mismatched argument type for 'opts' expected '{port: number, host: string}' got 'object'",
+		},
+	},
+	{
+		File: "function_defaults.jsonnet",
+		Expect: []string{
+			"[Error|ArgumentCardinality|5:27-5:44] too many arguments in function call (5 arguments for 4 parameters)",
+			"[Error|ArgumentCardinality|6:26-6:48] argument 'a' already given positionally",
+			"[Error|ArgumentCardinality|6:26-6:48] too many arguments in function call (6 arguments for 4 parameters)",
+			"[Error|ArgumentCardinality|8:20-8:33] argument 'b' already given positionally",
+			"[Error|ArgumentCardinality|9:25-9:40] missing argument for required parameter 'b'",
+			"[Error|ArgumentCardinality|10:16-10:23] too few arguments in function call (1 arguments for 2 required parameters)",
+			"[Error|ArgumentCardinality|11:22-11:34] missing argument for required parameter 'a'",
+			"[Error|ArgumentCardinality|11:22-11:34] missing argument for required parameter 'b'",
+			"[Error|ArgumentCardinality|13:27-13:43] too few arguments in function call (2 arguments for 3 required parameters)",
+			"[Error|ArgumentCardinality|14:26-14:42] argument 'a' already given positionally",
+			"[Error|ArgumentCardinality|14:26-14:42] too few arguments in function call (2 arguments for 3 required parameters)",
+		},
+	},
 }
 
 func fmtDiags(diags []protocol.Diagnostic) string {
@@ -55,13 +182,10 @@ func fmtDiags(diags []protocol.Diagnostic) string {
 func TestLinter(t *testing.T) {
 	for _, c := range linterCases {
 		t.Run(c.File, func(t *testing.T) {
-			vm := jsonnet.MakeVM()
-			vm.Importer(&FSImporter{FS: testdata.TestDataFS})
-			root, _, err := vm.ImportAST(c.File, c.File)
+			resolver, err := analysis.NewFileResolver(testdata.TestDataFS, nil, c.File)
 			require.NoError(t, err, "must be able to import root AST")
 
-			resolver := NewResolver(root, vm)
-			diags := linter.LintAST(root, resolver)
+			diags := linter.LintAST(resolver.Root(), resolver)
 			require.Equal(t, len(c.Expect), len(diags), "mismatch in expected length of diags, got:\n%s", fmtDiags(diags))
 			for i, d := range diags {
 				assert.Equal(t, c.Expect[i], linter.FmtDiag(d), "mismatch on diag %d", i)
@@ -70,127 +194,171 @@ func TestLinter(t *testing.T) {
 	}
 }
 
-// FSImporter imports data from the filesystem.
-type FSImporter struct {
-	FS      fs.FS
-	JPaths  []string
-	fsCache map[string]*fsCacheEntry
-}
+func TestLintImportNotFoundDisabled(t *testing.T) {
+	resolver, err := analysis.NewFileResolver(testdata.TestDataFS, nil, "ignored_imports.jsonnet")
+	require.NoError(t, err)
 
-type fsCacheEntry struct {
-	contents jsonnet.Contents
-	exists   bool
+	opts := linter.DefaultOptions()
+	opts.ImportNotFound = false
+	assert.Empty(t, linter.LintASTWithOptions(resolver.Root(), resolver, opts))
 }
 
-func (importer *FSImporter) tryPath(dir, importedPath string) (found bool, contents jsonnet.Contents, foundHere string, err error) {
-	if importer.fsCache == nil {
-		importer.fsCache = make(map[string]*fsCacheEntry)
-	}
-	var absPath string
-	if filepath.IsAbs(importedPath) {
-		absPath = importedPath
-	} else {
-		absPath = filepath.Join(dir, importedPath)
-	}
-	var entry *fsCacheEntry
-	if cacheEntry, isCached := importer.fsCache[absPath]; isCached {
-		entry = cacheEntry
-	} else {
-		contentBytes, err := fs.ReadFile(importer.FS, absPath)
-		if err != nil {
-			if os.IsNotExist(err) {
-				entry = &fsCacheEntry{
-					exists: false,
-				}
-			} else {
-				return false, jsonnet.Contents{}, "", err
-			}
-		} else {
-			entry = &fsCacheEntry{
-				exists:   true,
-				contents: jsonnet.MakeContentsRaw(contentBytes),
-			}
-		}
-		importer.fsCache[absPath] = entry
-	}
-	return entry.exists, entry.contents, absPath, nil
+func TestLintMaxTernaryDepth(t *testing.T) {
+	resolver, err := analysis.NewFileResolver(testdata.TestDataFS, nil, "nested_ternaries.jsonnet")
+	require.NoError(t, err)
+
+	assert.Empty(t, linter.LintAST(resolver.Root(), resolver))
+
+	opts := linter.DefaultOptions()
+	opts.MaxTernaryDepth = 2
+	assert.Equal(t, []string{
+		"[Warning|NestedTernary|6:60-6:99] conditional is nested 3 deep (max 2), consider extracting a local or a lookup object",
+	}, fmtDiagList(linter.LintASTWithOptions(resolver.Root(), resolver, opts)))
 }
 
-// This is copied from go-jsonnet and modified to support fs.FS
-func (importer *FSImporter) Import(importedFrom, importedPath string) (contents jsonnet.Contents, foundAt string, err error) {
-	dir, _ := filepath.Split(importedFrom)
-	found, content, foundHere, err := importer.tryPath(dir, importedPath)
-	if err != nil {
-		return jsonnet.Contents{}, "", err
-	}
+func TestLintMaxStringConcat(t *testing.T) {
+	resolver, err := analysis.NewFileResolver(testdata.TestDataFS, nil, "string_concat.jsonnet")
+	require.NoError(t, err)
 
-	for i := len(importer.JPaths) - 1; !found && i >= 0; i-- {
-		found, content, foundHere, err = importer.tryPath(importer.JPaths[i], importedPath)
-		if err != nil {
-			return jsonnet.Contents{}, "", err
-		}
-	}
+	assert.Empty(t, linter.LintAST(resolver.Root(), resolver))
 
-	if !found {
-		return jsonnet.Contents{}, "", fmt.Errorf("couldn't open import %#v: no match locally or in the Jsonnet library paths", importedPath)
-	}
-	return content, foundHere, nil
+	opts := linter.DefaultOptions()
+	opts.MaxStringConcat = 3
+	assert.Equal(t, []string{
+		"[Warning|StringConcat|5:9-5:52] string is built from 5 concatenations (max 3), consider std.format",
+	}, fmtDiagList(linter.LintASTWithOptions(resolver.Root(), resolver, opts)))
 }
 
-type resolver struct {
-	// rootURI uri.URI
-	root ast.Node
-	// A map of filenames from node.Loc().Filename to the root AST node
-	// This is used to find the root AST node of any node.
-	stackCache map[ast.Node][]ast.Node
-	roots      map[string]ast.Node
-	vm         *jsonnet.VM
-}
+func TestLintStrictTemplateFields(t *testing.T) {
+	resolver, err := analysis.NewFileResolver(testdata.TestDataFS, nil, "strict_template_fields.jsonnet")
+	require.NoError(t, err)
 
-var _ = (analysis.Resolver)(new(resolver))
+	assert.Empty(t, linter.LintAST(resolver.Root(), resolver))
 
-func NewResolver(root ast.Node, vm *jsonnet.VM) *resolver {
+	opts := linter.DefaultOptions()
+	opts.StrictTemplateFields = true
+	assert.Equal(t, []string{
+		"[Warning|UnknownField|6:27-6:37] template has no field 'prot', did you mean 'port'?",
+		"[Warning|UnknownField|7:27-7:38] template has no field 'replicas'",
+	}, fmtDiagList(linter.LintASTWithOptions(resolver.Root(), resolver, opts)))
+}
 
-	return &resolver{
-		root:       root,
-		roots:      map[string]ast.Node{root.Loc().FileName: root},
-		vm:         vm,
-		stackCache: map[ast.Node][]ast.Node{},
+func TestLintSelfOutsideObject(t *testing.T) {
+	// The jsonnet parser rejects `self` outside of an object, so the AST is built by hand
+	loc := func(col int) ast.LocationRange {
+		return ast.LocationRange{FileName: "anon", Begin: ast.Location{Line: 1, Column: col}, End: ast.Location{Line: 1, Column: col + 4}}
+	}
+	inObject, err := jsonnet.SnippetToAST("anon", "{a: self.b, b: 1}")
+	require.NoError(t, err)
+	root := &ast.Array{
+		NodeBase: ast.NodeBase{LocRange: ast.LocationRange{FileName: "anon", Begin: ast.Location{Line: 1, Column: 1}, End: ast.Location{Line: 1, Column: 40}}},
+		Elements: []ast.CommaSeparatedExpr{
+			{Expr: &ast.Index{NodeBase: ast.NodeBase{LocRange: loc(2)}, Target: &ast.Self{NodeBase: ast.NodeBase{LocRange: loc(2)}}, Index: &ast.LiteralString{Value: "x"}}},
+			{Expr: &ast.SuperIndex{NodeBase: ast.NodeBase{LocRange: loc(10)}, Index: &ast.LiteralString{Value: "y"}}},
+			{Expr: inObject},
+		},
 	}
+
+	diags := linter.LintAST(root, analysis.NewRootResolver(root, nil))
+	assert.Equal(t, []string{
+		"[Error|InvalidSelf|1:2-1:6] cannot use 'self' outside of an object",
+		"[Error|InvalidSelf|1:10-1:14] cannot use 'super' outside of an object",
+	}, fmtDiagList(diags))
 }
 
-func (r *resolver) NodeAt(loc ast.Location) (node ast.Node, stack []ast.Node) {
-	stack = analysis.StackAtLoc(r.root, loc)
-	if len(stack) == 0 {
-		return nil, nil
-	}
-	node = stack[len(stack)-1]
-	r.stackCache[node] = stack
-	return node, stack
+func TestLintIndentation(t *testing.T) {
+	src := `{
+  a: 1,
+   b: [
+    1, 2,
+     3,
+  ],
+  c: {d: 1, e: 2},
+    f: {
+      g: 1,
+    },
+  /* comments are not indentation */ h: 1,
+` + "\ti: 1,\n\t j: 1,\n}"
+	root, err := jsonnet.SnippetToAST("anon", src)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"[Information|Indentation|3:1-3:4] indentation of 3 is not a multiple of 2",
+		"[Information|Indentation|5:1-5:6] indentation of 5 is not a multiple of 2",
+		"[Information|Indentation|13:1-13:3] indentation of 3 is not a multiple of 2",
+	}, fmtDiagList(linter.LintIndentation(root, src, 2)))
+	assert.Equal(t, []string{
+		"[Information|Indentation|2:1-2:3] indentation of 2 is not a multiple of 4",
+		"[Information|Indentation|3:1-3:4] indentation of 3 is not a multiple of 4",
+		"[Information|Indentation|5:1-5:6] indentation of 5 is not a multiple of 4",
+		"[Information|Indentation|7:1-7:3] indentation of 2 is not a multiple of 4",
+		"[Information|Indentation|9:1-9:7] indentation of 6 is not a multiple of 4",
+		"[Information|Indentation|13:1-13:3] indentation of 5 is not a multiple of 4",
+	}, fmtDiagList(linter.LintIndentation(root, src, 4)))
 }
 
-func (r *resolver) Vars(from ast.Node) analysis.VarMap {
-	if from == nil || from.Loc() == nil {
-		return analysis.VarMap{}
-	}
-	root := r.roots[from.Loc().FileName]
-	if root == nil {
-		panic(fmt.Errorf("invariant: resolving var from %T where no root was imported", from))
-	}
-	if stk := r.stackCache[from]; len(stk) > 0 {
-		return analysis.StackVars(stk)
+func TestLintMixedIndentation(t *testing.T) {
+	src := "{\n  a: 1,\n\tb: 2,\n\t  c: 3,\n  \td: 4,\n}\n"
+	assert.Equal(t, []string{
+		"[Information|MixedIndentation|4:1-4:4] indentation mixes tabs and spaces",
+		"[Information|MixedIndentation|5:1-5:4] indentation mixes tabs and spaces",
+	}, fmtDiagList(linter.LintMixedIndentation(src)))
+}
+
+func fmtDiagList(diags []protocol.Diagnostic) []string {
+	res := []string{}
+	for _, d := range diags {
+		res = append(res, linter.FmtDiag(d))
 	}
-	stk := analysis.StackAtNode(root, from)
-	return analysis.StackVars(stk)
+	return res
 }
 
-func (r *resolver) Import(from, path string) ast.Node {
-	root, _, err := r.vm.ImportAST(from, path)
-	if err != nil {
-		return nil
+func TestWriteSARIF(t *testing.T) {
+	diags := map[string][]protocol.Diagnostic{
+		"app/main.jsonnet": {
+			{Range: protocol.Range{Start: protocol.Position{Line: 0, Character: 6}, End: protocol.Position{Line: 0, Character: 12}}, Severity: protocol.DiagnosticSeverityWarning, Code: linter.UnusedVar, Message: "unused local variable 'unused'"},
+			{Range: protocol.Range{Start: protocol.Position{Line: 2, Character: 4}, End: protocol.Position{Line: 2, Character: 5}}, Severity: protocol.DiagnosticSeverityError, Message: "parse error"},
+		},
 	}
-	if root != nil {
-		r.roots[root.Loc().FileName] = root
+	out := &bytes.Buffer{}
+	require.NoError(t, linter.WriteSARIF(out, diags))
+
+	var log struct {
+		Version string `json:"version"`
+		Runs    []struct {
+			Tool struct {
+				Driver struct {
+					Rules []struct {
+						ID string `json:"id"`
+					} `json:"rules"`
+				} `json:"driver"`
+			} `json:"tool"`
+			Results []struct {
+				RuleID    string `json:"ruleId"`
+				Level     string `json:"level"`
+				Locations []struct {
+					PhysicalLocation struct {
+						ArtifactLocation struct {
+							URI string `json:"uri"`
+						} `json:"artifactLocation"`
+						Region map[string]int `json:"region"`
+					} `json:"physicalLocation"`
+				} `json:"locations"`
+			} `json:"results"`
+		} `json:"runs"`
 	}
-	return root
+	require.NoError(t, json.Unmarshal(out.Bytes(), &log))
+	assert.Equal(t, "2.1.0", log.Version)
+	require.Len(t, log.Runs, 1)
+	run := log.Runs[0]
+	require.Len(t, run.Tool.Driver.Rules, 1)
+	assert.Equal(t, "UnusedVar", run.Tool.Driver.Rules[0].ID)
+	require.Len(t, run.Results, 2)
+	assert.Equal(t, "UnusedVar", run.Results[0].RuleID)
+	assert.Equal(t, "warning", run.Results[0].Level)
+	assert.Equal(t, "app/main.jsonnet", run.Results[0].Locations[0].PhysicalLocation.ArtifactLocation.URI)
+	assert.Equal(t, map[string]int{"startLine": 1, "startColumn": 7, "endLine": 1, "endColumn": 13}, run.Results[0].Locations[0].PhysicalLocation.Region)
+	// diagnostics without a code have no rule
+	assert.Equal(t, "", run.Results[1].RuleID)
+	assert.Equal(t, "error", run.Results[1].Level)
 }
@@ -0,0 +1,143 @@
+package linter
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"go.lsp.dev/protocol"
+)
+
+// The subset of SARIF 2.1.0 read by code scanning tools, like GitHub code scanning
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID               string       `json:"id"`
+	ShortDescription sarifMessage `json:"shortDescription"`
+	FullDescription  sarifMessage `json:"fullDescription"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId,omitempty"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           sarifRegion           `json:"region"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+// sarifRegion is 1-based, with an exclusive end column like the diagnostic ranges
+type sarifRegion struct {
+	StartLine   uint32 `json:"startLine"`
+	StartColumn uint32 `json:"startColumn"`
+	EndLine     uint32 `json:"endLine"`
+	EndColumn   uint32 `json:"endColumn"`
+}
+
+func sarifLevel(sev protocol.DiagnosticSeverity) string {
+	switch sev {
+	case protocol.DiagnosticSeverityError:
+		return "error"
+	case protocol.DiagnosticSeverityWarning:
+		return "warning"
+	}
+	return "note"
+}
+
+// WriteSARIF writes the diagnostics of each file as a SARIF 2.1.0 log. The files are keyed by
+// their path relative to the root of the repository, with `/` separators. Rules are the codes of
+// the diagnostics, described by their Explanations. Diagnostics without a code, like parse
+// errors, have no rule.
+func WriteSARIF(w io.Writer, diags map[string][]Diagnostic) error {
+	files := []string{}
+	for file := range diags {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+
+	run := sarifRun{
+		Tool: sarifTool{Driver: sarifDriver{
+			Name:           "jsonnet-lsp",
+			InformationURI: "https://github.com/carlverge/jsonnet-lsp",
+			Rules:          []sarifRule{},
+		}},
+		Results: []sarifResult{},
+	}
+	rules := map[string]bool{}
+	for _, file := range files {
+		for _, d := range diags[file] {
+			code := ""
+			if d.Code != nil {
+				code = fmt.Sprint(d.Code)
+			}
+			if code != "" && !rules[code] {
+				rules[code] = true
+				explanation := Explanations[DiagCode(code)]
+				run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, sarifRule{
+					ID:               code,
+					ShortDescription: sarifMessage{Text: strings.SplitN(explanation, "\n", 2)[0]},
+					FullDescription:  sarifMessage{Text: explanation},
+				})
+			}
+			run.Results = append(run.Results, sarifResult{
+				RuleID:  code,
+				Level:   sarifLevel(d.Severity),
+				Message: sarifMessage{Text: d.Message},
+				Locations: []sarifLocation{{PhysicalLocation: sarifPhysicalLocation{
+					ArtifactLocation: sarifArtifactLocation{URI: file},
+					Region: sarifRegion{
+						StartLine:   d.Range.Start.Line + 1,
+						StartColumn: d.Range.Start.Character + 1,
+						EndLine:     d.Range.End.Line + 1,
+						EndColumn:   d.Range.End.Character + 1,
+					},
+				}}},
+			})
+		}
+	}
+	sort.Slice(run.Tool.Driver.Rules, func(i, j int) bool { return run.Tool.Driver.Rules[i].ID < run.Tool.Driver.Rules[j].ID })
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(sarifLog{
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Version: "2.1.0",
+		Runs:    []sarifRun{run},
+	})
+}
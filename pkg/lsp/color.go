@@ -0,0 +1,157 @@
+package lsp
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/carlverge/jsonnet-lsp/pkg/analysis"
+	"github.com/google/go-jsonnet/ast"
+	"go.lsp.dev/protocol"
+)
+
+var (
+	hexColorPattern = regexp.MustCompile(`^#([0-9a-fA-F]{3,4}|[0-9a-fA-F]{6}|[0-9a-fA-F]{8})$`)
+	rgbColorPattern = regexp.MustCompile(`^rgba?\(\s*(\d{1,3})\s*,\s*(\d{1,3})\s*,\s*(\d{1,3})\s*(?:,\s*(\d*\.?\d+)\s*)?\)$`)
+)
+
+// parseColor parses `#rgb`, `#rgba`, `#rrggbb`, `#rrggbbaa`, `rgb(r, g, b)` and `rgba(r, g, b, a)`
+func parseColor(s string) (protocol.Color, bool) {
+	if hexColorPattern.MatchString(s) {
+		hex := s[1:]
+		if len(hex) <= 4 {
+			// short form, each digit is repeated
+			long := ""
+			for _, c := range hex {
+				long += string(c) + string(c)
+			}
+			hex = long
+		}
+		if len(hex) == 6 {
+			hex += "ff"
+		}
+		val, err := strconv.ParseUint(hex, 16, 32)
+		if err != nil {
+			return protocol.Color{}, false
+		}
+		return protocol.Color{
+			Red:   float64(val>>24&0xff) / 255,
+			Green: float64(val>>16&0xff) / 255,
+			Blue:  float64(val>>8&0xff) / 255,
+			Alpha: float64(val&0xff) / 255,
+		}, true
+	}
+
+	m := rgbColorPattern.FindStringSubmatch(s)
+	if m == nil || (strings.HasPrefix(s, "rgba") != (m[4] != "")) {
+		return protocol.Color{}, false
+	}
+	res := protocol.Color{Alpha: 1}
+	for i, c := range []*float64{&res.Red, &res.Green, &res.Blue} {
+		val, _ := strconv.Atoi(m[i+1])
+		if val > 255 {
+			return protocol.Color{}, false
+		}
+		*c = float64(val) / 255
+	}
+	if m[4] != "" {
+		alpha, err := strconv.ParseFloat(m[4], 64)
+		if err != nil || alpha > 1 {
+			return protocol.Color{}, false
+		}
+		res.Alpha = alpha
+	}
+	return res, true
+}
+
+func colorByte(c float64) int {
+	return int(math.Round(math.Max(0, math.Min(1, c)) * 255))
+}
+
+// hexColor formats the color as `#rrggbb`, or `#rrggbbaa` if it is not opaque
+func hexColor(c protocol.Color) string {
+	res := fmt.Sprintf("#%02x%02x%02x", colorByte(c.Red), colorByte(c.Green), colorByte(c.Blue))
+	if c.Alpha < 1 {
+		res += fmt.Sprintf("%02x", colorByte(c.Alpha))
+	}
+	return res
+}
+
+// rgbColor formats the color as `rgb(r, g, b)`, or `rgba(r, g, b, a)` if it is not opaque
+func rgbColor(c protocol.Color) string {
+	if c.Alpha < 1 {
+		return fmt.Sprintf("rgba(%d, %d, %d, %s)", colorByte(c.Red), colorByte(c.Green), colorByte(c.Blue), strconv.FormatFloat(math.Round(c.Alpha*100)/100, 'f', -1, 64))
+	}
+	return fmt.Sprintf("rgb(%d, %d, %d)", colorByte(c.Red), colorByte(c.Green), colorByte(c.Blue))
+}
+
+// stringContentRange returns the range of the contents of a quoted string literal on a single
+// line, without the quotes. Returns false for block and verbatim strings, and strings with
+// escapes, where the value does not match the source text.
+func stringContentRange(lines []string, str *ast.LiteralString) (ast.LocationRange, bool) {
+	rng := str.LocRange
+	if !rng.IsSet() || rng.Begin.Line != rng.End.Line || rng.Begin.Line > len(lines) {
+		return rng, false
+	}
+	if str.Kind != ast.StringDouble && str.Kind != ast.StringSingle {
+		return rng, false
+	}
+	rng.Begin.Column++
+	rng.End.Column--
+	if rng.End.Column-1 > len([]rune(lines[rng.Begin.Line-1])) || sourceSpan(lines, rng) != str.Value {
+		return rng, false
+	}
+	return rng, true
+}
+
+// DocumentColor finds the string literals that are colors, like `'#ff0000'`
+func (s *Server) DocumentColor(ctx context.Context, params *protocol.DocumentColorParams) ([]protocol.ColorInformation, error) {
+	res := []protocol.ColorInformation{}
+	resolver := s.NewResolver(params.TextDocument.URI)
+	current := s.overlay.Current(params.TextDocument.URI)
+	if resolver == nil || current == nil {
+		return res, nil
+	}
+
+	lines := strings.Split(current.Contents, "\n")
+	analysis.WalkStack(resolver.Root(), func(n ast.Node, _ []ast.Node) bool {
+		str, ok := n.(*ast.LiteralString)
+		if !ok {
+			return true
+		}
+		color, ok := parseColor(str.Value)
+		if !ok {
+			return true
+		}
+		if rng, ok := stringContentRange(lines, str); ok {
+			res = append(res, protocol.ColorInformation{Range: rangeToProto(rng), Color: color})
+		}
+		return true
+	})
+	return res, nil
+}
+
+// ColorPresentation offers the color as hex and as rgb, in the format of the replaced text first
+func (s *Server) ColorPresentation(ctx context.Context, params *protocol.ColorPresentationParams) ([]protocol.ColorPresentation, error) {
+	labels := []string{hexColor(params.Color), rgbColor(params.Color)}
+	if current := s.overlay.Current(params.TextDocument.URI); current != nil {
+		lines := strings.Split(current.Contents, "\n")
+		pos := protoToPos(params.Range.Start)
+		if pos.Line <= len(lines) && pos.Column-1 <= len([]rune(lines[pos.Line-1])) &&
+			strings.HasPrefix(string([]rune(lines[pos.Line-1])[pos.Column-1:]), "rgb") {
+			labels[0], labels[1] = labels[1], labels[0]
+		}
+	}
+
+	res := []protocol.ColorPresentation{}
+	for _, label := range labels {
+		res = append(res, protocol.ColorPresentation{
+			Label:    label,
+			TextEdit: &protocol.TextEdit{Range: params.Range, NewText: label},
+		})
+	}
+	return res, nil
+}
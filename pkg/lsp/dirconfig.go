@@ -0,0 +1,160 @@
+package lsp
+
+import (
+	"context"
+	"encoding/json"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"go.lsp.dev/uri"
+)
+
+// dirConfigFile overrides the configuration for the files in its directory and below it, like
+// different jpaths or lint settings for a subtree of a monorepo. Settings missing from the file
+// keep the value of the configuration of the parent directory. Only jpaths and the lint and
+// formatting settings can be set, so that a file checked into an untrusted repository cannot
+// turn on evaluation or turn off its sandbox.
+const dirConfigFile = ".jsonnet-lsp.json"
+
+// dirConfigs caches the configuration files of directories, and the configurations of
+// directories merged from them. Merged configurations are dropped when the configuration of the
+// server or any configuration file changes.
+type dirConfigs struct {
+	lock sync.Mutex
+	// the contents of the configuration file of each directory, nil if it has none
+	files  map[string][]byte
+	base   *Configuration
+	merged map[string]*dirConfig
+}
+
+// dirConfig is the configuration of a directory, merged from the configuration files of the
+// directories from the workspace root to it
+type dirConfig struct {
+	cfg *Configuration
+	// the absolute jpaths of the configuration files, nearest first. See OverlayImporter.SearchDirs.
+	jpaths []string
+}
+
+// fileConfig returns the configuration for a file: the server configuration, overridden by the
+// configuration files of the directories from the workspace root to the file, nearest last
+func (s *Server) fileConfig(u uri.URI) *Configuration {
+	return s.dirConfig(u).cfg
+}
+
+// dirConfig returns the merged configuration of the directory of a file
+func (s *Server) dirConfig(u uri.URI) *dirConfig {
+	cfg := s.config
+	rel, err := filepath.Rel(s.rootURI.Filename(), filepath.Dir(u.Filename()))
+	if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+		return &dirConfig{cfg: cfg}
+	}
+
+	dc := &s.dirConfigs
+	dc.lock.Lock()
+	defer dc.lock.Unlock()
+	if dc.base != cfg || dc.merged == nil {
+		dc.base, dc.merged = cfg, map[string]*dirConfig{}
+	}
+	if dc.files == nil {
+		dc.files = map[string][]byte{}
+	}
+	if res, ok := dc.merged[rel]; ok {
+		return res
+	}
+
+	dirs := []string{"."}
+	if rel != "." {
+		parts := strings.Split(filepath.ToSlash(rel), "/")
+		for i := range parts {
+			dirs = append(dirs, filepath.FromSlash(strings.Join(parts[:i+1], "/")))
+		}
+	}
+
+	res := &dirConfig{cfg: cfg}
+	for _, dir := range dirs {
+		data, ok := dc.files[dir]
+		if !ok {
+			data, _ = fs.ReadFile(s.rootFS, filepath.Join(dir, dirConfigFile))
+			dc.files[dir] = data
+		}
+		if data == nil {
+			continue
+		}
+		merged, err := mergeDirConfig(res, filepath.Join(s.rootURI.Filename(), dir), data)
+		if err != nil {
+			logf("failed to parse %s: %v", filepath.Join(dir, dirConfigFile), err)
+			continue
+		}
+		res = merged
+	}
+	dc.merged[rel] = res
+	return res
+}
+
+// mergeDirConfig returns a copy of the configuration with the settings of the configuration file
+// of `dir`. Relative jpaths are relative to `dir`, and are searched before the jpaths of the
+// parent directories.
+func mergeDirConfig(base *dirConfig, dir string, data []byte) (*dirConfig, error) {
+	baseData, err := json.Marshal(base.cfg)
+	if err != nil {
+		return nil, err
+	}
+	res := &Configuration{}
+	if err := json.Unmarshal(baseData, res); err != nil {
+		return nil, err
+	}
+	override := struct {
+		JPaths []string           `json:"jpaths"`
+		Diag   *DiagConfiguration `json:"diag"`
+		Fmt    *FmtConfiguration  `json:"fmt"`
+	}{Diag: &res.Diag, Fmt: &res.Fmt}
+	if err := json.Unmarshal(data, &override); err != nil {
+		return nil, err
+	}
+	// evaluation runs the files, which is not a lint setting
+	res.Diag.Evaluate = base.cfg.Diag.Evaluate
+	res.Diag.EvaluateOn = base.cfg.Diag.EvaluateOn
+	res.Diag.EvaluateGate = base.cfg.Diag.EvaluateGate
+
+	jpaths := []string{}
+	for _, jpath := range override.JPaths {
+		if !filepath.IsAbs(jpath) {
+			jpath = filepath.Join(dir, jpath)
+		}
+		jpaths = append(jpaths, jpath)
+	}
+	return &dirConfig{cfg: res, jpaths: append(jpaths, base.jpaths...)}, nil
+}
+
+// dirJPaths returns the jpaths of the configuration files of the directories of a file, see
+// OverlayImporter.SearchDirs. The jpaths of the server configuration are searched after the
+// search paths of the workspace.
+func (s *Server) dirJPaths(from string) []string {
+	if from == "" || !filepath.IsAbs(from) {
+		return nil
+	}
+	return s.dirConfig(uri.File(from)).jpaths
+}
+
+// invalidateDirConfig drops the cached configuration file of the directory, and re-runs
+// diagnostics on all open files with the new configuration
+func (s *Server) invalidateDirConfig(ctx context.Context, file uri.URI) {
+	rel, err := filepath.Rel(s.rootURI.Filename(), filepath.Dir(file.Filename()))
+	if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+		return
+	}
+	s.dirConfigs.lock.Lock()
+	delete(s.dirConfigs.files, rel)
+	s.dirConfigs.merged = nil
+	s.dirConfigs.lock.Unlock()
+
+	// the cached VMs have the external variables of the previous configuration
+	s.vmlock.Lock()
+	s.vms = nil
+	s.vmlock.Unlock()
+	for _, u := range s.overlay.Open() {
+		s.overlay.Refresh(u, s.processFileUpdateFn(ctx, u, s.fileConfig(u).Diag.EvaluateOn))
+	}
+}
@@ -0,0 +1,148 @@
+package lsp
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/carlverge/jsonnet-lsp/pkg/analysis"
+	"github.com/carlverge/jsonnet-lsp/pkg/overlay"
+	"github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/ast"
+	"go.lsp.dev/uri"
+)
+
+// DoctorParams is the environment checked by Doctor
+type DoctorParams struct {
+	// Root is the workspace root. If empty, it is the nearest directory above File (or the
+	// working directory) containing a root marker.
+	Root string
+	// JPaths are additional search paths, like the `jpaths` setting
+	JPaths []string
+	// ImportRoots map absolute import paths to directories, like the `importRoots` setting
+	ImportRoots map[string]string
+	// File is parsed and its imports resolved, if set
+	File string
+}
+
+// findProjectRoot returns the nearest directory from `start` upwards containing one of the
+// markers, or `start` if there is none
+func findProjectRoot(start string, markers []string) string {
+	for dir := start; ; dir = filepath.Dir(dir) {
+		for _, marker := range markers {
+			if _, err := os.Stat(filepath.Join(dir, marker)); err == nil {
+				return dir
+			}
+		}
+		if filepath.Dir(dir) == dir {
+			return start
+		}
+	}
+}
+
+// workspaceRoot returns the absolute path of the root directory. If it is empty, the root is
+// the nearest directory above the file (or the working directory) containing a root marker.
+func workspaceRoot(root, file string, markers []string) (string, error) {
+	if root == "" {
+		start, err := os.Getwd()
+		if err != nil {
+			return "", err
+		}
+		if file != "" {
+			start = filepath.Dir(file)
+		}
+		root = findProjectRoot(start, markers)
+	}
+	return filepath.Abs(root)
+}
+
+// Doctor writes how the server sees the environment, and where each import in the file resolves
+// to using the same importer as the server. Returns an error if the file cannot be parsed or
+// any of its imports cannot be found.
+func Doctor(w io.Writer, params DoctorParams) error {
+	cfg := defaultConfiguration()
+	cfg.ImportRoots = params.ImportRoots
+
+	file := params.File
+	if file != "" {
+		abs, err := filepath.Abs(file)
+		if err != nil {
+			return err
+		}
+		file = abs
+	}
+
+	root, err := workspaceRoot(params.Root, file, cfg.RootMarkers)
+	if err != nil {
+		return err
+	}
+
+	rootFS := os.DirFS(root)
+	searchPaths := workspaceSearchPaths(rootFS)
+	importer := newOverlayImporter(overlay.NewOverlay(), uri.File(root), rootFS, searchPaths, cfg)
+	importer.SetJPaths(params.JPaths)
+
+	list := func(elems []string) string {
+		if len(elems) == 0 {
+			return "(none)"
+		}
+		return strings.Join(elems, ", ")
+	}
+	fmt.Fprintf(w, "go-jsonnet:   %s\n", jsonnet.Version())
+	fmt.Fprintf(w, "root:         %s\n", root)
+	fmt.Fprintf(w, "root markers: %s\n", list(cfg.RootMarkers))
+	fmt.Fprintf(w, "search paths: %s\n", list(searchPaths))
+	fmt.Fprintf(w, "jpaths:       %s\n", list(params.JPaths))
+	roots := []string{}
+	for prefix, dir := range params.ImportRoots {
+		roots = append(roots, prefix+"="+dir)
+	}
+	sort.Strings(roots)
+	fmt.Fprintf(w, "import roots: %s\n", list(roots))
+	if file == "" {
+		return nil
+	}
+
+	fmt.Fprintf(w, "file:         %s\n", file)
+	if projectPath := importer.projectRoot(file); projectPath != "" {
+		fmt.Fprintf(w, "project root: %s\n", projectPath)
+	}
+	if jpaths := importer.fileJPaths(file); len(jpaths) > 0 {
+		fmt.Fprintf(w, "file jpaths:  %s\n", list(jpaths))
+	}
+	fmt.Fprintf(w, "search order: %s\n", list(importer.SearchDirs(file)))
+
+	contents, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	fileAST, err := jsonnet.SnippetToAST(file, string(contents))
+	if err != nil {
+		fmt.Fprintf(w, "parse:        %v\n", err)
+		return fmt.Errorf("cannot parse '%s'", file)
+	}
+	fmt.Fprintf(w, "parse:        ok\n")
+
+	notFound := 0
+	analysis.WalkStack(fileAST, func(n ast.Node, _ []ast.Node) bool {
+		path := importedFile(n)
+		if path == "" {
+			return true
+		}
+		line := n.Loc().Begin.Line
+		if _, foundAt, err := importer.Import(file, path); err != nil {
+			notFound++
+			fmt.Fprintf(w, "  %d: '%s' not found: %v\n", line, path, err)
+		} else {
+			fmt.Fprintf(w, "  %d: '%s' -> %s\n", line, path, foundAt)
+		}
+		return true
+	})
+	if notFound > 0 {
+		return fmt.Errorf("%d imports not found", notFound)
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package lsp
+
+import (
+	"fmt"
+
+	"github.com/carlverge/jsonnet-lsp/pkg/linter"
+	"go.lsp.dev/protocol"
+	"go.lsp.dev/uri"
+)
+
+// setPublishedDiagnostics keeps the diagnostics last published for the file, to explain them on hover
+func (s *Server) setPublishedDiagnostics(u uri.URI, diags []protocol.Diagnostic) {
+	s.diagLock.Lock()
+	defer s.diagLock.Unlock()
+	if s.published == nil {
+		s.published = map[uri.URI][]protocol.Diagnostic{}
+	}
+	if diags == nil {
+		delete(s.published, u)
+		return
+	}
+	s.published[u] = diags
+}
+
+func positionInRange(pos protocol.Position, rng protocol.Range) bool {
+	afterStart := pos.Line > rng.Start.Line || (pos.Line == rng.Start.Line && pos.Character >= rng.Start.Character)
+	beforeEnd := pos.Line < rng.End.Line || (pos.Line == rng.End.Line && pos.Character <= rng.End.Character)
+	return afterStart && beforeEnd
+}
+
+// explainDiagnostics explains the codes of the published diagnostics at the position, see
+// linter.Explanations. Returns an empty string if there are none.
+func (s *Server) explainDiagnostics(u uri.URI, pos protocol.Position) string {
+	s.diagLock.Lock()
+	diags := s.published[u]
+	s.diagLock.Unlock()
+
+	res := ""
+	seen := map[linter.DiagCode]bool{}
+	for _, d := range diags {
+		code := linter.DiagCode(fmt.Sprint(d.Code))
+		explanation, ok := linter.Explanations[code]
+		if !ok || seen[code] || !positionInRange(pos, d.Range) {
+			continue
+		}
+		seen[code] = true
+		res += fmt.Sprintf("\n\n%s: %s\n%s", code, d.Message, explanation)
+	}
+	return res
+}
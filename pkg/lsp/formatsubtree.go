@@ -0,0 +1,95 @@
+package lsp
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/google/go-jsonnet/ast"
+	"github.com/google/go-jsonnet/formatter"
+	"go.lsp.dev/protocol"
+	"go.lsp.dev/uri"
+)
+
+type FormatSubtreeParams struct {
+	TextDocument *protocol.TextDocumentIdentifier `json:"textDocument"`
+	Position     protocol.Position                `json:"position"`
+}
+
+// containerAtPos returns the innermost object or array containing the position
+func containerAtPos(stack []ast.Node) ast.Node {
+	for i := len(stack) - 1; i >= 0; i-- {
+		switch n := stack[i].(type) {
+		case *ast.DesugaredObject, *ast.Array:
+			if n.Loc().IsSet() {
+				return n
+			}
+		}
+	}
+	return nil
+}
+
+// sourceSpan returns the source text of the range
+func sourceSpan(lines []string, r ast.LocationRange) string {
+	first, last := []rune(lines[r.Begin.Line-1]), []rune(lines[r.End.Line-1])
+	if r.Begin.Line == r.End.Line {
+		return string(first[r.Begin.Column-1 : r.End.Column-1])
+	}
+	res := []string{string(first[r.Begin.Column-1:])}
+	res = append(res, lines[r.Begin.Line:r.End.Line-1]...)
+	res = append(res, string(last[:r.End.Column-1]))
+	return strings.Join(res, "\n")
+}
+
+// formatSubtreeEdit formats the source of `node` on its own, and indents the result to match
+// the line the node starts on.
+func formatSubtreeEdit(contents string, node ast.Node, opts formatter.Options, padding ObjectPadding) (*protocol.TextEdit, error) {
+	lines := strings.Split(contents, "\n")
+	rng := *node.Loc()
+	if rng.End.Line > len(lines) {
+		return nil, fmt.Errorf("document changed since it was parsed")
+	}
+
+	out, err := formatJsonnet(rng.FileName, sourceSpan(lines, rng), opts, padding)
+	if err != nil {
+		return nil, err
+	}
+
+	startLine := lines[rng.Begin.Line-1]
+	indent := startLine[:len(startLine)-len(strings.TrimLeft(startLine, " \t"))]
+	outLines := strings.Split(strings.TrimRight(out, "\n"), "\n")
+	for i := 1; i < len(outLines); i++ {
+		if outLines[i] != "" {
+			outLines[i] = indent + outLines[i]
+		}
+	}
+
+	return &protocol.TextEdit{
+		Range:   rangeToProto(rng),
+		NewText: strings.Join(outLines, "\n"),
+	}, nil
+}
+
+// FormatSubtree formats only the innermost object or array at the position
+func (s *Server) FormatSubtree(ctx context.Context, params *FormatSubtreeParams) (*protocol.WorkspaceEdit, error) {
+	resolver := s.NewResolver(params.TextDocument.URI)
+	current := s.overlay.Parsed(params.TextDocument.URI)
+	if resolver == nil || current == nil {
+		return nil, fmt.Errorf("cannot parse file '%s'", params.TextDocument.URI.Filename())
+	}
+
+	_, stack := resolver.NodeAt(protoToPos(params.Position))
+	node := containerAtPos(stack)
+	if node == nil {
+		return nil, fmt.Errorf("no object or array at position")
+	}
+
+	cfg := s.fileConfig(params.TextDocument.URI)
+	edit, err := formatSubtreeEdit(current.Contents, node, cfg.FormatterOptions(), cfg.ObjectPadding())
+	if err != nil {
+		return nil, err
+	}
+	return &protocol.WorkspaceEdit{
+		Changes: map[uri.URI][]protocol.TextEdit{params.TextDocument.URI: {*edit}},
+	}, nil
+}
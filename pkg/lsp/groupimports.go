@@ -0,0 +1,102 @@
+package lsp
+
+import (
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Import groups, in the order they are written
+const (
+	// imports from jpaths, vendor directories and import roots
+	importGroupLibrary = iota
+	// imports from the workspace root or the nested project root
+	importGroupWorkspace
+	// imports relative to the importing file, and imports that are not found
+	importGroupLocal
+	importGroupCount
+)
+
+// importLinePattern matches a formatted top level import, like `local a = import 'a.libsonnet';`
+var importLinePattern = regexp.MustCompile(`^local\s+([_a-zA-Z][_a-zA-Z0-9]*)\s*=\s*import(?:str|bin)?\s+(?:'([^'\\]*)'|"([^"\\]*)");$`)
+
+// importGroup classifies the import of `path` from the file by the directory it resolves from
+func (s *Server) importGroup(from, path string) int {
+	_, foundAt, err := s.importer.Import(from, path)
+	switch {
+	case err != nil:
+		return importGroupLocal
+	case filepath.IsAbs(path):
+		// absolute imports are mapped through the import roots
+		return importGroupLibrary
+	case foundAt == filepath.Join(filepath.Dir(from), path):
+		return importGroupLocal
+	}
+	for _, dir := range []string{s.importer.projectRoot(from), s.rootURI.Filename()} {
+		if dir != "" && foundAt == filepath.Join(dir, path) {
+			return importGroupWorkspace
+		}
+	}
+	return importGroupLibrary
+}
+
+// groupImports separates the imports at the top of formatted contents into groups by where they
+// resolve from (see importGroup), with a blank line between groups. Imports are sorted by path
+// within a group, like the formatter does. The contents are unchanged if two imports bind the
+// same name, as reordering them would change which one is used.
+func (s *Server) groupImports(filename, contents string) string {
+	lines := strings.Split(contents, "\n")
+
+	// skip the comments at the head of the file
+	start := 0
+	for start < len(lines) && !importLinePattern.MatchString(lines[start]) {
+		line := strings.TrimSpace(lines[start])
+		if line != "" && !strings.HasPrefix(line, "//") && !strings.HasPrefix(line, "#") {
+			return contents
+		}
+		start++
+	}
+
+	type importLine struct {
+		line, path string
+	}
+	groups := make([][]importLine, importGroupCount)
+	names := map[string]bool{}
+	end := start
+	for ; end < len(lines); end++ {
+		if lines[end] == "" {
+			continue
+		}
+		m := importLinePattern.FindStringSubmatch(lines[end])
+		if m == nil {
+			break
+		}
+		if names[m[1]] {
+			return contents
+		}
+		names[m[1]] = true
+		path := m[2] + m[3]
+		group := s.importGroup(filename, path)
+		groups[group] = append(groups[group], importLine{line: lines[end], path: path})
+	}
+	// blank lines after the imports are kept before the rest of the file
+	for end > start && lines[end-1] == "" {
+		end--
+	}
+
+	res := append([]string{}, lines[:start]...)
+	for _, group := range groups {
+		if len(group) == 0 {
+			continue
+		}
+		if len(res) > start {
+			res = append(res, "")
+		}
+		sort.SliceStable(group, func(i, j int) bool { return group[i].path < group[j].path })
+		for _, imp := range group {
+			res = append(res, imp.line)
+		}
+	}
+	return strings.Join(append(res, lines[end:]...), "\n")
+}
@@ -1,27 +1,90 @@
 package lsp
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"io/fs"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/carlverge/jsonnet-lsp/pkg/analysis"
+	"github.com/carlverge/jsonnet-lsp/pkg/linter"
 	"github.com/google/go-jsonnet"
 	"github.com/google/go-jsonnet/ast"
 	"github.com/google/go-jsonnet/formatter"
+	"github.com/hexops/gotextdiff"
+	"github.com/hexops/gotextdiff/myers"
+	"github.com/hexops/gotextdiff/span"
 	"go.lsp.dev/jsonrpc2"
 	"go.lsp.dev/protocol"
 	"go.lsp.dev/uri"
 )
 
 
+const (
+	EvaluateOnChange = "change"
+	EvaluateOnSave   = "save"
+	EvaluateOnNever  = "never"
+)
+
+const (
+	EvaluateGateErrors   = "errors"
+	EvaluateGateWarnings = "warnings"
+	EvaluateGateNone     = "none"
+)
+
 type DiagConfiguration struct {
 	Linter   bool `json:"linter"`
 	Evaluate bool `json:"evaluate"`
+	// When to run evaluation diagnostics, one of EvaluateOnChange, EvaluateOnSave, or EvaluateOnNever
+	EvaluateOn string `json:"evaluateOn"`
+	// Skip evaluation if the linter reports diagnostics at this severity or above, one of
+	// EvaluateGateErrors, EvaluateGateWarnings, or EvaluateGateNone
+	EvaluateGate string `json:"evaluateGate"`
+	// Flag object fields and array elements not indented by a multiple of Fmt.Indent
+	Indentation bool `json:"indentation"`
+	// Flag lines indented with both tabs and spaces
+	MixedIndentation bool `json:"mixedIndentation"`
+	// Flag imports that only resolve to files outside the workspace and jpaths
+	ImportOutsideWorkspace bool `json:"importOutsideWorkspace"`
+	// Warn about imports that cannot be resolved
+	ImportNotFound bool `json:"importNotFound"`
+	// Warn about conditionals nested deeper than this, zero for no limit
+	MaxTernaryDepth int `json:"maxTernaryDepth"`
+	// Suggest std.format for strings built from more `+` than this, zero for no limit
+	MaxStringConcat int `json:"maxStringConcat"`
+	// Flag fields merged onto a template that the template does not declare
+	StrictTemplateFields bool `json:"strictTemplateFields"`
+}
+
+func (c *DiagConfiguration) LinterOptions() linter.Options {
+	opts := linter.DefaultOptions()
+	opts.ImportNotFound = c.ImportNotFound
+	opts.MaxTernaryDepth = c.MaxTernaryDepth
+	opts.MaxStringConcat = c.MaxStringConcat
+	opts.StrictTemplateFields = c.StrictTemplateFields
+	return opts
+}
+
+// evaluationGated returns true if the linter diagnostics should prevent evaluating the file
+func (c *DiagConfiguration) evaluationGated(diags []protocol.Diagnostic) bool {
+	switch c.EvaluateGate {
+	case EvaluateGateNone:
+		return false
+	case EvaluateGateWarnings:
+		for _, d := range diags {
+			if d.Severity == protocol.DiagnosticSeverityError || d.Severity == protocol.DiagnosticSeverityWarning {
+				return true
+			}
+		}
+		return false
+	default:
+		return linter.HasErrors(diags)
+	}
 }
 
 type FmtConfiguration struct {
@@ -34,13 +97,22 @@ type FmtConfiguration struct {
 	PadObjects       bool   `json:"padObjects"`
 	SortImports      bool   `json:"sortImports"`
 	ImplicitPlus     bool   `json:"implicitPlus"`
+	// Separate the sorted imports with blank lines into groups by where they resolve from
+	GroupImports bool `json:"groupImports"`
+	// Padding of objects on a single line and spanning multiple lines, unset follows PadObjects.
+	// See ObjectPadding.
+	PadInlineObjects    *bool `json:"padInlineObjects"`
+	PadMultilineObjects *bool `json:"padMultilineObjects"`
 }
 
 func defaultConfiguration() *Configuration {
 	return &Configuration{
 		Diag: DiagConfiguration{
-			Linter:   true,
-			Evaluate: false,
+			Linter:         true,
+			Evaluate:       false,
+			EvaluateOn:     EvaluateOnChange,
+			EvaluateGate:   EvaluateGateErrors,
+			ImportNotFound: true,
 		},
 		Fmt: FmtConfiguration{
 			Indent:           2,
@@ -53,6 +125,14 @@ func defaultConfiguration() *Configuration {
 			ImplicitPlus:     true,
 			SortImports:      true,
 		},
+		EvaluateTimeoutMs: 10000,
+		VMCacheSize:       3,
+		RootMarkers:       []string{"jsonnetfile.json", ".git", "WORKSPACE"},
+		ExcludeGlobs:      []string{"node_modules/"},
+		FileExtensions:    []string{".jsonnet", ".libsonnet"},
+		SlashCompletion:   true,
+		CompleteSelfSuper: true,
+		SymbolCache:       true,
 	}
 }
 
@@ -60,6 +140,48 @@ type Configuration struct {
 	Diag   DiagConfiguration `json:"diag"`
 	JPaths []string          `json:"jpaths"`
 	Fmt    FmtConfiguration  `json:"fmt"`
+	// Evaluations running longer than this are abandoned. Zero means no timeout.
+	EvaluateTimeoutMs int `json:"evaluateTimeoutMs"`
+	// Evaluate files with an importer that refuses to read files outside of the workspace,
+	// for workspaces with untrusted files
+	SandboxEvaluate bool `json:"sandboxEvaluate"`
+	// The number of jsonnet VMs kept for the most recently used files. Each VM caches the
+	// files imported from its file, so switching between files does not reload the imports.
+	VMCacheSize int `json:"vmCacheSize"`
+	// Show the output of `std.manifest*` calls with constant arguments when hovering the function
+	ManifestPreview bool `json:"manifestPreview"`
+	// Offer stdlib functions in completion without the `std.` prefix, inserting the prefix
+	SuggestStdPrefix bool `json:"suggestStdPrefix"`
+	// Complete import paths when typing `/`. Completion triggered by `/` outside of an import
+	// path never returns anything.
+	SlashCompletion bool `json:"slashCompletion"`
+	// Complete functions as a call with placeholders for the required parameters
+	CompleteFunctionCalls bool `json:"completeFunctionCalls"`
+	// Offer `self`, `super` and `$` as variables in completion. They resolve regardless.
+	CompleteSelfSuper bool `json:"completeSelfSuper"`
+	// Files marking the root of a nested project. Imports are resolved from the nearest
+	// directory containing one of these before the workspace root.
+	RootMarkers []string `json:"rootMarkers"`
+	// Maps prefixes of absolute import paths to the directory they are resolved from, like
+	// `{"/lib": "third_party/lib"}`. Relative directories are relative to the workspace root.
+	ImportRoots map[string]string `json:"importRoots"`
+	// Paths skipped when scanning the workspace, in addition to the root .gitignore.
+	// Uses .gitignore syntax.
+	ExcludeGlobs []string `json:"excludeGlobs"`
+	// Persist the index of workspace symbols under the user cache directory, so that it does
+	// not need to be rebuilt when the server restarts
+	SymbolCache bool `json:"symbolCache"`
+	// Extensions of the files analyzed as jsonnet, in addition to documents the editor opens with
+	// the jsonnet language. Also used to find the jsonnet files in the workspace.
+	FileExtensions []string `json:"fileExtensions"`
+	// Values of the external variables read with `std.extVar`, used for evaluation and analysis
+	ExtVars    map[string]string      `json:"extVars"`
+	InlayHints InlayHintConfiguration `json:"inlayHints"`
+}
+
+type InlayHintConfiguration struct {
+	// Show a `+` where objects are merged without one, like `base { ... }`
+	ImplicitPlus bool `json:"implicitPlus"`
 }
 
 func (c *Configuration) FormatterOptions() formatter.Options {
@@ -81,6 +203,8 @@ func (c *Configuration) FormatterOptions() formatter.Options {
 		opts.StringStyle = formatter.StringStyleDouble
 	case "'":
 		opts.StringStyle = formatter.StringStyleSingle
+	case "leave", "Leave Alone":
+		opts.StringStyle = formatter.StringStyleLeave
 	default:
 		opts.StringStyle = formatter.StringStyleLeave
 	}
@@ -90,6 +214,8 @@ func (c *Configuration) FormatterOptions() formatter.Options {
 		opts.CommentStyle = formatter.CommentStyleHash
 	case "//":
 		opts.CommentStyle = formatter.CommentStyleSlash
+	case "leave", "Leave Alone":
+		opts.CommentStyle = formatter.CommentStyleLeave
 	default:
 		opts.CommentStyle = formatter.CommentStyleLeave
 	}
@@ -97,9 +223,114 @@ func (c *Configuration) FormatterOptions() formatter.Options {
 	return opts
 }
 
+// formatJsonnet formats the contents with the options from FormatterOptions and ObjectPadding.
+// The formatter treats a max of zero blank lines as no limit, while the setting means no blank
+// lines at all, so those are removed here from the formatted output. Blank lines inside of
+// strings and comments are kept.
+func formatJsonnet(filename, contents string, opts formatter.Options, padding ObjectPadding) (string, error) {
+	out, err := formatter.Format(filename, contents, opts)
+	if err == nil && (padding.Inline != opts.PadObjects || padding.Multiline != opts.PadObjects) {
+		out = padObjects(filename, out, padding)
+	}
+	if err != nil || opts.MaxBlankLines != 0 {
+		return out, err
+	}
+
+	root, err := jsonnet.SnippetToAST(filename, out)
+	if err != nil {
+		return out, nil
+	}
+	inToken := tokenLines(root, out)
+
+	lines := strings.Split(out, "\n")
+	res := make([]string, 0, len(lines))
+	for i, line := range lines {
+		// keep the final empty line, it is the trailing newline of the file
+		if line == "" && !inToken[i+1] && i != len(lines)-1 {
+			continue
+		}
+		res = append(res, line)
+	}
+	return strings.Join(res, "\n"), nil
+}
+
+// tokenLines returns the 1-based lines of the contents that continue a string or a block comment
+// started on a previous line. Blank lines on the other lines are between tokens.
+func tokenLines(root ast.Node, contents string) map[int]bool {
+	lineStarts := []int{0}
+	for i := range contents {
+		if contents[i] == '\n' {
+			lineStarts = append(lineStarts, i+1)
+		}
+	}
+	offset := func(loc ast.Location) int {
+		if loc.Line < 1 || loc.Line > len(lineStarts) {
+			return -1
+		}
+		return lineStarts[loc.Line-1] + loc.Column - 1
+	}
+
+	// the end offset of the strings by their begin offset, comment markers inside are skipped
+	strEnds := map[int]int{}
+	analysis.WalkStack(root, func(n ast.Node, _ []ast.Node) bool {
+		if str, ok := n.(*ast.LiteralString); ok && str.LocRange.IsSet() {
+			begin, end := offset(str.LocRange.Begin), offset(str.LocRange.End)
+			if begin >= 0 && end > begin && end <= len(contents) {
+				strEnds[begin] = end
+			}
+		}
+		return true
+	})
+
+	res := map[int]bool{}
+	line := 1
+	// skip the contents up to `end`, the lines started before it continue a token
+	skip := func(begin, end int) {
+		for i := begin; i < end; i++ {
+			if contents[i] == '\n' {
+				line++
+				res[line] = true
+			}
+		}
+	}
+	for i := 0; i < len(contents); {
+		rest := contents[i:]
+		switch {
+		case strEnds[i] > 0:
+			skip(i, strEnds[i])
+			i = strEnds[i]
+		case strings.HasPrefix(rest, "/*"):
+			end := len(contents)
+			if idx := strings.Index(rest[2:], "*/"); idx >= 0 {
+				end = i + 2 + idx + 2
+			}
+			skip(i, end)
+			i = end
+		case strings.HasPrefix(rest, "//") || rest[0] == '#':
+			// the newline ending the comment is between tokens
+			if idx := strings.IndexByte(rest, '\n'); idx >= 0 {
+				i += idx
+			} else {
+				i = len(contents)
+			}
+		default:
+			if rest[0] == '\n' {
+				line++
+			}
+			i++
+		}
+	}
+	return res
+}
+
 func (s *Server) Handler() jsonrpc2.Handler {
 	serverHandler := protocol.ServerHandler(s, jsonrpc2.MethodNotFoundHandler)
-	return serverHandler
+	return func(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+		if req.Method() == protocol.MethodInitialize {
+			reply = withInlayHintCapability(reply)
+		}
+		return serverHandler(ctx, reply, req)
+	}
 }
 
 func (s *Server) Shutdown(ctx context.Context) (err error) {
@@ -115,20 +346,30 @@ func (s *Server) Initialized(ctx context.Context, params *protocol.InitializedPa
 	return nil
 }
 
+// workspaceSearchPaths returns the generated output directories in the workspace to import from
+func workspaceSearchPaths(rootFS fs.FS) []string {
+	// Check for bazel generated output directory
+	if _, err := fs.Stat(rootFS, "bazel-bin"); err != nil {
+		logf("no bazel-bin dir: %v", err)
+		return nil
+	}
+	return []string{"bazel-bin"}
+}
+
 func (s *Server) Initialize(ctx context.Context, params *protocol.InitializeParams) (result *protocol.InitializeResult, err error) {
 
 	s.rootURI = findRootDirectory(params)
 	// s.rootFS = os.DirFS("/")
 	s.rootFS = os.DirFS(s.rootURI.Filename())
 
-	// Check for bazel generated output directory
-	if _, err := fs.Stat(s.rootFS, "bazel-bin"); err == nil {
-		s.searchPaths = append(s.searchPaths, "bazel-bin")
-	} else {
-		logf("no bazel-bin dir: %v", err)
-	}
+	s.searchPaths = append(s.searchPaths, workspaceSearchPaths(s.rootFS)...)
+
+	s.importer = newOverlayImporter(s.overlay, s.rootURI, s.rootFS, s.searchPaths, s.config)
+	s.importer.dirJPaths = s.dirJPaths
 
-	s.importer = &OverlayImporter{overlay: s.overlay, rootURI: s.rootURI, rootFS: s.rootFS, paths: s.searchPaths}
+	if s.config.SymbolCache {
+		s.symbols.load(symbolCacheFile(s.rootURI))
+	}
 
 	_ = s.notifier.LogMessage(ctx, &protocol.LogMessageParams{
 		Message: "Jsonnet LSP Server Initialized",
@@ -146,43 +387,67 @@ func (s *Server) Initialize(ctx context.Context, params *protocol.InitializePara
 				TriggerCharacters:   []string{"("},
 				RetriggerCharacters: []string{","},
 			},
-			DocumentSymbolProvider: true,
+			DocumentSymbolProvider:  true,
+			WorkspaceSymbolProvider: true,
 			CompletionProvider: &protocol.CompletionOptions{
 				TriggerCharacters: []string{".", "/"},
 			},
 			DocumentFormattingProvider: true,
 			HoverProvider:              true,
 			DefinitionProvider:         true,
+			ImplementationProvider:     true,
+			LinkedEditingRangeProvider: true,
+			RenameProvider:             &protocol.RenameOptions{PrepareProvider: true},
+			CodeActionProvider:         true,
+			ColorProvider:              true,
 		},
 	}, nil
 }
 
+// parseConfiguration decodes user settings on top of the default configuration, so
+// that settings missing from a partial configuration keep their default values
+// rather than being reset to their zero value.
+func parseConfiguration(data []byte) (*Configuration, error) {
+	cfg := defaultConfiguration()
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
 func (s *Server) DidChangeConfiguration(ctx context.Context, params *protocol.DidChangeConfigurationParams) (err error) {
 	data, _ := json.Marshal(params.Settings)
 	logf("did change config: %s", string(data))
-	newcfg := &Configuration{}
-	if err := json.Unmarshal(data, newcfg); err != nil {
+	newcfg, err := parseConfiguration(data)
+	if err != nil {
 		logf("failed to parse new configuration: %+v", err)
 		return nil
 	}
 
-	// TODO(@carlverge): Rethink how paths are threaded through the code, this is getting too messy.
+	// Search paths are owned by the importer, see OverlayImporter.SearchDirs
 	s.importer.SetJPaths(newcfg.JPaths)
+	s.importer.SetRootMarkers(newcfg.RootMarkers)
+	s.importer.SetImportRoots(newcfg.ImportRoots)
 
 	// Racy in the sense we could see an old pointer, but that is OK.
 	s.config = newcfg
+	// the cached VMs have the external variables of the previous configuration
+	s.vmlock.Lock()
+	s.vms = nil
+	s.vmlock.Unlock()
 
 	return nil
 }
 
 func (s *Server) DidOpen(ctx context.Context, params *protocol.DidOpenTextDocumentParams) error {
 	logf("did-open: uri=%s ver=%d txtlen=%d", params.TextDocument.URI, params.TextDocument.Version, len(params.TextDocument.Text))
+	s.setDocumentLanguage(params.TextDocument.URI, params.TextDocument.LanguageID)
 	s.overlay.Replace(
 		params.TextDocument.URI,
 		int64(params.TextDocument.Version),
 		params.TextDocument.Text,
-		parseJsonnetFn(params.TextDocument.URI),
-		s.processFileUpdateFn(ctx, params.TextDocument.URI),
+		s.documentParseFn(params.TextDocument.URI),
+		s.processFileUpdateFn(ctx, params.TextDocument.URI, EvaluateOnChange),
 	)
 	return nil
 }
@@ -197,12 +462,15 @@ func lastCharIsDot(ce []protocol.TextDocumentContentChangeEvent) bool {
 
 func (s *Server) DidChange(ctx context.Context, params *protocol.DidChangeTextDocumentParams) error {
 	tracef("did-change: uri=%s ver=%d changes=%d", params.TextDocument.URI, params.TextDocument.Version, len(params.ContentChanges))
+	// Any in-flight evaluation is for a stale version of the file
+	s.cancelEvaluation(params.TextDocument.URI)
+	s.invalidateDocumentSymbols(params.TextDocument.URI)
 	s.overlay.Update(
 		params.TextDocument.URI,
 		int64(params.TextDocument.Version),
 		convChangeEvents(params.ContentChanges),
-		parseJsonnetFn(params.TextDocument.URI),
-		s.processFileUpdateFn(ctx, params.TextDocument.URI),
+		s.documentParseFn(params.TextDocument.URI),
+		s.processFileUpdateFn(ctx, params.TextDocument.URI, EvaluateOnChange),
 	)
 	s.lastCharIsDot = lastCharIsDot(params.ContentChanges)
 	return nil
@@ -210,12 +478,20 @@ func (s *Server) DidChange(ctx context.Context, params *protocol.DidChangeTextDo
 
 func (s *Server) DidSave(ctx context.Context, params *protocol.DidSaveTextDocumentParams) (err error) {
 	tracef("did-save: uri=%s", params.TextDocument.URI)
+	if cfg := s.fileConfig(params.TextDocument.URI); cfg.Diag.Evaluate && cfg.Diag.EvaluateOn == EvaluateOnSave {
+		s.overlay.Refresh(params.TextDocument.URI, s.processFileUpdateFn(ctx, params.TextDocument.URI, EvaluateOnSave))
+	}
 	return nil
 }
 
 func (s *Server) DidClose(_ context.Context, params *protocol.DidCloseTextDocumentParams) (err error) {
 	logf("did-close: uri=%s", params.TextDocument.URI)
+	s.invalidateDocumentSymbols(params.TextDocument.URI)
+	s.setPublishedDiagnostics(params.TextDocument.URI, nil)
 	s.overlay.Close(params.TextDocument.URI)
+	s.setDocumentLanguage(params.TextDocument.URI, "")
+	// the file is read from disk again, which may differ from the unsaved contents
+	s.invalidateVMs(params.TextDocument.URI)
 	return nil
 }
 
@@ -245,9 +521,9 @@ func isObjectFieldsCompletion(stk []ast.Node, resolver analysis.Resolver) []anal
 	if lhs != nil && lhs.Object != nil && len(lhs.Object.Fields) > 0 {
 		res := []analysis.Field{}
 		// If the user has already filled out a field in the template, do not show it in the
-		// completion list (or if the field is hidden)
+		// completion list
 		for _, fld := range lhs.Object.Fields {
-			if seenFields[fld.Name] || fld.Hidden {
+			if seenFields[fld.Name] {
 				continue
 			}
 			res = append(res, fld)
@@ -258,6 +534,90 @@ func isObjectFieldsCompletion(stk []ast.Node, resolver analysis.Resolver) []anal
 	return nil
 }
 
+// isInSuperCompletion checks if the completion is for the field name string of `'name' in super`,
+// and returns the fields of the object being extended
+func isInSuperCompletion(stk []ast.Node, resolver analysis.Resolver) []analysis.Field {
+	if len(stk) < 2 {
+		return nil
+	}
+	in, _ := stk[len(stk)-2].(*ast.InSuper)
+	str, _ := stk[len(stk)-1].(*ast.LiteralString)
+	if in == nil || str == nil || in.Index != ast.Node(str) {
+		return nil
+	}
+	v := resolver.Vars(in).Get("super")
+	if v == nil || v.Node == nil {
+		return nil
+	}
+	sup := analysis.NodeToValue(v.Node, resolver)
+	if sup.Object == nil {
+		return nil
+	}
+	return sup.Object.Fields
+}
+
+// isExtVarCompletion checks if the completion is for the name string of `std.extVar('name')`
+func isExtVarCompletion(stk []ast.Node, resolver analysis.Resolver) bool {
+	if len(stk) < 2 {
+		return false
+	}
+	call, _ := stk[len(stk)-2].(*ast.Apply)
+	str, _ := stk[len(stk)-1].(*ast.LiteralString)
+	if call == nil || str == nil {
+		return false
+	}
+	if analysis.NodeToValue(call.Target, resolver).Function != analysis.StdLibFunctions["extVar"] {
+		return false
+	}
+	if len(call.Arguments.Positional) > 0 {
+		return call.Arguments.Positional[0].Expr == ast.Node(str)
+	}
+	return len(call.Arguments.Named) > 0 && call.Arguments.Named[0].Arg == ast.Node(str)
+}
+
+// isArrayIndexCompletion checks if the completion is for the index of an array, either as a
+// plain index `arr[idx]` or a slice `arr[start:end]` (desugared to `$std.slice(arr, start, end, step)`)
+func isArrayIndexCompletion(stk []ast.Node, resolver analysis.Resolver) bool {
+	if len(stk) < 2 {
+		return false
+	}
+	var target ast.Node
+	switch parent := stk[len(stk)-2].(type) {
+	case *ast.Index:
+		if parent.Index == stk[len(stk)-1] {
+			target = parent.Target
+		}
+	case *ast.Apply:
+		if !analysis.IsStdCall(parent, "slice") || len(parent.Arguments.Positional) == 0 {
+			return false
+		}
+		if parent.Arguments.Positional[0].Expr != stk[len(stk)-1] {
+			target = parent.Arguments.Positional[0].Expr
+		}
+	}
+	return target != nil && analysis.NodeToValue(target, resolver).Type == analysis.ArrayType
+}
+
+// Completions offered inside the brackets of an array index
+var sliceCompletions = []protocol.CompletionItem{
+	{
+		Label:            "start:end",
+		InsertText:       "${1:start}:${2:end}",
+		InsertTextFormat: protocol.InsertTextFormatSnippet,
+		Detail:           "array slice",
+		Documentation:    "Elements from `start` (inclusive) to `end` (exclusive). Either bound may be omitted.\nEquivalent to `std.slice(arr, start, end, null)`.",
+		Kind:             protocol.CompletionItemKindSnippet,
+	},
+	{
+		Label:            "start:end:step",
+		InsertText:       "${1:start}:${2:end}:${3:step}",
+		InsertTextFormat: protocol.InsertTextFormatSnippet,
+		Detail:           "array slice with step",
+		Documentation:    "Every `step`th element from `start` (inclusive) to `end` (exclusive). Any part may be omitted.\nEquivalent to `std.slice(arr, start, end, step)`.",
+		Kind:             protocol.CompletionItemKindSnippet,
+	},
+}
+
 var typeToCompletionKindMap = map[analysis.ValueType]protocol.CompletionItemKind{
 	analysis.FunctionType: protocol.CompletionItemKindFunction,
 	analysis.ObjectType:   protocol.CompletionItemKindStruct,
@@ -290,6 +650,24 @@ func valueToDetail(v *analysis.Value) string {
 	return v.Type.String()
 }
 
+// importDetail describes a value bound to `import 'file'` as a module, as imported objects are
+// whole libraries rather than plain objects. Returns false for other values.
+func importDetail(bound ast.Node, v *analysis.Value) (string, bool) {
+	imp, ok := bound.(*ast.Import)
+	if !ok || (v.Type != analysis.ObjectType && v.Type != analysis.AnyType) {
+		return "", false
+	}
+	return fmt.Sprintf("module(%q)", imp.File.Value), true
+}
+
+func isLiteral(n ast.Node) bool {
+	switch n.(type) {
+	case *ast.LiteralString, *ast.LiteralNumber, *ast.LiteralBoolean:
+		return true
+	}
+	return false
+}
+
 // precompute these as they are numerous and commonly used
 // this also lets us bypass the issue of their not having a real
 // ast node associated with them
@@ -312,39 +690,65 @@ func (s *Server) Completion(ctx context.Context, params *protocol.CompletionPara
 	if resolver == nil {
 		return res, nil
 	}
+	cfg := s.fileConfig(params.TextDocument.URI)
 
 	isDotComplete := s.lastCharIsDot || (params.Context != nil && params.Context.TriggerCharacter == ".")
 	isSlashComplete := params.Context != nil && params.Context.TriggerCharacter == "/"
+	if isSlashComplete && !cfg.SlashCompletion {
+		return res, nil
+	}
 
 	pos := protoToPos(params.Position)
 	if isDotComplete {
 		pos.Column--
 	}
 	node, stack := resolver.NodeAt(pos)
+	expected, isElement := expectedType(stack, resolver)
+
+	// Import file completion, for import, importstr and importbin
+	if isImportNode(node) {
+		file := importedFile(node)
+		// only directories inside the workspace can be listed
+		searchPaths := []string{}
+		for _, dir := range s.importer.SearchDirs(params.TextDocument.URI.Filename()) {
+			sp, err := filepath.Rel(s.rootURI.Filename(), dir)
+			if err != nil || strings.HasPrefix(sp, "..") {
+				continue
+			}
+			searchPaths = append(searchPaths, sp)
+		}
 
-	// Import file completion
-	if imp, ok := node.(*ast.Import); ok {
-		// always search a directory
-		path := filepath.Dir(imp.File.Value)
-		if finfo, err := fs.Stat(s.rootFS, filepath.Clean(imp.File.Value)); err == nil && finfo.IsDir() {
-			path = filepath.Clean(imp.File.Value)
+		// always search a directory, which is the typed path if it is a directory in any search path
+		path := filepath.Dir(file)
+		for _, sp := range searchPaths {
+			if finfo, err := fs.Stat(s.rootFS, filepath.Join(sp, filepath.Clean(file))); err == nil && finfo.IsDir() {
+				path = filepath.Clean(file)
+				break
+			}
 		}
 
 		seen := map[string]bool{}
 		ents := []fs.DirEntry{}
+		ignore := s.workspaceIgnore()
 
-		// Dedup files/directories from search paths
-		for _, sp := range append(append([]string{""}, s.searchPaths...), s.config.JPaths...) {
+		// Dedup files/directories from search paths, in the same order imports are resolved
+		for _, sp := range searchPaths {
 			entries, _ := fs.ReadDir(s.rootFS, filepath.Join(sp, path))
 			for _, ent := range entries {
 				if seen[ent.Name()] {
 					continue
 				}
+				// Paths are matched relative to the workspace root, like git does
+				if ignore.Match(filepath.ToSlash(filepath.Join(sp, path, ent.Name())), ent.IsDir()) {
+					continue
+				}
 				ents = append(ents, ent)
 				seen[ent.Name()] = true
 			}
 		}
 
+		// importstr and importbin are for data files, so suggest those before jsonnet files
+		_, isJsonnetImport := node.(*ast.Import)
 		for _, m := range ents {
 			if strings.HasPrefix(m.Name(), ".") {
 				continue
@@ -354,10 +758,21 @@ func (s *Server) Completion(ctx context.Context, params *protocol.CompletionPara
 				kind = protocol.CompletionItemKindFolder
 			}
 
-			res.Items = append(res.Items, protocol.CompletionItem{
+			item := protocol.CompletionItem{
 				Label: m.Name(),
 				Kind:  kind,
-			})
+			}
+			if !isJsonnetImport {
+				switch {
+				case m.IsDir():
+					item.SortText = "1_" + m.Name()
+				case cfg.isJsonnetFile(m.Name()):
+					item.SortText = "2_" + m.Name()
+				default:
+					item.SortText = "0_" + m.Name()
+				}
+			}
+			res.Items = append(res.Items, item)
 		}
 		return res, nil
 	}
@@ -370,86 +785,362 @@ func (s *Server) Completion(ctx context.Context, params *protocol.CompletionPara
 
 	if isDotComplete {
 		topVal := analysis.NodeToValue(node, resolver)
+		// `super.x` is only located at the `super` keyword, so complete the fields of the object
+		// being extended instead of the field accessed
+		if _, ok := node.(*ast.SuperIndex); ok {
+			if v := resolver.Vars(node).Get("super"); v != nil && v.Node != nil {
+				topVal = analysis.NodeToValue(v.Node, resolver)
+			}
+		}
 		if topVal.Object == nil {
 			return res, nil
 		}
 
 		if topVal == analysis.StdLibValue {
-			res.Items = stdlibCompletions
+			res.Items = make([]protocol.CompletionItem, len(stdlibCompletions))
+			for i, item := range stdlibCompletions {
+				if cfg.CompleteFunctionCalls {
+					item = withCallSnippet(item, analysis.StdLibFunctions[item.Label])
+				}
+				_, deprecated := linter.DeprecatedStdlib[item.Label]
+				res.Items[i] = withRank(item, completionRank(analysis.FunctionType, expected, false, deprecated))
+			}
 			return res, nil
 		}
 
 		for _, fld := range topVal.Object.Fields {
-			fldVal := analysis.NodeToValue(fld.Node, resolver)
+			fldVal := analysis.FieldToValue(&fld, resolver)
 
-			res.Items = append(res.Items, protocol.CompletionItem{
+			item := protocol.CompletionItem{
 				Label:         fld.Name,
 				InsertText:    analysis.SafeIdent(fld.Name),
 				Detail:        valueToDetail(fldVal),
 				Documentation: strings.Join(fld.Comment, "\n"),
 				Kind:          typeToCompletionKind(fld.Type, protocol.CompletionItemKindField),
-			})
+			}
+			if detail, ok := importDetail(fld.Node, fldVal); ok {
+				item.Detail, item.Kind = detail, protocol.CompletionItemKindModule
+			}
+			// fields of only some branches of a conditional object may be missing
+			if fld.Partial {
+				item.Detail = strings.TrimSpace(item.Detail + " (in some branches)")
+			}
+			if cfg.CompleteFunctionCalls {
+				item = withCallSnippet(item, fldVal.Function)
+			}
+			res.Items = append(res.Items, withRank(item, completionRank(fldVal.Type, expected, fld.Hidden, false)))
 		}
 		return res, nil
 	}
 
 	if flds := isObjectFieldsCompletion(stack, resolver); flds != nil {
 		for _, fld := range flds {
+			// hidden fields are overridden with `::`, and sorted after the visible fields
+			sep, sortPrefix := ":", "0"
+			if fld.Hidden {
+				sep, sortPrefix = "::", "1"
+			}
 			res.Items = append(res.Items, protocol.CompletionItem{
 				Label:            fld.Name,
-				InsertText:       analysis.SafeIdent(fld.Name) + ": $1,$0",
+				InsertText:       analysis.SafeIdent(fld.Name) + sep + " $1,$0",
 				InsertTextFormat: protocol.InsertTextFormatSnippet,
 				Detail:           fld.Type.String(),
 				Documentation:    strings.Join(fld.Comment, "\n"),
 				Kind:             protocol.CompletionItemKindField,
+				SortText:         sortPrefix + "_" + fld.Name,
 			})
 		}
 		return res, nil
 	}
 
-	for name, v := range resolver.Vars(node) {
+	if flds := isInSuperCompletion(stack, resolver); flds != nil {
+		for _, fld := range flds {
+			res.Items = append(res.Items, protocol.CompletionItem{
+				Label:         fld.Name,
+				Detail:        fld.Type.String(),
+				Documentation: strings.Join(fld.Comment, "\n"),
+				Kind:          protocol.CompletionItemKindField,
+			})
+		}
+		return res, nil
+	}
+
+	if isExtVarCompletion(stack, resolver) {
+		for name, val := range cfg.ExtVars {
+			res.Items = append(res.Items, protocol.CompletionItem{
+				Label:  name,
+				Detail: fmt.Sprintf("%q", val),
+				Kind:   protocol.CompletionItemKindConstant,
+			})
+		}
+		return res, nil
+	}
+
+	if isArrayIndexCompletion(stack, resolver) {
+		res.Items = append(res.Items, sliceCompletions...)
+	}
+
+	// Variables are unique by name, and shadowed bindings are replaced by the innermost one
+	vars := resolver.Vars(node)
+	for _, name := range vars.Names() {
+		if !cfg.CompleteSelfSuper && (name == "self" || name == "super" || name == "$") {
+			continue
+		}
+		v := vars.Get(name)
 		if v.Node != nil {
 			val := analysis.NodeToValue(v.Node, resolver)
 
-			res.Items = append(res.Items, protocol.CompletionItem{
+			item := protocol.CompletionItem{
 				Label:         name,
 				InsertText:    name,
 				Detail:        val.Type.String(),
 				Documentation: strings.Join(val.Comment, "\n"),
 				Kind:          typeToCompletionKind(val.Type, protocol.CompletionItemKindVariable),
 				SortText:      fmt.Sprintf("%3d_%s", v.StackPos, name),
-			})
+			}
+			if detail, ok := importDetail(v.Node, val); ok {
+				item.Detail, item.Kind = detail, protocol.CompletionItemKindModule
+			}
+			if cfg.CompleteFunctionCalls {
+				item = withCallSnippet(item, val.Function)
+			}
+			res.Items = append(res.Items, withRank(item, completionRank(val.Type, expected, false, false)))
 		} else {
-			res.Items = append(res.Items, protocol.CompletionItem{
+			res.Items = append(res.Items, withRank(protocol.CompletionItem{
 				Label:    name,
 				Kind:     protocol.CompletionItemKindVariable,
 				SortText: fmt.Sprintf("%3d_%s", 0, name),
-			})
+			}, rankDefault))
+		}
+	}
+
+	if cfg.SuggestStdPrefix {
+		res.Items = append(res.Items, stdPrefixCompletions(vars, expected, cfg.CompleteFunctionCalls)...)
+	}
+	if isElement {
+		if item, ok := elementSnippet(expected, cfg.Fmt.StringStyle); ok {
+			res.Items = append(res.Items, withRank(item, rankExpected))
 		}
 	}
 
 	return res, nil
 }
 
+// elementSnippet is a literal of the type expected for the elements of an array, like `''` when
+// completing the elements of an `array[string]` argument
+func elementSnippet(tp analysis.ValueType, stringStyle string) (protocol.CompletionItem, bool) {
+	item := protocol.CompletionItem{
+		InsertTextFormat: protocol.InsertTextFormatSnippet,
+		Kind:             protocol.CompletionItemKindSnippet,
+		Detail:           tp.String(),
+		// after the variables of the expected type
+		SortText: "~literal",
+	}
+	switch tp {
+	case analysis.StringType:
+		quote := `"`
+		if stringStyle == "'" {
+			quote = "'"
+		}
+		item.Label, item.InsertText = quote+quote, quote+"$1"+quote+"$0"
+	case analysis.ObjectType:
+		item.Label, item.InsertText = "{}", "{$1}$0"
+	case analysis.ArrayType:
+		item.Label, item.InsertText = "[]", "[$1]$0"
+	default:
+		return item, false
+	}
+	return item, true
+}
+
+// Completion items are ranked before their sort text: items of the type expected at the
+// position first, hidden fields and deprecated stdlib functions last
+const (
+	rankExpected = iota
+	rankDefault
+	rankHidden
+	rankDeprecated
+)
+
+// completionRank ranks an item of type `tp`, where `expected` is the type expected at the position
+func completionRank(tp, expected analysis.ValueType, hidden, deprecated bool) int {
+	switch {
+	case deprecated:
+		return rankDeprecated
+	case hidden:
+		return rankHidden
+	case expected != analysis.AnyType && tp == expected:
+		return rankExpected
+	}
+	return rankDefault
+}
+
+// withRank prefixes the sort text of the item, or its label if it has none, with the rank
+func withRank(item protocol.CompletionItem, rank int) protocol.CompletionItem {
+	sortText := item.SortText
+	if sortText == "" {
+		sortText = item.Label
+	}
+	item.SortText = fmt.Sprintf("%d%s", rank, sortText)
+	return item
+}
+
+// expectedType returns the type of the parameter the innermost call around the node passes the
+// node to, or the parameter being completed if the node is the call itself. Inside an array
+// literal argument, it is the type of the elements of the parameter and `element` is true.
+// Returns AnyType if the node is not an argument, or the parameter type is not known.
+func expectedType(stack []ast.Node, resolver analysis.Resolver) (tp analysis.ValueType, element bool) {
+	for i := len(stack) - 1; i >= 0; i-- {
+		apply, ok := stack[i].(*ast.Apply)
+		if !ok {
+			continue
+		}
+		fn := analysis.NodeToValue(apply.Target, resolver).Function
+		if fn == nil || len(fn.Params) == 0 {
+			return analysis.AnyType, false
+		}
+		params := analysis.CallParams(fn, apply, resolver)
+		if i == len(stack)-1 {
+			return params[activeParamIndex(apply, fn)].Type, false
+		}
+		child := stack[i+1]
+		// elements of an array literal argument, like the strings of `std.join(",", [...])`
+		_, inArray := child.(*ast.Array)
+		paramType := func(p analysis.Param) analysis.ValueType {
+			if inArray {
+				return analysis.ElementType(p.TypeHint)
+			}
+			return p.Type
+		}
+		for j, arg := range apply.Arguments.Positional {
+			if arg.Expr == child && j < len(params) {
+				return paramType(params[j]), inArray
+			}
+		}
+		for _, arg := range apply.Arguments.Named {
+			if arg.Arg != child {
+				continue
+			}
+			for _, p := range params {
+				if p.Name == string(arg.Name) {
+					return paramType(p), inArray
+				}
+			}
+		}
+		return analysis.AnyType, false
+	}
+	return analysis.AnyType, false
+}
+
+// stdPrefixCompletions offers bare stdlib function names that insert `std.name`, unless
+// the name is shadowed by a variable. They are sorted after all variables in scope.
+func stdPrefixCompletions(vars analysis.VarMap, expected analysis.ValueType, callSnippets bool) []protocol.CompletionItem {
+	res := []protocol.CompletionItem{}
+	for _, item := range stdlibCompletions {
+		if vars.Get(item.Label) != nil {
+			continue
+		}
+		fn := analysis.StdLibFunctions[item.Label]
+		item.InsertText = "std." + item.Label
+		item.FilterText = item.Label
+		item.Detail = "std." + item.Detail
+		item.SortText = "~std_" + item.Label
+		if callSnippets {
+			item = withCallSnippet(item, fn)
+		}
+		_, deprecated := linter.DeprecatedStdlib[item.Label]
+		res = append(res, withRank(item, completionRank(analysis.FunctionType, expected, false, deprecated)))
+	}
+	return res
+}
+
+var snippetEscaper = strings.NewReplacer(`\`, `\\`, `$`, `\$`, `}`, `\}`)
+
+// withCallSnippet changes the completion of a function to insert a call, with a tab stop for
+// each parameter without a default value
+func withCallSnippet(item protocol.CompletionItem, fn *analysis.Function) protocol.CompletionItem {
+	if fn == nil {
+		return item
+	}
+	insert := item.InsertText
+	if insert == "" {
+		insert = item.Label
+	}
+	args := []string{}
+	for _, param := range fn.Params {
+		if param.Default != nil {
+			continue
+		}
+		args = append(args, fmt.Sprintf("${%d:%s}", len(args)+1, snippetEscaper.Replace(param.Name)))
+	}
+	item.InsertText = snippetEscaper.Replace(insert) + "(" + strings.Join(args, ", ") + ")$0"
+	item.InsertTextFormat = protocol.InsertTextFormatSnippet
+	return item
+}
+
+type cachedDocumentSymbols struct {
+	version int64
+	symbols []interface{}
+}
+
+// documentSymbols returns the cached symbols of the file if they were computed from `version`
+func (s *Server) documentSymbols(u uri.URI, version int64) ([]interface{}, bool) {
+	s.docSymbolLock.Lock()
+	defer s.docSymbolLock.Unlock()
+	if cached := s.docSymbols[u]; cached != nil && cached.version == version {
+		return cached.symbols, true
+	}
+	return nil, false
+}
+
+func (s *Server) cacheDocumentSymbols(u uri.URI, version int64, symbols []interface{}) {
+	s.docSymbolLock.Lock()
+	defer s.docSymbolLock.Unlock()
+	if s.docSymbols == nil {
+		s.docSymbols = map[uri.URI]*cachedDocumentSymbols{}
+	}
+	s.docSymbols[u] = &cachedDocumentSymbols{version: version, symbols: symbols}
+}
+
+// invalidateDocumentSymbols drops the cached symbols of a file that changed or was closed
+func (s *Server) invalidateDocumentSymbols(u uri.URI) {
+	s.docSymbolLock.Lock()
+	defer s.docSymbolLock.Unlock()
+	delete(s.docSymbols, u)
+}
+
 func (s *Server) DocumentSymbol(ctx context.Context, params *protocol.DocumentSymbolParams) ([]interface{}, error) {
 	res := []interface{}{}
+	parsed := s.overlay.Parsed(params.TextDocument.URI)
 	root := s.getCurrentAST(params.TextDocument.URI)
-	if root == nil {
+	if parsed == nil || root == nil {
 		return res, nil
 	}
+	if cached, ok := s.documentSymbols(params.TextDocument.URI, parsed.Version); ok {
+		return cached, nil
+	}
 
+	resolver := s.NewResolver(params.TextDocument.URI)
 	locals, _ := analysis.UnwindLocals(root)
 	for _, name := range locals.Names() {
 		v := locals.Get(name)
-		res = append(res, protocol.DocumentSymbol{
+		sym := protocol.DocumentSymbol{
 			Name:           string(name),
 			Kind:           protocol.SymbolKindVariable,
 			Detail:         v.Type.String(),
 			Range:          rangeToProto(v.Loc),
 			SelectionRange: rangeToProto(v.Loc),
-		})
+		}
+		// show the signature of functions, like the hover does
+		if fn, ok := v.Node.(*ast.Function); ok && resolver != nil {
+			val := analysis.NodeToValue(fn, resolver)
+			sym.Kind = protocol.SymbolKindFunction
+			sym.Detail = val.Type.String() + val.Function.String()
+		}
+		res = append(res, sym)
 	}
 
+	s.cacheDocumentSymbols(params.TextDocument.URI, parsed.Version, res)
 	return res, nil
 }
 
@@ -468,6 +1159,9 @@ func (s *Server) SignatureHelp(ctx context.Context, params *protocol.SignatureHe
 	if !ok {
 		return &protocol.SignatureHelp{Signatures: []protocol.SignatureInformation{}}, nil
 	}
+	if res := operatorSignatureHelp(apply, protoToPos(params.Position), resolver); res != nil {
+		return res, nil
+	}
 
 	targ := analysis.NodeToValue(apply.Target, resolver)
 	if targ.Function == nil {
@@ -480,23 +1174,7 @@ func (s *Server) SignatureHelp(ctx context.Context, params *protocol.SignatureHe
 	// The AST doesn't parse with partial named params, so we can't fully
 	// properly highlight the active named (without gnarly string parsing)
 
-	activeParam := 0
-	if len(apply.Arguments.Positional) < len(targ.Function.Params) {
-		seenNamed := map[string]bool{}
-		for i := range apply.Arguments.Positional {
-			seenNamed[targ.Function.Params[i].Name] = true
-		}
-		for _, p := range apply.Arguments.Named {
-			seenNamed[string(p.Name)] = true
-		}
-		for i, p := range targ.Function.Params {
-			if seenNamed[p.Name] {
-				continue
-			}
-			activeParam = i
-			break
-		}
-	}
+	activeParam := activeParamIndex(apply, targ.Function)
 
 	fnName := "function"
 	switch name := apply.Target.(type) {
@@ -529,14 +1207,144 @@ func (s *Server) SignatureHelp(ctx context.Context, params *protocol.SignatureHe
 	return res, nil
 }
 
+// activeParamIndex returns the index of the first parameter of the function not passed by the call
+func activeParamIndex(apply *ast.Apply, fn *analysis.Function) int {
+	if len(apply.Arguments.Positional) >= len(fn.Params) {
+		return 0
+	}
+	seenNamed := map[string]bool{}
+	for i := range apply.Arguments.Positional {
+		seenNamed[fn.Params[i].Name] = true
+	}
+	for _, p := range apply.Arguments.Named {
+		seenNamed[string(p.Name)] = true
+	}
+	for i, p := range fn.Params {
+		if !seenNamed[p.Name] {
+			return i
+		}
+	}
+	return 0
+}
+
+// operatorOverload is a stdlib function documenting a binary operator for operands of a type
+type operatorOverload struct {
+	name string
+	// type of the first argument selecting this overload
+	argType analysis.ValueType
+	doc     string
+}
+
+// binaryOperatorFunctions maps the stdlib functions binary operators are desugared to, to the
+// overloads of the operator
+var binaryOperatorFunctions = map[string][]operatorOverload{
+	"mod": {
+		{name: "format", argType: analysis.StringType, doc: "`str % vals` formats the string, the same as `std.format(str, vals)`."},
+		{name: "mod", argType: analysis.NumberType, doc: "`a % b` is the remainder of dividing the numbers, the same as `std.mod(a, b)`."},
+	},
+	"objectHasAll": {
+		{name: "objectHasAll", argType: analysis.ObjectType, doc: "`f in o` checks if the object has the field, including hidden fields, the same as `std.objectHasAll(o, f)`."},
+	},
+}
+
+// operatorSignatureHelp shows the overloads of a binary operator desugared to a stdlib call, like
+// `a % b` to `$std.mod(a, b)`. The overload matching the type of the operand is active. Returns
+// nil if the call is not an operator.
+func operatorSignatureHelp(apply *ast.Apply, pos ast.Location, resolver analysis.Resolver) *protocol.SignatureHelp {
+	idx, ok := apply.Target.(*ast.Index)
+	if !ok || len(apply.Arguments.Positional) != 2 {
+		return nil
+	}
+	std, _ := idx.Target.(*ast.Var)
+	name, _ := idx.Index.(*ast.LiteralString)
+	if std == nil || std.Id != "$std" || name == nil || binaryOperatorFunctions[name.Value] == nil {
+		return nil
+	}
+
+	// the operands may be reversed in the call, like `f in o` to `objectHasAll(o, f)`
+	args := apply.Arguments.Positional
+	left := 0
+	if locBefore(args[1].Expr.Loc().Begin, args[0].Expr.Loc().Begin) {
+		left = 1
+	}
+	activeParam := left
+	if locBefore(args[left].Expr.Loc().End, pos) {
+		activeParam = 1 - left
+	}
+
+	argType := analysis.NodeToValue(args[0].Expr, resolver).Type
+	res := &protocol.SignatureHelp{Signatures: []protocol.SignatureInformation{}, ActiveParameter: uint32(activeParam)}
+	for i, overload := range binaryOperatorFunctions[name.Value] {
+		fn := analysis.StdLibFunctions[overload.name]
+		sigp := []protocol.ParameterInformation{}
+		for _, param := range fn.Params {
+			sigp = append(sigp, protocol.ParameterInformation{
+				Label:         param.String(),
+				Documentation: strings.Join(param.Comment, "\n"),
+			})
+		}
+		res.Signatures = append(res.Signatures, protocol.SignatureInformation{
+			Label:           "std." + overload.name + fn.String(),
+			Documentation:   strings.Join(append([]string{overload.doc}, fn.Comment...), "\n\n"),
+			Parameters:      sigp,
+			ActiveParameter: uint32(activeParam),
+		})
+		if overload.argType == argType {
+			res.ActiveSignature = uint32(i)
+		}
+	}
+	return res
+}
+
+// locBefore checks if location `a` is strictly before `b`
+func locBefore(a, b ast.Location) bool {
+	return a.Line < b.Line || (a.Line == b.Line && a.Column < b.Column)
+}
+
+// isImportNode returns true for import, importstr and importbin nodes
+func isImportNode(node ast.Node) bool {
+	switch node.(type) {
+	case *ast.Import, *ast.ImportStr, *ast.ImportBin:
+		return true
+	}
+	return false
+}
+
+// isJsonnetFile returns true for file names with one of the configured FileExtensions
+func (c *Configuration) isJsonnetFile(name string) bool {
+	for _, ext := range c.FileExtensions {
+		if ext != "" && strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// importedFile returns the path of an import, importstr or importbin node
+func importedFile(node ast.Node) string {
+	switch n := node.(type) {
+	case *ast.Import:
+		return n.File.Value
+	case *ast.ImportStr:
+		return n.File.Value
+	case *ast.ImportBin:
+		return n.File.Value
+	}
+	return ""
+}
+
 func (s *Server) Hover(ctx context.Context, params *protocol.HoverParams) (result *protocol.Hover, err error) {
 	resolver := s.NewResolver(params.TextDocument.URI)
 	if resolver == nil {
 		return &protocol.Hover{}, nil
 	}
 
-	node, _ := resolver.NodeAt(protoToPos(params.Position))
+	explanation := s.explainDiagnostics(params.TextDocument.URI, params.Position)
+	node, stack := resolver.NodeAt(protoToPos(params.Position))
 	if node == nil {
+		if explanation != "" {
+			return &protocol.Hover{Contents: protocol.MarkupContent{Kind: protocol.PlainText, Value: explanation[2:]}}, nil
+		}
 		return &protocol.Hover{}, nil
 	}
 
@@ -551,10 +1359,30 @@ func (s *Server) Hover(ctx context.Context, params *protocol.HoverParams) (resul
 	if value.Function != nil {
 		doc += value.Function.String()
 	}
+	// Literals already show their value as the comment, only show folded constants
+	if cnst, ok := value.ConstantString(); ok && !isLiteral(value.Node) {
+		doc += "\n= " + cnst
+	}
+	if file := importedFile(node); file != "" {
+		if _, foundAt, err := s.importer.Import(params.TextDocument.URI.Filename(), file); err == nil {
+			doc += "\nresolved to " + foundAt
+		} else {
+			doc += fmt.Sprintf("\nimport not found: '%s'", file)
+		}
+	}
+	if path, ok := indexPath(node, resolver); ok {
+		doc += "\n" + path
+	}
 	if len(value.Comment) > 0 {
 		doc += "\n"
 		doc += strings.Join(value.Comment, "\n")
 	}
+	if s.fileConfig(params.TextDocument.URI).ManifestPreview {
+		if preview, ok := s.manifestPreview(ctx, resolver, stack); ok {
+			doc += "\n\nmanifested:\n" + preview
+		}
+	}
+	doc += explanation
 
 	return &protocol.Hover{
 		Range: rnge,
@@ -565,17 +1393,64 @@ func (s *Server) Hover(ctx context.Context, params *protocol.HoverParams) (resul
 	}, nil
 }
 
+// indexPath shows the type of every segment of a chain of field accesses, like
+// `a: object → b: object → c: number` for `a.b.c`. Only chains of at least two fields from a
+// variable or `self` are shown.
+func indexPath(node ast.Node, resolver analysis.Resolver) (string, bool) {
+	segments := []string{}
+	for {
+		n, ok := node.(*ast.Index)
+		if !ok {
+			break
+		}
+		idx, ok := n.Index.(*ast.LiteralString)
+		if !ok {
+			return "", false
+		}
+		segments = append(segments, fmt.Sprintf("%s: %s", idx.Value, analysis.NodeToValue(n, resolver).Type))
+		node = n.Target
+	}
+	switch root := node.(type) {
+	case *ast.Var:
+		segments = append(segments, fmt.Sprintf("%s: %s", root.Id, analysis.NodeToValue(root, resolver).Type))
+	case *ast.Self:
+		segments = append(segments, "self: object")
+	default:
+		return "", false
+	}
+	if len(segments) < 3 {
+		return "", false
+	}
+	for i, j := 0, len(segments)-1; i < j; i, j = i+1, j-1 {
+		segments[i], segments[j] = segments[j], segments[i]
+	}
+	return strings.Join(segments, " → "), true
+}
+
 func (s *Server) Definition(ctx context.Context, params *protocol.DefinitionParams) ([]protocol.Location, error) {
 	resolver := s.NewResolver(params.TextDocument.URI)
 	if resolver == nil {
 		return []protocol.Location{}, nil
 	}
 
-	node, _ := resolver.NodeAt(protoToPos(params.Position))
+	pos := protoToPos(params.Position)
+	node, _ := resolver.NodeAt(pos)
 	if node == nil {
 		return []protocol.Location{}, nil
 	}
 
+	// `fn(name=value)` jumps to the parameter `name` in the function definition
+	if call, ok := node.(*ast.Apply); ok {
+		if arg := analysis.NamedArgAt(call, pos); arg != nil {
+			return namedArgDefinition(call, arg, resolver), nil
+		}
+	}
+
+	// stdlib functions have no source, jump to their generated document instead
+	if locs := stdlibDefinition(node, resolver); locs != nil {
+		return locs, nil
+	}
+
 	value := analysis.NodeToValue(node, resolver)
 	if !value.Range.IsSet() {
 		return []protocol.Location{}, nil
@@ -588,34 +1463,328 @@ func (s *Server) Definition(ctx context.Context, params *protocol.DefinitionPara
 
 }
 
-func (s *Server) Formatting(ctx context.Context, params *protocol.DocumentFormattingParams) ([]protocol.TextEdit, error) {
-	current := s.overlay.Current(params.TextDocument.URI)
+// namedArgDefinition returns the location of the parameter of the called function with the
+// same name as the named argument
+func namedArgDefinition(call *ast.Apply, arg *ast.NamedArgument, resolver analysis.Resolver) []protocol.Location {
+	fn := analysis.NodeToValue(call.Target, resolver)
+	if fn.Function == nil {
+		return []protocol.Location{}
+	}
+	for _, param := range fn.Function.Params {
+		if param.Name != string(arg.Name) || !param.Range.IsSet() {
+			continue
+		}
+		return []protocol.Location{{
+			URI:   uri.File(param.Range.FileName),
+			Range: rangeToProto(param.Range),
+		}}
+	}
+	return []protocol.Location{}
+}
+
+// fieldAtCursor finds the name of the field under the cursor and the node defining its value,
+// either from a field definition `{name: ...}` or a field access `obj.name`.
+func fieldAtCursor(loc ast.Location, node ast.Node, stack []ast.Node, resolver analysis.Resolver) (string, ast.Node) {
+	switch n := node.(type) {
+	case *ast.DesugaredObject:
+		fld := analysis.FieldNameAt(n, loc)
+		if fld == nil {
+			return "", nil
+		}
+		name, ok := fld.Name.(*ast.LiteralString)
+		if !ok {
+			return "", nil
+		}
+		return name.Value, fld.Body
+	case *ast.Index:
+		// `obj.name` is desugared without a location for `name`
+		if !analysis.LocInRange(analysis.NodeRange(n.Target), loc) {
+			return indexedField(n, resolver)
+		}
+	case *ast.LiteralString:
+		// `obj["name"]`
+		if len(stack) < 2 {
+			return "", nil
+		}
+		if idx, ok := stack[len(stack)-2].(*ast.Index); ok && idx.Index == n {
+			return indexedField(idx, resolver)
+		}
+	}
+	return "", nil
+}
+
+func indexedField(idx *ast.Index, resolver analysis.Resolver) (string, ast.Node) {
+	name, ok := idx.Index.(*ast.LiteralString)
+	if !ok {
+		return "", nil
+	}
+	target := analysis.NodeToValue(idx.Target, resolver)
+	if target.Object == nil || target.Object.FieldMap[name.Value] == nil {
+		return "", nil
+	}
+	return name.Value, target.Object.FieldMap[name.Value].Node
+}
+
+// sameDefinition checks if two nodes are the same definition. Nodes from imported files may come
+// from a different VM, so fall back to comparing by location.
+func sameDefinition(a, b ast.Node) bool {
+	if a == nil || b == nil {
+		return false
+	}
+	if a == b {
+		return true
+	}
+	ra, rb := analysis.NodeRange(a), analysis.NodeRange(b)
+	return ra.IsSet() && ra.FileName == rb.FileName && ra.Begin == rb.Begin && ra.End == rb.End
+}
+
+// findFieldImplementations searches `root` for objects merged onto an object that has `name`
+// defined by `def`, and which override that field: `base + {name: ...}`.
+func findFieldImplementations(root ast.Node, resolver analysis.Resolver, name string, def ast.Node) []protocol.Location {
+	res := []protocol.Location{}
+	analysis.WalkStack(root, func(n ast.Node, _ []ast.Node) bool {
+		bin, ok := n.(*ast.Binary)
+		if !ok || bin.Op != ast.BopPlus {
+			return true
+		}
+		rhs, ok := bin.Right.(*ast.DesugaredObject)
+		if !ok {
+			return true
+		}
+		for _, fld := range rhs.Fields {
+			if fn, ok := fld.Name.(*ast.LiteralString); !ok || fn.Value != name || sameDefinition(fld.Body, def) {
+				continue
+			}
+			lhs := analysis.NodeToValue(bin.Left, resolver)
+			if lhs.Object == nil || lhs.Object.FieldMap[name] == nil || !sameDefinition(lhs.Object.FieldMap[name].Node, def) {
+				continue
+			}
+			res = append(res, protocol.Location{
+				URI:   uri.File(fld.LocRange.FileName),
+				Range: rangeToProto(fld.LocRange),
+			})
+		}
+		return true
+	})
+	return res
+}
+
+func (s *Server) Implementation(ctx context.Context, params *protocol.ImplementationParams) ([]protocol.Location, error) {
+	resolver := s.NewResolver(params.TextDocument.URI)
+	if resolver == nil {
+		return []protocol.Location{}, nil
+	}
+
+	pos := protoToPos(params.Position)
+	node, stack := resolver.NodeAt(pos)
+	name, def := fieldAtCursor(pos, node, stack, resolver)
+	if def == nil {
+		return []protocol.Location{}, nil
+	}
+
+	// TODO(@carlverge): Only the current file is searched. Searching other files needs
+	// a resolver per file, which would thrash the VM cache.
+	return findFieldImplementations(resolver.Root(), resolver, name, def), nil
+}
+
+// identFieldName returns the name of a field defined with an identifier (not a string or
+// computed name), and the range of the name.
+func identFieldName(fld *ast.DesugaredObjectField) (string, ast.LocationRange, bool) {
+	// identifier names are desugared to strings without a location
+	name, ok := fld.Name.(*ast.LiteralString)
+	if !ok || name.LocRange.IsSet() || !fld.LocRange.IsSet() {
+		return "", ast.LocationRange{}, false
+	}
+	begin := fld.LocRange.Begin
+	return name.Value, ast.LocationRange{FileName: fld.LocRange.FileName, Begin: begin, End: ast.Location{Line: begin.Line, Column: begin.Column + len(name.Value)}}, true
+}
+
+// dottedIndexName returns the field name of `target.name`, and the range of the name.
+func dottedIndexName(idx *ast.Index) (string, ast.LocationRange, bool) {
+	// dotted names are desugared to strings without a location
+	name, ok := idx.Index.(*ast.LiteralString)
+	if !ok || name.LocRange.IsSet() || !idx.LocRange.IsSet() {
+		return "", ast.LocationRange{}, false
+	}
+	end := idx.LocRange.End
+	if end.Column-len(name.Value) < 1 {
+		return "", ast.LocationRange{}, false
+	}
+	return name.Value, ast.LocationRange{FileName: idx.LocRange.FileName, Begin: ast.Location{Line: end.Line, Column: end.Column - len(name.Value)}, End: end}, true
+}
+
+// innermostObject returns the closest object in the stack, excluding the last node
+func innermostObject(stack []ast.Node) *ast.DesugaredObject {
+	for i := len(stack) - 2; i >= 0; i-- {
+		if obj, ok := stack[i].(*ast.DesugaredObject); ok {
+			return obj
+		}
+	}
+	return nil
+}
+
+// bindsDollar checks if `$` refers to the object, which is only the case for the outermost object
+func bindsDollar(obj *ast.DesugaredObject) bool {
+	for _, b := range obj.Locals {
+		if b.Variable == "$" {
+			return true
+		}
+	}
+	return false
+}
+
+// selfReferencedObject finds the object that `self.name` or `$.name` refers to
+func selfReferencedObject(idx *ast.Index, stack []ast.Node) *ast.DesugaredObject {
+	switch target := idx.Target.(type) {
+	case *ast.Self:
+		return innermostObject(stack)
+	case *ast.Var:
+		if target.Id != "$" {
+			return nil
+		}
+		for _, n := range stack {
+			if obj, ok := n.(*ast.DesugaredObject); ok && bindsDollar(obj) {
+				return obj
+			}
+		}
+	}
+	return nil
+}
+
+// linkedFieldRanges finds the name of the identifier field `name` in `obj`, and every
+// `self.name` or `$.name` referring to it.
+func linkedFieldRanges(obj *ast.DesugaredObject, name string) []protocol.Range {
+	res := []protocol.Range{}
+	for i := range obj.Fields {
+		if fldName, rng, ok := identFieldName(&obj.Fields[i]); ok && fldName == name {
+			res = append(res, rangeToProto(rng))
+		}
+	}
+	if len(res) == 0 {
+		return nil
+	}
+
+	analysis.WalkStack(obj, func(n ast.Node, stack []ast.Node) bool {
+		idx, ok := n.(*ast.Index)
+		if !ok {
+			return true
+		}
+		if idxName, rng, ok := dottedIndexName(idx); ok && idxName == name && selfReferencedObject(idx, stack) == obj {
+			res = append(res, rangeToProto(rng))
+		}
+		return true
+	})
+	return res
+}
+
+func (s *Server) LinkedEditingRange(ctx context.Context, params *protocol.LinkedEditingRangeParams) (*protocol.LinkedEditingRanges, error) {
+	resolver := s.NewResolver(params.TextDocument.URI)
+	if resolver == nil {
+		return nil, nil
+	}
+
+	pos := protoToPos(params.Position)
+	node, stack := resolver.NodeAt(pos)
+
+	var obj *ast.DesugaredObject
+	var name string
+	switch n := node.(type) {
+	case *ast.DesugaredObject:
+		fld := analysis.FieldNameAt(n, pos)
+		if fld == nil {
+			return nil, nil
+		}
+		fldName, rng, ok := identFieldName(fld)
+		if !ok || !analysis.LocInRange(rng, pos) {
+			return nil, nil
+		}
+		obj, name = n, fldName
+	case *ast.Index:
+		idxName, rng, ok := dottedIndexName(n)
+		if !ok || !analysis.LocInRange(rng, pos) {
+			return nil, nil
+		}
+		obj, name = selfReferencedObject(n, stack), idxName
+	}
+	if obj == nil {
+		return nil, nil
+	}
+
+	ranges := linkedFieldRanges(obj, name)
+	if len(ranges) < 2 {
+		return nil, nil
+	}
+	return &protocol.LinkedEditingRanges{Ranges: ranges}, nil
+}
+
+// formatDocument formats the current contents of the file. `tabSize` is the indent used if
+// none is configured.
+func (s *Server) formatDocument(u uri.URI, tabSize uint32) (before, after string, err error) {
+	current := s.overlay.Current(u)
 	if current == nil {
-		return []protocol.TextEdit{}, nil
+		return "", "", fmt.Errorf("file '%s' is not open", u.Filename())
 	}
 
-	fname := params.TextDocument.URI.Filename()
-	opts := s.config.FormatterOptions()
+	cfg := s.fileConfig(u)
+	opts := cfg.FormatterOptions()
 	if opts.Indent <= 0 {
-		opts.Indent = int(params.Options.TabSize)
+		opts.Indent = int(tabSize)
 	}
 
-	out, err := formatter.Format(fname, current.Contents, opts)
+	out, err := formatJsonnet(u.Filename(), current.Contents, opts, cfg.ObjectPadding())
+	if err == nil && cfg.Fmt.SortImports && cfg.Fmt.GroupImports {
+		out = s.groupImports(u.Filename(), out)
+	}
+	return current.Contents, out, err
+}
+
+func (s *Server) Formatting(ctx context.Context, params *protocol.DocumentFormattingParams) ([]protocol.TextEdit, error) {
+	before, out, err := s.formatDocument(params.TextDocument.URI, params.Options.TabSize)
 	if err != nil {
 		return []protocol.TextEdit{}, nil
 	}
-    lines := uint32(strings.Count(current.Contents, "\n") + 1)
+    lines := uint32(strings.Count(before, "\n") + 1)
 	return []protocol.TextEdit{{Range: protocol.Range{End: protocol.Position{Line: lines}}, NewText: string(out)}}, nil
 }
 
+type FormatPreviewParams struct {
+	TextDocument *protocol.TextDocumentIdentifier `json:"textDocument"`
+	// The indent used if none is configured
+	TabSize uint32 `json:"tabSize,omitempty"`
+}
+
+type FormatPreviewResult struct {
+	// Unified diff of the formatting changes, empty if the file is already formatted
+	Diff string `json:"diff"`
+}
+
+// FormatPreview shows what formatting the file would change, as a unified diff
+func (s *Server) FormatPreview(ctx context.Context, params *FormatPreviewParams) (*FormatPreviewResult, error) {
+	before, after, err := s.formatDocument(params.TextDocument.URI, params.TabSize)
+	if err != nil {
+		return nil, err
+	}
+	name := filepath.Base(params.TextDocument.URI.Filename())
+	edits := myers.ComputeEdits(span.URIFromURI(string(params.TextDocument.URI)), before, after)
+	return &FormatPreviewResult{Diff: fmt.Sprint(gotextdiff.ToUnified(name, name+" (formatted)", before, edits))}, nil
+}
+
 type EvaluateParams struct {
 	TextDocument *protocol.TextDocumentIdentifier `json:"textDocument"`
+	// Output selects a single top-level key to manifest, for files with multiple outputs (`jsonnet -m`)
+	Output string `json:"output,omitempty"`
 }
 
 type EvaluateResult struct {
 	Output string `json:"output"`
 }
 
+type ListOutputsResult struct {
+	Outputs []string `json:"outputs"`
+	// Error is set if the file could not be evaluated to an object
+	Error string `json:"error,omitempty"`
+}
+
 func formatRuntimeError(err error) string {
 	rt, ok := err.(jsonnet.RuntimeError)
 	if !ok {
@@ -636,18 +1805,108 @@ func (s *Server) Evaluate(ctx context.Context, params *EvaluateParams) (*Evaluat
 		return nil, fmt.Errorf("cannot get jsonnet VM for file '%s'", params.TextDocument.URI.Filename())
 	}
 
+	ctx, cancel := s.evaluateContext(ctx)
+	defer cancel()
+
 	result := &EvaluateResult{}
 	var err error
-	cvm.Use(func(vm *jsonnet.VM) {
-		result.Output, err = vm.Evaluate(curAST)
-		if err != nil {
-			result.Output = formatRuntimeError(err)
-		}
-	})
+	result.Output, err = s.evaluate(ctx, cvm, curAST)
+	if err == nil && params.Output != "" {
+		result.Output, err = selectOutput(result.Output, params.Output)
+	}
+	if err == errEvaluateTimeout {
+		result.Output = fmt.Sprintf("evaluation timed out after %dms", s.config.EvaluateTimeoutMs)
+	} else if err != nil {
+		result.Output = formatRuntimeError(err)
+	}
 	return result, nil
 }
 
+// evaluateOutputs evaluates a multi-output file, which must be an object of output name to contents
+func evaluateOutputs(output string) (map[string]json.RawMessage, error) {
+	outputs := map[string]json.RawMessage{}
+	if err := json.Unmarshal([]byte(output), &outputs); err != nil {
+		return nil, fmt.Errorf("multi-output evaluation must be an object: %v", err)
+	}
+	return outputs, nil
+}
+
+// selectOutput manifests a single key of a multi-output file, indented the same as jsonnet
+func selectOutput(output, key string) (string, error) {
+	outputs, err := evaluateOutputs(output)
+	if err != nil {
+		return "", err
+	}
+	raw, ok := outputs[key]
+	if !ok {
+		return "", fmt.Errorf("output '%s' not found", key)
+	}
+	buf := &bytes.Buffer{}
+	if err := json.Indent(buf, raw, "", "   "); err != nil {
+		return "", err
+	}
+	buf.WriteByte('\n')
+	return buf.String(), nil
+}
+
+// ListOutputs evaluates the file and lists its top-level keys, so an editor can choose which to
+// manifest with `Evaluate`.
+func (s *Server) ListOutputs(ctx context.Context, params *EvaluateParams) (*ListOutputsResult, error) {
+	cvm := s.getVM(params.TextDocument.URI)
+	curAST := s.getCurrentAST(params.TextDocument.URI)
+	if cvm == nil || curAST == nil {
+		return nil, fmt.Errorf("cannot get jsonnet VM for file '%s'", params.TextDocument.URI.Filename())
+	}
+
+	ctx, cancel := s.evaluateContext(ctx)
+	defer cancel()
+
+	result := &ListOutputsResult{Outputs: []string{}}
+	output, err := s.evaluate(ctx, cvm, curAST)
+	if err == errEvaluateTimeout {
+		result.Error = fmt.Sprintf("evaluation timed out after %dms", s.config.EvaluateTimeoutMs)
+		return result, nil
+	} else if err != nil {
+		result.Error = formatRuntimeError(err)
+		return result, nil
+	}
+
+	outputs, err := evaluateOutputs(output)
+	if err != nil {
+		result.Error = err.Error()
+		return result, nil
+	}
+	for key := range outputs {
+		result.Outputs = append(result.Outputs, key)
+	}
+	sort.Strings(result.Outputs)
+	return result, nil
+}
+
+// toggleDiag flips a diagnostics setting and re-runs diagnostics on all open files. Returns the
+// new value of the setting.
+func (s *Server) toggleDiag(ctx context.Context, flag func(*DiagConfiguration) *bool) bool {
+	cfg := *s.config
+	enabled := flag(&cfg.Diag)
+	*enabled = !*enabled
+	// Racy in the same way as DidChangeConfiguration
+	s.config = &cfg
+
+	for _, u := range s.overlay.Open() {
+		s.overlay.Refresh(u, s.processFileUpdateFn(ctx, u, cfg.Diag.EvaluateOn))
+	}
+	return *enabled
+}
+
 func (s *Server) ExecuteCommand(ctx context.Context, params *protocol.ExecuteCommandParams) (result interface{}, err error) {
+	// Commands without arguments
+	switch params.Command {
+	case "jsonnet.lsp.toggleLinter":
+		return s.toggleDiag(ctx, func(c *DiagConfiguration) *bool { return &c.Linter }), nil
+	case "jsonnet.lsp.toggleEvaluate":
+		return s.toggleDiag(ctx, func(c *DiagConfiguration) *bool { return &c.Evaluate }), nil
+	}
+
 	if len(params.Arguments) != 1 {
 		return nil, jsonrpc2.ErrInvalidParams
 	}
@@ -663,6 +1922,54 @@ func (s *Server) ExecuteCommand(ctx context.Context, params *protocol.ExecuteCom
 			return nil, jsonrpc2.ErrInvalidParams
 		}
 		return s.Evaluate(ctx, args)
+	case "jsonnet.lsp.listOutputs":
+		args := &EvaluateParams{}
+		if err := json.Unmarshal([]byte(argData), args); err != nil {
+			return nil, jsonrpc2.ErrInvalidParams
+		}
+		return s.ListOutputs(ctx, args)
+	case "jsonnet.lsp.sortFields":
+		args := &SortFieldsParams{}
+		if err := json.Unmarshal([]byte(argData), args); err != nil || args.TextDocument == nil {
+			return nil, jsonrpc2.ErrInvalidParams
+		}
+		return s.SortFields(ctx, args)
+	case "jsonnet.lsp.formatSubtree":
+		args := &FormatSubtreeParams{}
+		if err := json.Unmarshal([]byte(argData), args); err != nil || args.TextDocument == nil {
+			return nil, jsonrpc2.ErrInvalidParams
+		}
+		return s.FormatSubtree(ctx, args)
+	case "jsonnet.lsp.formatPreview":
+		args := &FormatPreviewParams{}
+		if err := json.Unmarshal([]byte(argData), args); err != nil || args.TextDocument == nil {
+			return nil, jsonrpc2.ErrInvalidParams
+		}
+		return s.FormatPreview(ctx, args)
+	case "jsonnet.lsp.exportSchema":
+		args := &ExportSchemaParams{}
+		if err := json.Unmarshal([]byte(argData), args); err != nil || args.TextDocument == nil {
+			return nil, jsonrpc2.ErrInvalidParams
+		}
+		return s.ExportSchema(ctx, args)
+	case "jsonnet.lsp.stdlibDocument":
+		args := &StdlibDocumentParams{}
+		if err := json.Unmarshal([]byte(argData), args); err != nil {
+			return nil, jsonrpc2.ErrInvalidParams
+		}
+		return s.StdlibDocument(ctx, args)
+	case "jsonnet.lsp.symbolId":
+		args := &SymbolIDParams{}
+		if err := json.Unmarshal([]byte(argData), args); err != nil || args.TextDocument == nil {
+			return nil, jsonrpc2.ErrInvalidParams
+		}
+		return s.SymbolID(ctx, args)
+	case "jsonnet.lsp.unresolvedImports":
+		args := &UnresolvedImportsParams{}
+		if err := json.Unmarshal([]byte(argData), args); err != nil || args.TextDocument == nil {
+			return nil, jsonrpc2.ErrInvalidParams
+		}
+		return s.UnresolvedImports(ctx, args)
 	}
 
 	return nil, jsonrpc2.ErrMethodNotFound
@@ -0,0 +1,1822 @@
+package lsp
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/carlverge/jsonnet-lsp/pkg/analysis"
+	"github.com/carlverge/jsonnet-lsp/pkg/linter"
+	"github.com/carlverge/jsonnet-lsp/pkg/overlay"
+	"github.com/google/go-jsonnet"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.lsp.dev/protocol"
+	"go.lsp.dev/uri"
+)
+
+// newTestServer creates a server rooted in a temporary directory containing `files`
+func newTestServer(t *testing.T, files map[string]string) *Server {
+	root := t.TempDir()
+	for name, contents := range files {
+		path := filepath.Join(root, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(contents), 0o644))
+	}
+	s := &Server{
+		FallbackServer: &FallbackServer{},
+		overlay:        overlay.NewOverlay(),
+		symbols:        newSymbolIndex(),
+		config:         defaultConfiguration(),
+		rootURI:        uri.File(root),
+		rootFS:         os.DirFS(root),
+	}
+	s.importer = &OverlayImporter{overlay: s.overlay, rootURI: s.rootURI, rootFS: s.rootFS}
+	return s
+}
+
+// open adds the file to the overlay and waits for it to be parsed
+func (s *Server) open(t *testing.T, name, contents string) uri.URI {
+	u := uri.File(filepath.Join(s.rootURI.Filename(), name))
+	s.overlay.ReplaceSync(u, 1, contents, parseJsonnetFn(u))
+	require.NotNil(t, s.getCurrentAST(u), "could not parse %s", name)
+	return u
+}
+
+// completionLabels returns the sorted labels of completion items at line/col (1-indexed)
+func completionLabels(t *testing.T, s *Server, u uri.URI, line, col int, trigger string) []string {
+	params := &protocol.CompletionParams{
+		TextDocumentPositionParams: protocol.TextDocumentPositionParams{
+			TextDocument: protocol.TextDocumentIdentifier{URI: u},
+			Position:     protocol.Position{Line: uint32(line - 1), Character: uint32(col - 1)},
+		},
+	}
+	if trigger != "" {
+		params.Context = &protocol.CompletionContext{TriggerCharacter: trigger}
+	}
+	res, err := s.Completion(context.Background(), params)
+	require.NoError(t, err)
+	labels := []string{}
+	for _, item := range res.Items {
+		labels = append(labels, item.Label)
+	}
+	sort.Strings(labels)
+	return labels
+}
+
+func TestParseConfigurationKeepsDefaults(t *testing.T) {
+	cfg, err := parseConfiguration([]byte(`{"fmt": {"stringStyle": "leave"}}`))
+	require.NoError(t, err)
+
+	dflt := defaultConfiguration()
+	assert.Equal(t, "leave", cfg.Fmt.StringStyle)
+	assert.Equal(t, dflt.Fmt.Indent, cfg.Fmt.Indent)
+	assert.Equal(t, dflt.Fmt.CommentStyle, cfg.Fmt.CommentStyle)
+	assert.Equal(t, dflt.Diag, cfg.Diag)
+}
+
+type formatCase struct {
+	Name   string
+	Config string
+	Source string
+	Expect string
+}
+
+func TestFormatterOptions(t *testing.T) {
+	mixedQuotes := "{\n  a: 'single',\n  b: \"double\",\n}\n"
+	mixedComments := "# hash\n// slash\n{}\n"
+	padding := "local o = {a: {b: 1}, c: {}};\n[o, {a: [\n  1,\n]}]\n"
+	blankLines := "local a = 1;\n\n\n\nlocal b = 2;\n\n{\n  a: a,\n\n\n\n  b: |||\n    x\n\n\n    y\n  |||,\n}\n"
+	cases := []formatCase{
+		{
+			Name:   "StringStyleLeave",
+			Config: `{"fmt": {"stringStyle": "leave"}}`,
+			Source: mixedQuotes,
+			Expect: mixedQuotes,
+		},
+		{
+			Name:   "StringStyleLeaveAlone",
+			Config: `{"fmt": {"stringStyle": "Leave Alone"}}`,
+			Source: mixedQuotes,
+			Expect: mixedQuotes,
+		},
+		{
+			Name:   "StringStyleDouble",
+			Config: `{"fmt": {"stringStyle": "\""}}`,
+			Source: mixedQuotes,
+			Expect: "{\n  a: \"single\",\n  b: \"double\",\n}\n",
+		},
+		{
+			Name:   "StringStyleSingle",
+			Config: `{"fmt": {"stringStyle": "'"}}`,
+			Source: mixedQuotes,
+			Expect: "{\n  a: 'single',\n  b: 'double',\n}\n",
+		},
+		{
+			Name:   "CommentStyleLeave",
+			Config: `{"fmt": {"commentStyle": "leave"}}`,
+			Source: mixedComments,
+			Expect: mixedComments,
+		},
+		{
+			Name:   "CommentStyleLeaveAlone",
+			Config: `{"fmt": {"commentStyle": "Leave Alone"}}`,
+			Source: mixedComments,
+			Expect: mixedComments,
+		},
+		{
+			Name:   "CommentStyleHash",
+			Config: `{"fmt": {"commentStyle": "#"}}`,
+			Source: mixedComments,
+			Expect: "# hash\n# slash\n{}\n",
+		},
+		{
+			Name:   "CommentStyleSlash",
+			Config: `{"fmt": {"commentStyle": "//"}}`,
+			Source: mixedComments,
+			Expect: "// hash\n// slash\n{}\n",
+		},
+		{
+			// Unrelated settings must not reset the comment style to its zero value
+			Name:   "CommentStyleDefault",
+			Config: `{"fmt": {"indent": 4}}`,
+			Source: mixedComments,
+			Expect: "// hash\n// slash\n{}\n",
+		},
+		{
+			Name:   "PadObjects",
+			Config: `{"fmt": {"padObjects": true}}`,
+			Source: padding,
+			Expect: "local o = { a: { b: 1 }, c: {} };\n[o, { a: [\n  1,\n] }]\n",
+		},
+		{
+			Name:   "PadInlineObjectsOnly",
+			Config: `{"fmt": {"padObjects": true, "padMultilineObjects": false}}`,
+			Source: padding,
+			Expect: "local o = { a: { b: 1 }, c: {} };\n[o, {a: [\n  1,\n]}]\n",
+		},
+		{
+			Name:   "PadMultilineObjectsOnly",
+			Config: `{"fmt": {"padObjects": false, "padMultilineObjects": true}}`,
+			Source: padding,
+			Expect: "local o = {a: {b: 1}, c: {}};\n[o, { a: [\n  1,\n] }]\n",
+		},
+		{
+			// unset follows padObjects
+			Name:   "PadObjectsNull",
+			Config: `{"fmt": {"padObjects": false, "padInlineObjects": null}}`,
+			Source: padding,
+			Expect: "local o = {a: {b: 1}, c: {}};\n[o, {a: [\n  1,\n]}]\n",
+		},
+		{
+			Name:   "MaxBlankLinesOne",
+			Config: `{"fmt": {"maxBlankLines": 1}}`,
+			Source: blankLines,
+			Expect: "local a = 1;\n\nlocal b = 2;\n\n{\n  a: a,\n\n  b: |||\n    x\n\n\n    y\n  |||,\n}\n",
+		},
+		{
+			// zero removes every blank line, rather than being treated as no limit
+			Name:   "MaxBlankLinesZero",
+			Config: `{"fmt": {"maxBlankLines": 0, "indent": 2}}`,
+			Source: blankLines,
+			Expect: "local a = 1;\nlocal b = 2;\n{\n  a: a,\n  b: |||\n    x\n\n\n    y\n  |||,\n}\n",
+		},
+		{
+			// blank lines are only removed between tokens, not inside of block comments
+			Name:   "MaxBlankLinesZeroComment",
+			Config: `{"fmt": {"maxBlankLines": 0}}`,
+			Source: "local a = '/*';\n\n/* first\n\n   second */\n\n# end\n\n{a: a}\n",
+			Expect: "local a = \"/*\";\n/* first\n\n   second */\n// end\n{ a: a }\n",
+		},
+		{
+			// Unrelated settings must not reset the max blank lines to zero
+			Name:   "MaxBlankLinesDefault",
+			Config: `{"fmt": {"indent": 2}}`,
+			Source: blankLines,
+			Expect: "local a = 1;\n\n\nlocal b = 2;\n\n{\n  a: a,\n\n\n  b: |||\n    x\n\n\n    y\n  |||,\n}\n",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			cfg, err := parseConfiguration([]byte(c.Config))
+			require.NoError(t, err)
+			out, err := formatJsonnet("test.jsonnet", c.Source, cfg.FormatterOptions(), cfg.ObjectPadding())
+			require.NoError(t, err)
+			assert.Equal(t, c.Expect, out)
+		})
+	}
+}
+
+func TestFormatGroupImports(t *testing.T) {
+	s := newTestServer(t, map[string]string{
+		"vendor/k.libsonnet":    "{}",
+		"lib/util.libsonnet":    "{}",
+		"app/sibling.libsonnet": "{}",
+	})
+	s.importer.SetJPaths([]string{"vendor"})
+	s.config.Fmt.GroupImports = true
+	u := s.open(t, "app/main.jsonnet", "// header\nlocal util = import 'lib/util.libsonnet';\nlocal sib = import 'sibling.libsonnet';\n\nlocal k = import 'k.libsonnet';\nlocal missing = import 'missing.libsonnet';\n{}\n")
+
+	_, out, err := s.formatDocument(u, 2)
+	require.NoError(t, err)
+	assert.Equal(t, "// header\nlocal k = import \"k.libsonnet\";\n\nlocal util = import \"lib/util.libsonnet\";\n\nlocal missing = import \"missing.libsonnet\";\nlocal sib = import \"sibling.libsonnet\";\n{}\n", out)
+
+	// reordering imports binding the same name would change which one is used
+	dup := s.open(t, "app/dup.jsonnet", "local a = import 'sibling.libsonnet';\nlocal a = import 'k.libsonnet';\na\n")
+	_, out, err = s.formatDocument(dup, 2)
+	require.NoError(t, err)
+	assert.Equal(t, "local a = import \"sibling.libsonnet\";\nlocal a = import \"k.libsonnet\";\na\n", out)
+}
+
+func TestFormatPreview(t *testing.T) {
+	s := newTestServer(t, nil)
+	u := s.open(t, "main.jsonnet", "{a:1,\n  'b': \"x\"}\n")
+	res, err := s.ExecuteCommand(context.Background(), &protocol.ExecuteCommandParams{
+		Command:   "jsonnet.lsp.formatPreview",
+		Arguments: []interface{}{`{"textDocument": {"uri": "` + string(u) + `"}}`},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "--- main.jsonnet\n+++ main.jsonnet (formatted)\n@@ -1,2 +1,4 @@\n-{a:1,\n-  'b': \"x\"}\n+{\n+  a: 1,\n+  b: \"x\",\n+}\n", res.(*FormatPreviewResult).Diff)
+
+	// nothing to change once formatted
+	formatted := s.open(t, "formatted.jsonnet", "{\n  a: 1,\n}\n")
+	preview, err := s.FormatPreview(context.Background(), &FormatPreviewParams{TextDocument: &protocol.TextDocumentIdentifier{URI: formatted}})
+	require.NoError(t, err)
+	assert.Equal(t, "", preview.Diff)
+}
+
+func TestCompletionArraySlice(t *testing.T) {
+	s := newTestServer(t, nil)
+	u := s.open(t, "main.jsonnet", "local arr = [1, 2, 3];\nlocal str = 'abc';\n[arr[0], arr[1:2], str[0]]\n")
+
+	assert.Contains(t, completionLabels(t, s, u, 3, 6, ""), "start:end")
+	assert.Contains(t, completionLabels(t, s, u, 3, 15, ""), "start:end:step")
+	assert.NotContains(t, completionLabels(t, s, u, 3, 24, ""), "start:end")
+}
+
+func textDocumentPosition(u uri.URI, line, col int) protocol.TextDocumentPositionParams {
+	return protocol.TextDocumentPositionParams{
+		TextDocument: protocol.TextDocumentIdentifier{URI: u},
+		Position:     protocol.Position{Line: uint32(line - 1), Character: uint32(col - 1)},
+	}
+}
+
+func TestImplementation(t *testing.T) {
+	s := newTestServer(t, nil)
+	u := s.open(t, "main.jsonnet", `local base = {
+  name:: error 'must override',
+};
+[base + {name: 'a'}, base {name: 'b'}, {name: 'c'}, base.name]
+`)
+
+	expect := []protocol.Range{
+		{Start: protocol.Position{Line: 3, Character: 9}, End: protocol.Position{Line: 3, Character: 18}},
+		{Start: protocol.Position{Line: 3, Character: 27}, End: protocol.Position{Line: 3, Character: 36}},
+	}
+
+	// from the field definition, and from a reference to the field
+	for _, pos := range [][2]int{{2, 4}, {4, 58}} {
+		locs, err := s.Implementation(context.Background(), &protocol.ImplementationParams{TextDocumentPositionParams: textDocumentPosition(u, pos[0], pos[1])})
+		require.NoError(t, err)
+		ranges := []protocol.Range{}
+		for _, l := range locs {
+			assert.Equal(t, u, l.URI)
+			ranges = append(ranges, l.Range)
+		}
+		assert.Equal(t, expect, ranges, "implementations from %v", pos)
+	}
+}
+
+// blockingImporter blocks every import until released, to keep an evaluation running
+type blockingImporter struct {
+	release chan struct{}
+}
+
+func (imp *blockingImporter) Import(from, path string) (jsonnet.Contents, string, error) {
+	<-imp.release
+	return jsonnet.MakeContents("1"), path, nil
+}
+
+// blockEvaluation makes the imports of the evaluations of the file block until the end of the
+// test, which then waits for the abandoned evaluation of the file to finish
+func blockEvaluation(t *testing.T, s *Server, u uri.URI) {
+	imp := &blockingImporter{release: make(chan struct{})}
+	s.getVM(u).importer.real = imp
+	t.Cleanup(func() {
+		s.evalLock.Lock()
+		abandoned := s.abandonedEvals[u]
+		s.evalLock.Unlock()
+		close(imp.release)
+		if abandoned == nil {
+			return
+		}
+		select {
+		case <-abandoned:
+		case <-time.After(time.Second):
+			t.Error("abandoned evaluation did not finish")
+		}
+	})
+}
+
+func TestEvaluateTimeout(t *testing.T) {
+	s := newTestServer(t, nil)
+	s.config.EvaluateTimeoutMs = 50
+	u := s.open(t, "main.jsonnet", "import 'slow.libsonnet'")
+	blockEvaluation(t, s, u)
+
+	res, err := s.Evaluate(context.Background(), &EvaluateParams{TextDocument: &protocol.TextDocumentIdentifier{URI: u}})
+	require.NoError(t, err)
+	assert.Equal(t, "evaluation timed out after 50ms", res.Output)
+	// the abandoned VM must not be reused
+	assert.Empty(t, s.vms)
+
+	// evaluations of the file wait for the abandoned evaluation instead of starting another
+	s.evalLock.Lock()
+	abandoned := s.abandonedEvals[u]
+	s.evalLock.Unlock()
+	require.NotNil(t, abandoned)
+	diags, err := s.evaluateDiags(context.Background(), s.NewResolver(u))
+	require.NoError(t, err)
+	require.Len(t, diags, 1)
+	assert.Equal(t, "evaluation timed out after 50ms", diags[0].Message)
+	assert.Equal(t, protocol.Range{End: protocol.Position{Character: 23}}, diags[0].Range)
+	s.evalLock.Lock()
+	assert.Equal(t, abandoned, s.abandonedEvals[u])
+	s.evalLock.Unlock()
+}
+
+func TestEvaluateSandbox(t *testing.T) {
+	outside := filepath.Join(t.TempDir(), "secret.libsonnet")
+	require.NoError(t, os.WriteFile(outside, []byte("'secret'"), 0o644))
+	s := newTestServer(t, map[string]string{"lib.libsonnet": "'lib'"})
+	u := s.open(t, "main.jsonnet", "[import 'lib.libsonnet', import '"+outside+"']")
+	evaluate := func() string {
+		res, err := s.Evaluate(context.Background(), &EvaluateParams{TextDocument: &protocol.TextDocumentIdentifier{URI: u}})
+		require.NoError(t, err)
+		return res.Output
+	}
+
+	assert.JSONEq(t, `["lib", "secret"]`, evaluate())
+
+	s.config.SandboxEvaluate = true
+	s.vms = nil
+	assert.Contains(t, evaluate(), "not found inside the workspace")
+
+	u = s.open(t, "main.jsonnet", "import 'lib.libsonnet'")
+	assert.JSONEq(t, `"lib"`, evaluate())
+}
+
+func TestEvaluateCreatedImport(t *testing.T) {
+	s := newTestServer(t, nil)
+	u := s.open(t, "main.jsonnet", "import 'lib/missing.libsonnet'")
+	evaluate := func() string {
+		res, err := s.Evaluate(context.Background(), &EvaluateParams{TextDocument: &protocol.TextDocumentIdentifier{URI: u}})
+		require.NoError(t, err)
+		return res.Output
+	}
+	assert.Contains(t, evaluate(), "not found")
+
+	created := filepath.Join(s.rootURI.Filename(), "lib/missing.libsonnet")
+	require.NoError(t, os.MkdirAll(filepath.Dir(created), 0o755))
+	require.NoError(t, os.WriteFile(created, []byte("'found'"), 0o644))
+	require.NoError(t, s.DidChangeWatchedFiles(context.Background(), &protocol.DidChangeWatchedFilesParams{
+		Changes: []*protocol.FileEvent{{URI: uri.File(created), Type: protocol.FileChangeTypeCreated}},
+	}))
+	assert.JSONEq(t, `"found"`, evaluate())
+}
+
+func TestVMCache(t *testing.T) {
+	s := newTestServer(t, map[string]string{"lib.libsonnet": "{ a: 1 }"})
+	s.config.VMCacheSize = 2
+	a := s.open(t, "a.jsonnet", "{}")
+	b := s.open(t, "b.jsonnet", "(import 'lib.libsonnet').a")
+	c := s.open(t, "c.jsonnet", "{}")
+
+	vmA := s.getVM(a)
+	vmB := s.getVM(b)
+	_, found := vmB.ImportAST(b.Filename(), "lib.libsonnet")
+	require.NotEqual(t, uri.URI(""), found)
+
+	// switching back to a file reuses its VM
+	assert.Same(t, vmA, s.getVM(a))
+	assert.Same(t, vmB, s.getVM(b))
+
+	// the least recently used VM is dropped
+	vmC := s.getVM(c)
+	assert.Equal(t, []*vmCache{vmC, vmB}, s.vms)
+	assert.NotSame(t, vmA, s.getVM(a))
+	assert.Len(t, s.vms, 2)
+
+	// changing an imported file drops the VMs that imported it
+	vmB = s.getVM(b)
+	vmB.ImportAST(b.Filename(), "lib.libsonnet")
+	s.invalidateVMs(found)
+	assert.Len(t, s.vms, 1)
+	assert.NotSame(t, vmB, s.getVM(b))
+}
+
+func TestEvaluationCanceledByChange(t *testing.T) {
+	s := newTestServer(t, nil)
+	s.config.EvaluateTimeoutMs = 0
+	u := s.open(t, "main.jsonnet", "import 'slow.libsonnet'")
+	blockEvaluation(t, s, u)
+
+	type result struct {
+		diags []protocol.Diagnostic
+		err   error
+	}
+	done := make(chan result)
+	go func() {
+		diags, err := s.evaluateDiags(context.Background(), s.NewResolver(u))
+		done <- result{diags, err}
+	}()
+
+	// wait for the evaluation to start before canceling it
+	require.Eventually(t, func() bool {
+		s.evalLock.Lock()
+		defer s.evalLock.Unlock()
+		return s.evalCancels[u] != nil
+	}, time.Second, time.Millisecond)
+	s.cancelEvaluation(u)
+
+	select {
+	case res := <-done:
+		assert.Nil(t, res.diags)
+		assert.ErrorIs(t, res.err, context.Canceled)
+	case <-time.After(time.Second):
+		t.Fatal("evaluation was not canceled")
+	}
+	// the VM still running the canceled evaluation is not reused
+	assert.Empty(t, s.vms)
+}
+
+func TestEvaluationCanceledBeforeStart(t *testing.T) {
+	s := newTestServer(t, nil)
+	u := s.open(t, "main.jsonnet", "{a: 1}")
+	vm := s.getVM(u)
+
+	// the evaluation waits for the VM, which is busy until the evaluation is canceled
+	vm.lock.Lock()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := s.evaluate(ctx, vm, s.getCurrentAST(u))
+	vm.lock.Unlock()
+	assert.ErrorIs(t, err, context.Canceled)
+
+	// nothing ran on the VM, so it is kept
+	assert.Same(t, vm, s.getVM(u))
+	out, err := s.evaluate(context.Background(), vm, s.getCurrentAST(u))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"a": 1}`, out)
+}
+
+func TestCompletionImportedObject(t *testing.T) {
+	libs := map[string]string{
+		"plain.libsonnet":   "{foo: 1, bar:: 2}\n",
+		"locals.libsonnet":  "local x = 1;\nlocal f(y) = y;\n{foo: x, bar:: f(2)}\n",
+		"asserts.libsonnet": "local x = 1;\nassert x == 1;\nlocal obj = {foo: x, bar:: 2};\nobj\n",
+		"merged.libsonnet":  "local base = {foo: 1};\nbase + {bar:: 2}\n",
+		"nested.libsonnet":  "local lib = import 'locals.libsonnet';\nlib\n",
+	}
+	s := newTestServer(t, libs)
+	for name := range libs {
+		t.Run(name, func(t *testing.T) {
+			u := s.open(t, "main.jsonnet", "local lib = import '"+name+"';\nlib\n")
+			assert.Equal(t, []string{"bar", "foo"}, completionLabels(t, s, u, 2, 4, "."))
+		})
+	}
+}
+
+func TestDefinitionAcrossImports(t *testing.T) {
+	s := newTestServer(t, map[string]string{
+		"lib/util.libsonnet": "local x = 1;\n{\n  add(a, b):: a + b,\n  val: x,\n  me: $,\n}\n",
+		"lib/fn.libsonnet":   "function(a) a\n",
+	})
+	u := s.open(t, "main.jsonnet", "local lib = import 'lib/util.libsonnet';\nlocal fn = import 'lib/fn.libsonnet';\n[lib.add(1, 2), lib.val, lib.me, fn(1)]\n")
+	util := uri.File(filepath.Join(s.rootURI.Filename(), "lib/util.libsonnet"))
+	fn := uri.File(filepath.Join(s.rootURI.Filename(), "lib/fn.libsonnet"))
+
+	cases := []struct {
+		Name  string
+		Col   int
+		URI   uri.URI
+		Range protocol.Range
+	}{
+		{"Function", 6, util, protocol.Range{Start: protocol.Position{Line: 2, Character: 14}, End: protocol.Position{Line: 2, Character: 19}}},
+		{"Local", 21, util, protocol.Range{Start: protocol.Position{Line: 0, Character: 10}, End: protocol.Position{Line: 0, Character: 11}}},
+		{"RootObject", 30, util, protocol.Range{Start: protocol.Position{Line: 1, Character: 0}, End: protocol.Position{Line: 5, Character: 1}}},
+		{"ImportedFunction", 34, fn, protocol.Range{Start: protocol.Position{Line: 0, Character: 0}, End: protocol.Position{Line: 0, Character: 13}}},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			locs, err := s.Definition(context.Background(), &protocol.DefinitionParams{TextDocumentPositionParams: textDocumentPosition(u, 3, c.Col)})
+			require.NoError(t, err)
+			require.Len(t, locs, 1)
+			assert.Equal(t, c.URI, locs[0].URI)
+			assert.Equal(t, c.Range, locs[0].Range)
+		})
+	}
+}
+
+func TestDefinitionNamedArgument(t *testing.T) {
+	s := newTestServer(t, map[string]string{
+		"lib/util.libsonnet": "{\n  make(name, port=80):: {},\n}\n",
+	})
+	u := s.open(t, "main.jsonnet", "local lib = import 'lib/util.libsonnet';\nlib.make(port=8080, name='a')\n")
+	util := uri.File(filepath.Join(s.rootURI.Filename(), "lib/util.libsonnet"))
+
+	definition := func(col int) []protocol.Location {
+		locs, err := s.Definition(context.Background(), &protocol.DefinitionParams{TextDocumentPositionParams: textDocumentPosition(u, 2, col)})
+		require.NoError(t, err)
+		return locs
+	}
+
+	assert.Equal(t, []protocol.Location{{URI: util, Range: protocol.Range{Start: protocol.Position{Line: 1, Character: 13}, End: protocol.Position{Line: 1, Character: 20}}}}, definition(11))
+	assert.Equal(t, []protocol.Location{{URI: util, Range: protocol.Range{Start: protocol.Position{Line: 1, Character: 7}, End: protocol.Position{Line: 1, Character: 11}}}}, definition(22))
+}
+
+func TestDefinitionStdlib(t *testing.T) {
+	s := newTestServer(t, nil)
+	u := s.open(t, "main.jsonnet", "local s = std;\n[std.abs(-1), s.length([])]\n")
+
+	definition := func(col int) []protocol.Location {
+		locs, err := s.Definition(context.Background(), &protocol.DefinitionParams{TextDocumentPositionParams: textDocumentPosition(u, 2, col)})
+		require.NoError(t, err)
+		return locs
+	}
+
+	locs := definition(6)
+	require.Len(t, locs, 1)
+	assert.Equal(t, uri.URI("jsonnet-stdlib://std/abs"), locs[0].URI)
+
+	res, err := s.ExecuteCommand(context.Background(), &protocol.ExecuteCommandParams{
+		Command:   "jsonnet.lsp.stdlibDocument",
+		Arguments: []interface{}{`{"uri": "jsonnet-stdlib://std/abs"}`},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "std.abs(n: number) -> number\n", res.(*StdlibDocumentResult).Contents)
+	assert.Equal(t, protocol.Range{Start: protocol.Position{Character: 4}, End: protocol.Position{Character: 7}}, locs[0].Range)
+
+	// documented functions start with their comment, and aliases of std work
+	locs = definition(17)
+	require.Len(t, locs, 1)
+	assert.Equal(t, uri.URI("jsonnet-stdlib://std/length"), locs[0].URI)
+	doc, err := s.StdlibDocument(context.Background(), &StdlibDocumentParams{URI: locs[0].URI})
+	require.NoError(t, err)
+	lines := strings.Split(doc.Contents, "\n")
+	assert.True(t, strings.HasPrefix(lines[0], "// "), lines[0])
+	assert.Equal(t, "std.length", lines[locs[0].Range.Start.Line][:locs[0].Range.End.Character])
+
+	_, err = s.StdlibDocument(context.Background(), &StdlibDocumentParams{URI: "jsonnet-stdlib://std/nope"})
+	assert.Error(t, err)
+}
+
+func TestHoverConstant(t *testing.T) {
+	s := newTestServer(t, nil)
+	u := s.open(t, "main.jsonnet", "local port = 8080 + 1;\nlocal neg = -(port * 2);\nlocal name = 'svc' + '-' + 'a';\nlocal off = !true;\nlocal lit = 5;\nlocal unknown = std.length([]) + 1;\n[port, neg, name, off, lit, unknown, 1 / 0]\n")
+
+	hover := func(col int) string {
+		res, err := s.Hover(context.Background(), &protocol.HoverParams{TextDocumentPositionParams: textDocumentPosition(u, 7, col)})
+		require.NoError(t, err)
+		return res.Contents.Value
+	}
+	assert.Equal(t, "number\n= 8081", hover(2))
+	assert.Equal(t, "number\n= -16162", hover(8))
+	assert.Equal(t, "string\n= \"svc-a\"", hover(13))
+	assert.Equal(t, "boolean\n= false", hover(19))
+	assert.Equal(t, "number\n5", hover(24))
+	assert.Equal(t, "any", hover(29))
+	assert.Equal(t, "any", hover(40))
+}
+
+func TestHoverIndexPath(t *testing.T) {
+	s := newTestServer(t, nil)
+	u := s.open(t, "main.jsonnet", "local cfg = {server: {port: 80, tls: {}}};\n[cfg.server.port, cfg.server, cfg.server.tls.cert, cfg[std.toString(1)].port]\n")
+	hover := func(col int) string {
+		res, err := s.Hover(context.Background(), &protocol.HoverParams{TextDocumentPositionParams: textDocumentPosition(u, 2, col)})
+		require.NoError(t, err)
+		return res.Contents.Value
+	}
+
+	assert.Equal(t, "number\ncfg: object → server: object → port: number\n80", hover(14))
+	// a single field is not a chain
+	assert.NotContains(t, hover(27), "→")
+	// unknown fields are shown as any
+	assert.Contains(t, hover(46), "cfg: object → server: object → tls: object → cert: any")
+	// the path is not known through a computed index
+	assert.NotContains(t, hover(72), "→")
+}
+
+func TestExtVars(t *testing.T) {
+	s := newTestServer(t, nil)
+	u := s.open(t, "main.jsonnet", "local name = 'svc-' + std.extVar('env');\nlocal other = std.extVar('other');\n{name: name, other: other}\n")
+	hover := func(col int) string {
+		res, err := s.Hover(context.Background(), &protocol.HoverParams{TextDocumentPositionParams: textDocumentPosition(u, 3, col)})
+		require.NoError(t, err)
+		return res.Contents.Value
+	}
+	assert.Equal(t, "any", hover(8))
+
+	cfg, err := parseConfiguration([]byte(`{"extVars": {"env": "prod", "other": "x"}}`))
+	require.NoError(t, err)
+	require.NoError(t, s.DidChangeConfiguration(context.Background(), &protocol.DidChangeConfigurationParams{Settings: cfg}))
+	assert.Equal(t, "string\n= \"svc-prod\"", hover(8))
+
+	res, err := s.Evaluate(context.Background(), &EvaluateParams{TextDocument: &protocol.TextDocumentIdentifier{URI: u}})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"name": "svc-prod", "other": "x"}`, res.Output)
+}
+
+func TestEvaluateOutputs(t *testing.T) {
+	s := newTestServer(t, nil)
+	multi := s.open(t, "multi.jsonnet", "{'b.json': {x: [1, 2]}, 'a.json': {y: 'z'}}")
+	single := s.open(t, "single.jsonnet", "[1, 2]")
+	ctx := context.Background()
+	doc := func(u uri.URI) *protocol.TextDocumentIdentifier { return &protocol.TextDocumentIdentifier{URI: u} }
+
+	outs, err := s.ListOutputs(ctx, &EvaluateParams{TextDocument: doc(multi)})
+	require.NoError(t, err)
+	assert.Equal(t, &ListOutputsResult{Outputs: []string{"a.json", "b.json"}}, outs)
+
+	res, err := s.Evaluate(ctx, &EvaluateParams{TextDocument: doc(multi), Output: "b.json"})
+	require.NoError(t, err)
+	assert.Equal(t, "{\n   \"x\": [\n      1,\n      2\n   ]\n}\n", res.Output)
+
+	res, err = s.Evaluate(ctx, &EvaluateParams{TextDocument: doc(multi), Output: "c.json"})
+	require.NoError(t, err)
+	assert.Equal(t, "output 'c.json' not found", res.Output)
+
+	outs, err = s.ListOutputs(ctx, &EvaluateParams{TextDocument: doc(single)})
+	require.NoError(t, err)
+	assert.Empty(t, outs.Outputs)
+	assert.Contains(t, outs.Error, "must be an object")
+}
+
+func TestCompletionSelfForwardReference(t *testing.T) {
+	s := newTestServer(t, nil)
+	u := s.open(t, "main.jsonnet", "{\n  url: 'http://' + self,\n  root: $,\n  nested: {inner: self},\n  host:: 'localhost',\n}\n")
+
+	// fields defined after the cursor are available through `self` and `$`
+	assert.Equal(t, []string{"host", "nested", "root", "url"}, completionLabels(t, s, u, 2, 24, "."))
+	assert.Equal(t, []string{"host", "nested", "root", "url"}, completionLabels(t, s, u, 3, 10, "."))
+	// `self` is the innermost object
+	assert.Equal(t, []string{"inner"}, completionLabels(t, s, u, 4, 24, "."))
+}
+
+func TestCompletionShadowedVariable(t *testing.T) {
+	s := newTestServer(t, nil)
+	u := s.open(t, "main.jsonnet", "local x = 'outer';\nlocal f(y) =\n  local x = 1;\n  {\n    local y = [],\n    a: x + y,\n  };\n[f(1), x]\n")
+
+	res, err := s.Completion(context.Background(), &protocol.CompletionParams{TextDocumentPositionParams: textDocumentPosition(u, 6, 8)})
+	require.NoError(t, err)
+	details := map[string][]string{}
+	for _, item := range res.Items {
+		details[item.Label] = append(details[item.Label], item.Detail)
+	}
+	assert.Equal(t, []string{"number"}, details["x"])
+	assert.Equal(t, []string{"array"}, details["y"])
+
+	// outside of the function only the outer binding is visible
+	res, err = s.Completion(context.Background(), &protocol.CompletionParams{TextDocumentPositionParams: textDocumentPosition(u, 8, 8)})
+	require.NoError(t, err)
+	details = map[string][]string{}
+	for _, item := range res.Items {
+		details[item.Label] = append(details[item.Label], item.Detail)
+	}
+	assert.Equal(t, []string{"string"}, details["x"])
+	assert.NotContains(t, details, "y")
+}
+
+func TestRenameField(t *testing.T) {
+	s := newTestServer(t, nil)
+	u := s.open(t, "main.jsonnet", `local base = {
+  port: 80,
+  url: 'host:' + self.port,
+};
+local svc = base + {port: 8080, other: self.port};
+local unrelated = {port: 1};
+[base.port, svc.port, unrelated.port]
+`)
+	rng := func(line, begin, end int) protocol.Range {
+		return protocol.Range{Start: protocol.Position{Line: uint32(line - 1), Character: uint32(begin - 1)}, End: protocol.Position{Line: uint32(line - 1), Character: uint32(end - 1)}}
+	}
+	rename := func(line, col int) ([]protocol.Range, error) {
+		res, err := s.Rename(context.Background(), &protocol.RenameParams{TextDocumentPositionParams: textDocumentPosition(u, line, col), NewName: "listen"})
+		if err != nil {
+			return nil, err
+		}
+		ranges := []protocol.Range{}
+		for _, edit := range res.Changes[u] {
+			assert.Equal(t, "listen", edit.NewText)
+			ranges = append(ranges, edit.Range)
+		}
+		return ranges, nil
+	}
+
+	expect := []protocol.Range{rng(2, 3, 7), rng(3, 23, 27), rng(5, 21, 25), rng(5, 45, 49), rng(7, 7, 11), rng(7, 17, 21)}
+	for _, pos := range [][2]int{{2, 4}, {3, 24}, {5, 22}, {7, 8}, {7, 18}} {
+		ranges, err := rename(pos[0], pos[1])
+		require.NoError(t, err)
+		assert.ElementsMatch(t, expect, ranges, "renaming from %d:%d", pos[0], pos[1])
+	}
+
+	ranges, err := rename(7, 33)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []protocol.Range{rng(6, 20, 24), rng(7, 33, 37)}, ranges)
+
+	prepared, err := s.PrepareRename(context.Background(), &protocol.PrepareRenameParams{TextDocumentPositionParams: textDocumentPosition(u, 7, 8)})
+	require.NoError(t, err)
+	assert.Equal(t, rng(7, 7, 11), *prepared)
+
+	_, err = s.Rename(context.Background(), &protocol.RenameParams{TextDocumentPositionParams: textDocumentPosition(u, 2, 4), NewName: "not valid"})
+	assert.Error(t, err)
+
+	// references that cannot be edited, or may refer to the field through inheritance, decline
+	// the rename
+	u = s.open(t, "main.jsonnet", "local base = {port: 80};\n[base.port, base['port']]\n")
+	_, err = rename(1, 16)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "string index")
+	u = s.open(t, "main.jsonnet", "local tmpl = {a: self.port};\nlocal obj = {port: 80};\n[obj.port, tmpl]\n")
+	_, err = rename(2, 15)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "may be inherited")
+	for _, src := range []string{
+		"local o = {port: 80};\n[o.port, std.objectHas(o, 'port')]\n",
+		"local o = {port: 80};\n[o.port, 'port' in o]\n",
+		"local o = {port: 80};\nlocal f(x) = [x['port'], std.get(x, 'port')];\n[o.port, f(o)]\n",
+	} {
+		u = s.open(t, "main.jsonnet", src)
+		_, err = rename(1, 13)
+		require.Error(t, err, src)
+		assert.Contains(t, err.Error(), "string", src)
+	}
+
+	// the new name must not replace another field
+	u = s.open(t, "main.jsonnet", "local o = {port: 80, listen: 1};\nlocal p = {listen: 1} + {port: 80};\n[o.port, p.port]\n")
+	_, err = rename(1, 13)
+	assert.EqualError(t, err, "field 'listen' already exists")
+	_, err = rename(2, 27)
+	assert.EqualError(t, err, "field 'listen' already exists")
+
+	// the fields of the result of the file may be used by other files
+	u = s.open(t, "main.jsonnet", "local base = {port: 80};\nbase + {url: self.port}\n")
+	_, err = rename(1, 16)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "exported")
+}
+
+func TestLinkedEditingRange(t *testing.T) {
+	s := newTestServer(t, nil)
+	u := s.open(t, "main.jsonnet", `{
+  port: 80,
+  url: 'http://host:' + self.port,
+  root: $.port,
+  nested: {port: 1, a: self.port},
+  'quoted': self.quoted,
+}
+`)
+	rng := func(line, begin, end int) protocol.Range {
+		return protocol.Range{Start: protocol.Position{Line: uint32(line - 1), Character: uint32(begin - 1)}, End: protocol.Position{Line: uint32(line - 1), Character: uint32(end - 1)}}
+	}
+	linked := func(line, col int) []protocol.Range {
+		res, err := s.LinkedEditingRange(context.Background(), &protocol.LinkedEditingRangeParams{TextDocumentPositionParams: textDocumentPosition(u, line, col)})
+		require.NoError(t, err)
+		if res == nil {
+			return nil
+		}
+		return res.Ranges
+	}
+
+	outer := []protocol.Range{rng(2, 3, 7), rng(3, 30, 34), rng(4, 11, 15)}
+	assert.Equal(t, outer, linked(2, 4), "from the definition")
+	assert.Equal(t, outer, linked(3, 32), "from self.port")
+	assert.Equal(t, outer, linked(4, 13), "from $.port")
+
+	// self in a nested object refers to the nested object
+	assert.Equal(t, []protocol.Range{rng(5, 12, 16), rng(5, 29, 33)}, linked(5, 30))
+
+	// not on a field name, or a field name that is not an identifier
+	assert.Nil(t, linked(2, 10))
+	assert.Nil(t, linked(3, 25))
+	assert.Nil(t, linked(6, 4))
+}
+
+func TestSortFields(t *testing.T) {
+	s := newTestServer(t, nil)
+	src := `{
+  // the port
+  port: 80,
+  host:: 'localhost',
+  name: {
+    b: 1,
+    a: 2,
+  },
+  local x = 1,
+  zeta: x,
+  alpha: x
+}
+`
+	u := s.open(t, "main.jsonnet", src)
+	apply := func(edit *protocol.WorkspaceEdit) string {
+		require.Len(t, edit.Changes[u], 1)
+		te := edit.Changes[u][0]
+		lines := strings.Split(src, "\n")
+		out := append([]string{}, lines[:te.Range.Start.Line]...)
+		out = append(out, te.NewText)
+		out = append(out, lines[te.Range.End.Line+1:]...)
+		return strings.Join(out, "\n")
+	}
+
+	edit, err := s.SortFields(context.Background(), &SortFieldsParams{TextDocument: &protocol.TextDocumentIdentifier{URI: u}, Position: protocol.Position{Line: 2, Character: 2}})
+	require.NoError(t, err)
+	assert.Equal(t, `{
+  host:: 'localhost',
+  name: {
+    b: 1,
+    a: 2,
+  },
+  // the port
+  port: 80,
+  local x = 1,
+  alpha: x,
+  zeta: x,
+}
+`, apply(edit))
+
+	edit, err = s.SortFields(context.Background(), &SortFieldsParams{TextDocument: &protocol.TextDocumentIdentifier{URI: u}, Position: protocol.Position{Line: 2, Character: 2}, GroupHidden: true})
+	require.NoError(t, err)
+	assert.Equal(t, `{
+  name: {
+    b: 1,
+    a: 2,
+  },
+  // the port
+  port: 80,
+  host:: 'localhost',
+  local x = 1,
+  alpha: x,
+  zeta: x,
+}
+`, apply(edit))
+
+	// the nested object under the cursor
+	actions, err := s.CodeAction(context.Background(), &protocol.CodeActionParams{TextDocument: protocol.TextDocumentIdentifier{URI: u}, Range: protocol.Range{Start: protocol.Position{Line: 5, Character: 4}}})
+	require.NoError(t, err)
+	require.Len(t, actions, 1)
+	assert.Contains(t, apply(actions[0].Edit), "  name: {\n    a: 2,\n    b: 1,\n  },\n")
+
+	// the trailing comma is added after the value when the line has multibyte characters
+	src = "{\n  b: 'ünïcödé' + 'é',\n  a: 'é'\n}\n"
+	u = s.open(t, "multibyte.jsonnet", src)
+	edit, err = s.SortFields(context.Background(), &SortFieldsParams{TextDocument: &protocol.TextDocumentIdentifier{URI: u}, Position: protocol.Position{Line: 1, Character: 2}})
+	require.NoError(t, err)
+	assert.Equal(t, "{\n  a: 'é',\n  b: 'ünïcödé' + 'é',\n}\n", apply(edit))
+
+	// fields sharing a line cannot be sorted
+	single := s.open(t, "single.jsonnet", "{b: 1, a: 2}")
+	_, err = s.SortFields(context.Background(), &SortFieldsParams{TextDocument: &protocol.TextDocumentIdentifier{URI: single}})
+	assert.Error(t, err)
+}
+
+func TestCodeActionDeprecatedQuickFix(t *testing.T) {
+	// no deprecated stdlib function has a replacement yet
+	deprecated := linter.DeprecatedStdlib
+	linter.DeprecatedStdlib = map[string]linter.Deprecation{
+		"asciiLower":   {Suggestion: "use `std.asciiUpper`", Replacement: "asciiUpper"},
+		"base64Decode": deprecated["base64Decode"],
+	}
+	defer func() { linter.DeprecatedStdlib = deprecated }()
+
+	s := newTestServer(t, nil)
+	u := s.open(t, "main.jsonnet", "local s = std;\n[std.asciiLower('a'), s.asciiLower('b'), std.base64Decode('')]\n")
+	resolver := s.NewResolver(u)
+	diags := linter.LintAST(resolver.Root(), resolver)
+	require.Len(t, diags, 3)
+
+	// the diagnostics are sent through the client, which decodes the data as json
+	data, err := json.Marshal(diags)
+	require.NoError(t, err)
+	diags = nil
+	require.NoError(t, json.Unmarshal(data, &diags))
+
+	actions, err := s.CodeAction(context.Background(), &protocol.CodeActionParams{
+		TextDocument: protocol.TextDocumentIdentifier{URI: u},
+		Context:      protocol.CodeActionContext{Diagnostics: diags},
+	})
+	require.NoError(t, err)
+	// base64Decode cannot be replaced mechanically
+	require.Len(t, actions, 2)
+	for _, action := range actions {
+		assert.Equal(t, protocol.QuickFix, action.Kind)
+		assert.Equal(t, "Replace with 'std.asciiUpper'", action.Title)
+	}
+	assert.Equal(t, []protocol.TextEdit{{
+		Range:   protocol.Range{Start: protocol.Position{Line: 1, Character: 5}, End: protocol.Position{Line: 1, Character: 15}},
+		NewText: "asciiUpper",
+	}}, actions[0].Edit.Changes[u])
+	assert.Equal(t, []protocol.TextEdit{{
+		Range:   protocol.Range{Start: protocol.Position{Line: 1, Character: 24}, End: protocol.Position{Line: 1, Character: 34}},
+		NewText: "asciiUpper",
+	}}, actions[1].Edit.Changes[u])
+}
+
+func TestCompletionStdPrefix(t *testing.T) {
+	s := newTestServer(t, nil)
+	u := s.open(t, "main.jsonnet", "local length = 1;\nlength\n")
+
+	items := func() map[string]protocol.CompletionItem {
+		res, err := s.Completion(context.Background(), &protocol.CompletionParams{TextDocumentPositionParams: textDocumentPosition(u, 2, 1)})
+		require.NoError(t, err)
+		byLabel := map[string]protocol.CompletionItem{}
+		for _, item := range res.Items {
+			byLabel[item.Label] = item
+		}
+		return byLabel
+	}
+
+	assert.NotContains(t, items(), "map")
+
+	s.config.SuggestStdPrefix = true
+	got := items()
+	require.Contains(t, got, "map")
+	assert.Equal(t, "std.map", got["map"].InsertText)
+	assert.Equal(t, "std.map(func: function, arr: array) -> array", got["map"].Detail)
+	// shadowed by the local variable
+	assert.Equal(t, "length", got["length"].InsertText)
+	assert.Equal(t, protocol.CompletionItemKindVariable, got["length"].Kind)
+}
+
+func TestCompletionFunctionCalls(t *testing.T) {
+	s := newTestServer(t, nil)
+	s.config.CompleteFunctionCalls = true
+	u := s.open(t, "main.jsonnet", "local make(name, port=80) = {};\nlocal lib = {fmt(a, b):: a};\nlocal n = 1;\n[std, lib, n]\n")
+
+	items := func(line, col int, trigger string) map[string]protocol.CompletionItem {
+		params := &protocol.CompletionParams{TextDocumentPositionParams: textDocumentPosition(u, line, col)}
+		if trigger != "" {
+			params.Context = &protocol.CompletionContext{TriggerCharacter: trigger}
+		}
+		res, err := s.Completion(context.Background(), params)
+		require.NoError(t, err)
+		byLabel := map[string]protocol.CompletionItem{}
+		for _, item := range res.Items {
+			byLabel[item.Label] = item
+		}
+		return byLabel
+	}
+
+	vars := items(4, 2, "")
+	assert.Equal(t, "make(${1:name})$0", vars["make"].InsertText)
+	assert.Equal(t, protocol.InsertTextFormatSnippet, vars["make"].InsertTextFormat)
+	assert.Equal(t, "n", vars["n"].InsertText)
+
+	std := items(4, 5, ".")
+	assert.Equal(t, "map(${1:func}, ${2:arr})$0", std["map"].InsertText)
+	assert.Equal(t, "get(${1:o}, ${2:f})$0", std["get"].InsertText)
+
+	fields := items(4, 10, ".")
+	assert.Equal(t, "fmt(${1:a}, ${2:b})$0", fields["fmt"].InsertText)
+
+	// completions are not changed when disabled
+	s.config.CompleteFunctionCalls = false
+	assert.Equal(t, "make", items(4, 2, "")["make"].InsertText)
+	assert.Equal(t, "", items(4, 5, ".")["map"].InsertText)
+}
+
+func TestCompletionObjectAsserts(t *testing.T) {
+	s := newTestServer(t, nil)
+	u := s.open(t, "main.jsonnet", "local min = 1;\nlocal obj = {\n  assert self.port >= min,\n  port: 80,\n  assert self.name != '',\n  name: 'a',\n};\nobj\n")
+	assert.Equal(t, []string{"name", "port"}, completionLabels(t, s, u, 8, 4, "."))
+}
+
+func TestCompletionHiddenTemplateFields(t *testing.T) {
+	s := newTestServer(t, nil)
+	u := s.open(t, "main.jsonnet", "local tmpl = {name: 'a', config:: {}, port: 80, debug:: false};\ntmpl + {port: 1, }\n")
+
+	val := analysis.NodeToValue(s.getCurrentAST(u), s.NewResolver(u))
+	require.NotNil(t, val.Object)
+	hidden := map[string]bool{}
+	for _, fld := range val.Object.Fields {
+		hidden[fld.Name] = fld.Hidden
+	}
+	assert.Equal(t, map[string]bool{"name": false, "config": true, "port": false, "debug": true}, hidden)
+
+	res, err := s.Completion(context.Background(), &protocol.CompletionParams{TextDocumentPositionParams: textDocumentPosition(u, 2, 17)})
+	require.NoError(t, err)
+	inserts := map[string]string{}
+	for _, item := range res.Items {
+		inserts[item.Label] = item.InsertText
+	}
+	assert.Equal(t, map[string]string{
+		"name":   "name: $1,$0",
+		"config": "config:: $1,$0",
+		"debug":  "debug:: $1,$0",
+	}, inserts)
+}
+
+func TestCompletionImportIgnored(t *testing.T) {
+	s := newTestServer(t, map[string]string{
+		".gitignore":                "# build output\n/out/\n*.gen.libsonnet\n!keep.gen.libsonnet\n",
+		"lib/a.libsonnet":           "{}",
+		"lib/out/b.libsonnet":       "{}",
+		"out/c.libsonnet":           "{}",
+		"node_modules/d.libsonnet":  "{}",
+		"vendor/e.libsonnet":        "{}",
+		"skip.gen.libsonnet":        "{}",
+		"keep.gen.libsonnet":        "{}",
+		"lib/nested.gen.libsonnet":  "{}",
+		"lib/nested/keep.libsonnet": "{}",
+		"jsonnet/out/f.libsonnet":   "{}",
+	})
+	s.config.ExcludeGlobs = append(s.config.ExcludeGlobs, "vendor")
+
+	u := s.open(t, "main.jsonnet", "import 'x'\n")
+	assert.Equal(t, []string{"jsonnet", "keep.gen.libsonnet", "lib"}, completionLabels(t, s, u, 1, 9, "/"))
+
+	// anchored patterns only match from the workspace root, also in jpaths
+	u = s.open(t, "main.jsonnet", "import 'lib/x'\n")
+	assert.Equal(t, []string{"a.libsonnet", "nested", "out"}, completionLabels(t, s, u, 1, 13, "/"))
+	s.importer.SetJPaths([]string{"jsonnet"})
+	u = s.open(t, "main.jsonnet", "import 'x'\n")
+	assert.Equal(t, []string{"jsonnet", "keep.gen.libsonnet", "lib", "out"}, completionLabels(t, s, u, 1, 9, "/"))
+
+	// the .gitignore is read again when it changes
+	require.NoError(t, os.WriteFile(filepath.Join(s.rootURI.Filename(), ".gitignore"), []byte("lib/\n"), 0o644))
+	assert.Equal(t, []string{"jsonnet", "keep.gen.libsonnet", "lib", "out"}, completionLabels(t, s, u, 1, 9, "/"))
+	require.NoError(t, s.DidChangeWatchedFiles(context.Background(), &protocol.DidChangeWatchedFilesParams{
+		Changes: []*protocol.FileEvent{{URI: uri.File(filepath.Join(s.rootURI.Filename(), ".gitignore")), Type: protocol.FileChangeTypeChanged}},
+	}))
+	assert.Equal(t, []string{"jsonnet", "keep.gen.libsonnet", "out", "skip.gen.libsonnet"}, completionLabels(t, s, u, 1, 9, "/"))
+}
+
+func TestCompletionImportJPathSubdirectory(t *testing.T) {
+	s := newTestServer(t, map[string]string{
+		"lib/a.libsonnet":             "{}",
+		"jsonnet/lib/b.libsonnet":     "{}",
+		"jsonnet/lib/k8s/c.libsonnet": "{}",
+		"third_party/lib/k8s/d.json":  "{}",
+	})
+	s.importer.SetJPaths([]string{"jsonnet", "third_party"})
+
+	u := s.open(t, "main.jsonnet", "import 'lib/x'\n")
+	assert.Equal(t, []string{"a.libsonnet", "b.libsonnet", "k8s"}, completionLabels(t, s, u, 1, 13, "/"))
+
+	// a directory that only exists in the jpaths is listed from all of them
+	u = s.open(t, "main.jsonnet", "import 'lib/k8s/x'\n")
+	assert.Equal(t, []string{"c.libsonnet", "d.json"}, completionLabels(t, s, u, 1, 17, "/"))
+	u = s.open(t, "main.jsonnet", "import 'lib/k8s'\n")
+	assert.Equal(t, []string{"c.libsonnet", "d.json"}, completionLabels(t, s, u, 1, 15, "/"))
+}
+
+func TestCompletionSlashTrigger(t *testing.T) {
+	s := newTestServer(t, map[string]string{
+		"lib/a.libsonnet": "{}",
+		"lib/b.txt":       "b",
+	})
+
+	// all import kinds complete paths
+	u := s.open(t, "main.jsonnet", "importstr 'lib/x'\n")
+	assert.Equal(t, []string{"a.libsonnet", "b.txt"}, completionLabels(t, s, u, 1, 16, "/"))
+	u = s.open(t, "main.jsonnet", "importbin 'lib/x'\n")
+	assert.Equal(t, []string{"a.libsonnet", "b.txt"}, completionLabels(t, s, u, 1, 16, "/"))
+
+	// division does not complete anything
+	u = s.open(t, "main.jsonnet", "local x = 4 / 2;\nx\n")
+	assert.Empty(t, completionLabels(t, s, u, 1, 13, "/"))
+
+	s.config.SlashCompletion = false
+	u = s.open(t, "main.jsonnet", "import 'lib/x'\n")
+	assert.Empty(t, completionLabels(t, s, u, 1, 13, "/"))
+}
+
+func TestCompletionImportstrOrder(t *testing.T) {
+	s := newTestServer(t, map[string]string{
+		"lib/a.libsonnet":   "{}",
+		"lib/b.yaml":        "b: 1",
+		"lib/c.jsonnet":     "{}",
+		"lib/d/e.sql":       "select 1",
+		"lib/query.sql":     "select 2",
+		"lib/z.txt":         "z",
+		"lib/nested/x.json": "{}",
+	})
+	sorted := func(u uri.URI) []string {
+		res, err := s.Completion(context.Background(), &protocol.CompletionParams{
+			TextDocumentPositionParams: textDocumentPosition(u, 1, 16),
+			Context:                    &protocol.CompletionContext{TriggerCharacter: "/"},
+		})
+		require.NoError(t, err)
+		sort.SliceStable(res.Items, func(i, j int) bool { return res.Items[i].SortText < res.Items[j].SortText })
+		labels := []string{}
+		for _, item := range res.Items {
+			labels = append(labels, item.Label)
+		}
+		return labels
+	}
+
+	// data files first, then directories, then jsonnet files
+	u := s.open(t, "main.jsonnet", "importstr 'lib/x'\n")
+	assert.Equal(t, []string{"b.yaml", "query.sql", "z.txt", "d", "nested", "a.libsonnet", "c.jsonnet"}, sorted(u))
+
+	// import keeps the directory order
+	u = s.open(t, "main.jsonnet", "import 'lib/xxxx'\n")
+	assert.Equal(t, []string{"a.libsonnet", "b.yaml", "c.jsonnet", "d", "nested", "query.sql", "z.txt"}, sorted(u))
+}
+
+func TestCompletionConditionalObject(t *testing.T) {
+	s := newTestServer(t, nil)
+	u := s.open(t, "main.jsonnet", "local cfg(prod) = if prod then {a: 1, c: 'x'} else {b: 2, c: 'y'};\nlocal obj = cfg(true);\nobj\n")
+	res, err := s.Completion(context.Background(), &protocol.CompletionParams{
+		TextDocumentPositionParams: textDocumentPosition(u, 3, 4),
+		Context:                    &protocol.CompletionContext{TriggerCharacter: "."},
+	})
+	require.NoError(t, err)
+	details := map[string]string{}
+	for _, item := range res.Items {
+		details[item.Label] = item.Detail
+	}
+	assert.Equal(t, map[string]string{
+		"a": "number(1) (in some branches)",
+		"b": "number(2) (in some branches)",
+		"c": "string",
+	}, details)
+}
+
+func TestCompletionObjectFunctions(t *testing.T) {
+	s := newTestServer(t, nil)
+	u := s.open(t, "main.jsonnet", "local base = {name: 'svc', port: 80, debug:: false};\nlocal pruned = std.prune(base);\nlocal patched = std.mergePatch(base, {port: null, tls: true});\nlocal mapped = std.mapWithKey(function(k, v) std.toString(v), base);\n[pruned, patched, mapped]\n")
+
+	assert.Equal(t, []string{"name", "port"}, completionLabels(t, s, u, 5, 8, "."))
+	assert.Equal(t, []string{"name", "tls"}, completionLabels(t, s, u, 5, 17, "."))
+	assert.Equal(t, []string{"name", "port"}, completionLabels(t, s, u, 5, 25, "."))
+
+	// elements of the arrays of field values
+	u = s.open(t, "values.jsonnet", "local servers = {a: {host: 'x'}, b: {host: 'y', port: 2}};\nlocal first = std.objectValues(servers)[0];\nlocal kv = std.objectKeysValues(servers)[0];\n[first, kv, kv.value]\n")
+	assert.Equal(t, []string{"host", "port"}, completionLabels(t, s, u, 4, 7, "."))
+	assert.Equal(t, []string{"key", "value"}, completionLabels(t, s, u, 4, 11, "."))
+	assert.Equal(t, []string{"host", "port"}, completionLabels(t, s, u, 4, 21, "."))
+}
+
+// rankedLabels returns the labels of the completion items in the order of their sort text
+func rankedLabels(t *testing.T, s *Server, u uri.URI, line, col int, trigger string) []string {
+	params := &protocol.CompletionParams{
+		TextDocumentPositionParams: textDocumentPosition(u, line, col),
+	}
+	if trigger != "" {
+		params.Context = &protocol.CompletionContext{TriggerCharacter: trigger}
+	}
+	res, err := s.Completion(context.Background(), params)
+	require.NoError(t, err)
+	sort.SliceStable(res.Items, func(i, j int) bool { return res.Items[i].SortText < res.Items[j].SortText })
+	labels := []string{}
+	for _, item := range res.Items {
+		labels = append(labels, item.Label)
+	}
+	return labels
+}
+
+func TestCompletionRanking(t *testing.T) {
+	s := newTestServer(t, nil)
+	u := s.open(t, "main.jsonnet", "local lib = {num: 1, str: 'x', hid:: 'y'};\nlocal f(s/*:string*/) = s;\nlocal n = 1;\nlocal t = 'z';\n[f(lib), f(t), std]\n")
+
+	// fields of the expected type first, hidden fields last
+	assert.Equal(t, []string{"str", "num", "hid"}, rankedLabels(t, s, u, 5, 6, "."))
+	// variables of the expected type first
+	assert.Equal(t, "t", rankedLabels(t, s, u, 5, 12, "")[0])
+	// deprecated stdlib functions last
+	std := rankedLabels(t, s, u, 5, 19, ".")
+	assert.Equal(t, "base64Decode", std[len(std)-1])
+
+	// the elements of the array joined with a string separator are strings
+	join := s.open(t, "join.jsonnet", "local n = 1;\nlocal t = 'z';\nstd.join(',', [n, ])\n")
+	assert.Equal(t, "t", rankedLabels(t, s, join, 3, 19, "")[0])
+
+	// the elements of an array passed to a typed array parameter
+	typed := s.open(t, "typed.jsonnet", "local n = 1;\nlocal t = 'z';\nlocal hosts(names/*:array[string]*/) = names;\nhosts([n, ])\n")
+	assert.Equal(t, []string{"t", `""`}, rankedLabels(t, s, typed, 4, 11, "")[:2])
+	s.config.Fmt.StringStyle = "'"
+	assert.Equal(t, []string{"t", "''"}, rankedLabels(t, s, typed, 4, 11, "")[:2])
+	// no literal for an argument that is not an array literal
+	assert.NotContains(t, rankedLabels(t, s, u, 5, 12, ""), "''")
+}
+
+func TestCompletionInSuper(t *testing.T) {
+	s := newTestServer(t, nil)
+	u := s.open(t, "main.jsonnet", "local base = {name: 'x', port:: 80};\nbase + {hasPort: 'p' in super}\n")
+	assert.Equal(t, []string{"name", "port"}, completionLabels(t, s, u, 2, 20, ""))
+}
+
+func TestCompletionExtVar(t *testing.T) {
+	s := newTestServer(t, nil)
+	s.config.ExtVars = map[string]string{"env": "prod", "region": "eu"}
+	u := s.open(t, "main.jsonnet", "local other = 'x';\n[std.extVar('e'), std.extVar(x='r'), std.length('o')]\n")
+
+	assert.Equal(t, []string{"env", "region"}, completionLabels(t, s, u, 2, 15, ""))
+	assert.Equal(t, []string{"env", "region"}, completionLabels(t, s, u, 2, 33, ""))
+	// other string arguments are not extVar names
+	assert.NotContains(t, completionLabels(t, s, u, 2, 50, ""), "env")
+}
+
+func TestCompletionAssertMessage(t *testing.T) {
+	s := newTestServer(t, nil)
+	u := s.open(t, "main.jsonnet", "local cfg = {name: 'svc', port: 80};\nassert cfg.port > 0 : 'bad port for ' + cfg.name;\n{\n  local min = 1,\n  port: cfg.port,\n  assert self.port > min : 'port below ' + min + ' in ' + self.port,\n}\n")
+
+	// variables and fields in the message of a top level assert
+	assert.Equal(t, []string{"cfg", "std"}, completionLabels(t, s, u, 2, 44, ""))
+	assert.Equal(t, []string{"name", "port"}, completionLabels(t, s, u, 2, 45, "."))
+	// object locals and fields of `self` in the message of an object assert
+	assert.Equal(t, []string{"$", "cfg", "min", "self", "std"}, completionLabels(t, s, u, 6, 45, ""))
+	assert.Equal(t, []string{"port"}, completionLabels(t, s, u, 6, 63, "."))
+}
+
+func TestCompletionSelfSuper(t *testing.T) {
+	s := newTestServer(t, nil)
+	u := s.open(t, "main.jsonnet", "local base = {a: 1};\nbase + {\n  local x = 1,\n  b: x,\n  c: self.b,\n}\n")
+	assert.Equal(t, []string{"$", "base", "self", "std", "super", "x"}, completionLabels(t, s, u, 4, 6, ""))
+
+	s.config.CompleteSelfSuper = false
+	assert.Equal(t, []string{"base", "std", "x"}, completionLabels(t, s, u, 4, 6, ""))
+	// still resolved for dot completion
+	assert.Equal(t, []string{"b", "c"}, completionLabels(t, s, u, 5, 10, "."))
+}
+
+func TestCompletionImportDetail(t *testing.T) {
+	s := newTestServer(t, map[string]string{
+		"lib/util.libsonnet": "{ name: 'util' }",
+		"lib/fn.libsonnet":   "function(x) x",
+		"lib/data.txt":       "data",
+	})
+	u := s.open(t, "main.jsonnet", "local util = import 'lib/util.libsonnet';\nlocal fn = import 'lib/fn.libsonnet';\nlocal data = importstr 'lib/data.txt';\nlocal libs = {util: import 'lib/util.libsonnet', obj: {}};\n[util, fn, data, libs]\n")
+
+	complete := func(line, col int, trigger string) map[string]string {
+		res, err := s.Completion(context.Background(), &protocol.CompletionParams{
+			TextDocumentPositionParams: textDocumentPosition(u, line, col),
+			Context:                    &protocol.CompletionContext{TriggerCharacter: trigger},
+		})
+		require.NoError(t, err)
+		details := map[string]string{}
+		for _, item := range res.Items {
+			details[item.Label] = item.Detail
+		}
+		return details
+	}
+
+	vars := complete(5, 2, "")
+	assert.Equal(t, `module("lib/util.libsonnet")`, vars["util"])
+	assert.Equal(t, "function", vars["fn"])
+	assert.Equal(t, "string", vars["data"])
+	assert.Equal(t, "object", vars["libs"])
+	assert.Equal(t, map[string]string{"util": `module("lib/util.libsonnet")`, "obj": "object"}, complete(5, 22, "."))
+}
+
+func TestCompletionFunctionReturn(t *testing.T) {
+	s := newTestServer(t, map[string]string{
+		"lib.libsonnet": "{\n  make(name):: local base = {name: name};\n    base + {kind:: 'lib'},\n}\n",
+	})
+	for name, contents := range map[string]string{
+		"literal":   "local makeConfig() = {foo: 1, bar:: 2};\nlocal obj = makeConfig();\nobj\n",
+		"locals":    "local makeConfig(x) =\n  local y = x;\n  local res = {foo: y, bar:: 2};\n  res;\nlocal obj = makeConfig(1);\nobj\n",
+		"field":     "local lib = {makeConfig():: {foo: 1, bar:: 2}};\nlocal obj = lib.makeConfig();\nobj\n",
+		"anonymous": "local makeConfig = function(x) {foo: x, bar:: 2};\nlocal obj = makeConfig(1);\nobj\n",
+		"asserts":   "local makeConfig(x) =\n  assert x > 0;\n  local y = x;\n  assert y > 0 : 'msg';\n  {foo: y, bar:: 2};\nlocal obj = makeConfig(1);\nobj\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			u := s.open(t, "main.jsonnet", contents)
+			line := strings.Count(contents, "\n")
+			assert.Equal(t, []string{"bar", "foo"}, completionLabels(t, s, u, line, 4, "."))
+		})
+	}
+	t.Run("imported", func(t *testing.T) {
+		u := s.open(t, "main.jsonnet", "local lib = import 'lib.libsonnet';\nlocal obj = lib.make('a');\nobj\n")
+		assert.Equal(t, []string{"kind", "name"}, completionLabels(t, s, u, 3, 4, "."))
+	})
+}
+
+func TestHoverImport(t *testing.T) {
+	s := newTestServer(t, map[string]string{
+		"lib/util.libsonnet": "{a: 1}",
+		"lib/data.txt":       "text",
+	})
+	u := s.open(t, "main.jsonnet", "[\n  import 'lib/util.libsonnet',\n  importstr 'lib/data.txt',\n  import 'missing.libsonnet',\n]\n")
+
+	hover := func(line int) string {
+		res, err := s.Hover(context.Background(), &protocol.HoverParams{TextDocumentPositionParams: textDocumentPosition(u, line, 5)})
+		require.NoError(t, err)
+		return res.Contents.Value
+	}
+	root := s.rootURI.Filename()
+	assert.Equal(t, "object\nresolved to "+filepath.Join(root, "lib/util.libsonnet"), hover(2))
+	assert.Equal(t, "string\nresolved to "+filepath.Join(root, "lib/data.txt"), hover(3))
+	assert.Equal(t, "any\nimport not found: 'missing.libsonnet'", hover(4))
+}
+
+func TestEvaluationGated(t *testing.T) {
+	warning := []protocol.Diagnostic{{Severity: protocol.DiagnosticSeverityWarning}}
+	errs := []protocol.Diagnostic{{Severity: protocol.DiagnosticSeverityInformation}, {Severity: protocol.DiagnosticSeverityError}}
+
+	for gate, expect := range map[string][]bool{
+		EvaluateGateErrors:   {false, false, true},
+		EvaluateGateWarnings: {false, true, true},
+		EvaluateGateNone:     {false, false, false},
+		// unset configurations keep the original behaviour
+		"": {false, false, true},
+	} {
+		cfg := &DiagConfiguration{EvaluateGate: gate}
+		assert.Equal(t, expect, []bool{cfg.evaluationGated(nil), cfg.evaluationGated(warning), cfg.evaluationGated(errs)}, "gate %q", gate)
+	}
+}
+
+func TestCompletionSuper(t *testing.T) {
+	s := newTestServer(t, nil)
+	u := s.open(t, "main.jsonnet", "local base = {x: 1, y:: {z: 2}};\nbase + {\n  x: super.x + 1,\n  w: super.y.z,\n  nested: {v: super.x},\n}\n")
+
+	assert.Equal(t, []string{"x", "y"}, completionLabels(t, s, u, 3, 11, "."))
+	assert.Equal(t, []string{"x", "y"}, completionLabels(t, s, u, 4, 11, "."))
+	// objects that are not merged have no known `super`
+	assert.Empty(t, completionLabels(t, s, u, 5, 20, "."))
+
+	res, err := s.Hover(context.Background(), &protocol.HoverParams{TextDocumentPositionParams: textDocumentPosition(u, 3, 8)})
+	require.NoError(t, err)
+	assert.Equal(t, "number\n1", res.Contents.Value)
+}
+
+func TestFormatSubtree(t *testing.T) {
+	s := newTestServer(t, nil)
+	src := "local base = {a: 1};\n{\n  keep:    'untouched',\n  nested: base + {\n      x:   1,\n        \"y\": [1,2],\n   z: super.a\n  },\n  list: [ 1,2 ],\n}\n"
+	u := s.open(t, "main.jsonnet", src)
+	apply := func(pos protocol.Position) string {
+		edit, err := s.FormatSubtree(context.Background(), &FormatSubtreeParams{TextDocument: &protocol.TextDocumentIdentifier{URI: u}, Position: pos})
+		require.NoError(t, err)
+		require.Len(t, edit.Changes[u], 1)
+		te := edit.Changes[u][0]
+		lines := strings.Split(src, "\n")
+		out := append([]string{}, lines[:te.Range.Start.Line]...)
+		out = append(out, lines[te.Range.Start.Line][:te.Range.Start.Character]+te.NewText+lines[te.Range.End.Line][te.Range.End.Character:])
+		out = append(out, lines[te.Range.End.Line+1:]...)
+		return strings.Join(out, "\n")
+	}
+
+	assert.Equal(t, "local base = {a: 1};\n{\n  keep:    'untouched',\n  nested: base + {\n    x: 1,\n    y: [1, 2],\n    z: super.a,\n  },\n  list: [ 1,2 ],\n}\n", apply(protocol.Position{Line: 5, Character: 9}))
+	assert.Equal(t, "local base = {a: 1};\n{\n  keep:    'untouched',\n  nested: base + {\n      x:   1,\n        \"y\": [1,2],\n   z: super.a\n  },\n  list: [1, 2],\n}\n", apply(protocol.Position{Line: 8, Character: 10}))
+}
+
+func TestCompletionMergedObjects(t *testing.T) {
+	s := newTestServer(t, map[string]string{
+		"base.libsonnet": "{base: 1, shared: 'base'}",
+	})
+	u := s.open(t, "main.jsonnet", "local base = import 'base.libsonnet';\nlocal override = {shared: 'override', extra:: true};\nlocal obj = base + override + {last: {inner: 1}} {implicit: 2};\n[obj, obj.shared]\n")
+	assert.Equal(t, []string{"base", "extra", "implicit", "last", "shared"}, completionLabels(t, s, u, 4, 5, "."))
+
+	// fields are listed in source order, with overridden fields moved to the overriding operand
+	res, err := s.Completion(context.Background(), &protocol.CompletionParams{
+		TextDocumentPositionParams: textDocumentPosition(u, 4, 5),
+		Context:                    &protocol.CompletionContext{TriggerCharacter: "."},
+	})
+	require.NoError(t, err)
+	labels := []string{}
+	for _, item := range res.Items {
+		labels = append(labels, item.Label)
+	}
+	assert.Equal(t, []string{"base", "shared", "extra", "last", "implicit"}, labels)
+
+	// the rightmost operand wins for fields defined more than once
+	hover, err := s.Hover(context.Background(), &protocol.HoverParams{TextDocumentPositionParams: textDocumentPosition(u, 4, 12)})
+	require.NoError(t, err)
+	assert.Equal(t, "string\noverride", hover.Contents.Value)
+}
+
+func TestCompletionErrorMessage(t *testing.T) {
+	s := newTestServer(t, nil)
+	u := s.open(t, "main.jsonnet", "local name = 'svc';\nlocal check(x) = if x > 0 then x else error 'bad ' + x + name;\n{\n  local field = 'a',\n  assert name != '' : 'empty ' + field,\n  a: check(1),\n}\n")
+
+	labels := func(line, col int) []string {
+		res := []string{}
+		for _, l := range completionLabels(t, s, u, line, col, "") {
+			if l == "name" || l == "x" || l == "field" || l == "check" {
+				res = append(res, l)
+			}
+		}
+		return res
+	}
+	assert.Equal(t, []string{"check", "name", "x"}, labels(2, 59))
+	assert.Equal(t, []string{"check", "field", "name"}, labels(5, 36))
+}
+
+func TestDocumentSymbolFunctions(t *testing.T) {
+	s := newTestServer(t, nil)
+	u := s.open(t, "main.jsonnet", "local port = 80;\nlocal join(arr, sep/*:string*/=',') = std.join(sep, arr);\nlocal double = function(x/*:number*/) x * 2;\n{}\n")
+
+	res, err := s.DocumentSymbol(context.Background(), &protocol.DocumentSymbolParams{TextDocument: protocol.TextDocumentIdentifier{URI: u}})
+	require.NoError(t, err)
+	got := map[string]protocol.DocumentSymbol{}
+	for _, sym := range res {
+		got[sym.(protocol.DocumentSymbol).Name] = sym.(protocol.DocumentSymbol)
+	}
+	assert.Equal(t, protocol.SymbolKindVariable, got["port"].Kind)
+	assert.Equal(t, "number", got["port"].Detail)
+	assert.Equal(t, protocol.SymbolKindFunction, got["join"].Kind)
+	// the rendering of the default of `sep` is not asserted, only the parameters
+	assert.True(t, strings.HasPrefix(got["join"].Detail, "function(arr, sep: string"), got["join"].Detail)
+	assert.Equal(t, protocol.SymbolKindFunction, got["double"].Kind)
+	assert.Equal(t, "function(x: number)", got["double"].Detail)
+}
+
+func TestDocumentSymbolCache(t *testing.T) {
+	s := newTestServer(t, nil)
+	u := s.open(t, "main.jsonnet", "local a = 1;\n{}\n")
+	params := &protocol.DocumentSymbolParams{TextDocument: protocol.TextDocumentIdentifier{URI: u}}
+	names := func() []string {
+		res, err := s.DocumentSymbol(context.Background(), params)
+		require.NoError(t, err)
+		names := []string{}
+		for _, sym := range res {
+			names = append(names, sym.(protocol.DocumentSymbol).Name)
+		}
+		return names
+	}
+
+	assert.Equal(t, []string{"a", "std"}, names())
+	// the same version is served from the cache
+	s.cacheDocumentSymbols(u, 1, []interface{}{protocol.DocumentSymbol{Name: "cached"}})
+	assert.Equal(t, []string{"cached"}, names())
+
+	// a new version is computed again
+	s.overlay.ReplaceSync(u, 2, "local a = 1;\nlocal b = 2;\n{}\n", parseJsonnetFn(u))
+	assert.Equal(t, []string{"a", "b", "std"}, names())
+
+	s.invalidateDocumentSymbols(u)
+	assert.Nil(t, s.docSymbols[u])
+}
+
+func TestHoverManifestPreview(t *testing.T) {
+	s := newTestServer(t, nil)
+	u := s.open(t, "main.jsonnet", "local port = 8080 + 1;\nlocal cfg = {name: 'svc', port: port, tags: ['a'], hidden:: true};\nlocal dyn = {name: std.extVar('x')};\n[std.manifestYamlDoc(cfg), std.manifestIni({sections: {}}), std.manifestJson(dyn)]\n")
+	hover := func(col int) string {
+		res, err := s.Hover(context.Background(), &protocol.HoverParams{TextDocumentPositionParams: textDocumentPosition(u, 4, col)})
+		require.NoError(t, err)
+		return res.Contents.Value
+	}
+
+	assert.NotContains(t, hover(8), "manifested")
+
+	s.config.ManifestPreview = true
+	assert.True(t, strings.HasSuffix(hover(8), "\n\nmanifested:\n\"name\": \"svc\"\n\"port\": 8081\n\"tags\":\n- \"a\""), hover(8))
+	// only the function name shows a preview
+	assert.NotContains(t, hover(23), "manifested")
+	// arguments must be constant
+	assert.NotContains(t, hover(67), "manifested")
+
+	// the defaults of parameters are not their values
+	u = s.open(t, "param.jsonnet", "local f(cfg={a: 1}, n=1) = [std.manifestJson(cfg), std.manifestJson({n: n + 1})];\nf({b: 2}, 2)\n")
+	for _, col := range []int{33, 55} {
+		res, err := s.Hover(context.Background(), &protocol.HoverParams{TextDocumentPositionParams: textDocumentPosition(u, 1, col)})
+		require.NoError(t, err)
+		assert.NotContains(t, res.Contents.Value, "manifested")
+	}
+}
+
+func TestHoverExplainDiagnostic(t *testing.T) {
+	s := newTestServer(t, nil)
+	u := s.open(t, "main.jsonnet", "local unused = 1;\n{a: 1}\n")
+	s.setPublishedDiagnostics(u, []protocol.Diagnostic{{
+		Range:   protocol.Range{Start: protocol.Position{Line: 0, Character: 6}, End: protocol.Position{Line: 0, Character: 12}},
+		Code:    linter.UnusedVar,
+		Message: "unused variable 'unused'",
+	}})
+	hover := func(line, col int) string {
+		res, err := s.Hover(context.Background(), &protocol.HoverParams{TextDocumentPositionParams: textDocumentPosition(u, line, col)})
+		require.NoError(t, err)
+		return res.Contents.Value
+	}
+
+	assert.Contains(t, hover(1, 8), "\n\nUnusedVar: unused variable 'unused'\n"+linter.Explanations[linter.UnusedVar])
+	assert.NotContains(t, hover(2, 2), "UnusedVar")
+
+	// closing the file forgets its diagnostics
+	require.NoError(t, s.DidClose(context.Background(), &protocol.DidCloseTextDocumentParams{TextDocument: protocol.TextDocumentIdentifier{URI: u}}))
+	assert.Empty(t, s.published)
+}
+
+func TestSignatureHelpOperators(t *testing.T) {
+	s := newTestServer(t, nil)
+	help := func(u uri.URI, line, col int) (string, uint32) {
+		res, err := s.SignatureHelp(context.Background(), &protocol.SignatureHelpParams{
+			TextDocumentPositionParams: textDocumentPosition(u, line, col),
+		})
+		require.NoError(t, err)
+		if len(res.Signatures) == 0 {
+			return "", 0
+		}
+		return res.Signatures[res.ActiveSignature].Label, res.ActiveParameter
+	}
+
+	u := s.open(t, "main.jsonnet", "local name = 'x';\nlocal n = 7;\n[\n  'hello %s' % name,\n  n % 2,\n  'a' in {a: 1},\n]\n")
+	label, param := help(u, 4, 14)
+	assert.Equal(t, "std.format(str: string, vals) -> string", label)
+	assert.Equal(t, uint32(1), param)
+	label, param = help(u, 5, 6)
+	assert.Equal(t, "std.mod(a: number, b: number) -> number", label)
+	assert.Equal(t, uint32(1), param)
+	// operands of `in` are reversed in the call
+	label, param = help(u, 6, 7)
+	assert.Equal(t, "std.objectHasAll(o: object, f: string) -> boolean", label)
+	assert.Equal(t, uint32(0), param)
+}
+
+func TestWorkspaceSymbols(t *testing.T) {
+	s := newTestServer(t, map[string]string{
+		"lib/util.libsonnet":           "local helper(x) = x;\n{\n  makeThing(name):: helper(name),\n  'quoted-name': 1,\n}\n",
+		"main.jsonnet":                 "local util = import 'lib/util.libsonnet';\nutil.makeThing('a')\n",
+		"node_modules/dep/x.libsonnet": "{ makeOther: 1 }",
+		"notes.txt":                    "makeNothing",
+	})
+	cacheFile := filepath.Join(t.TempDir(), "symbols.json")
+	s.symbols.load(cacheFile)
+
+	symbols := func(query string) []string {
+		res, err := s.Symbols(context.Background(), &protocol.WorkspaceSymbolParams{Query: query})
+		require.NoError(t, err)
+		names := []string{}
+		for _, sym := range res {
+			names = append(names, sym.ContainerName+":"+sym.Name)
+		}
+		return names
+	}
+
+	assert.Equal(t, []string{"lib/util.libsonnet:makeThing"}, symbols("make"))
+	assert.Equal(t, []string{"lib/util.libsonnet:helper", "lib/util.libsonnet:makeThing", "lib/util.libsonnet:quoted-name", "main.jsonnet:util"}, symbols(""))
+
+	// the index is persisted
+	loaded := newSymbolIndex()
+	loaded.load(cacheFile)
+	require.Contains(t, loaded.files, "main.jsonnet")
+	require.Len(t, loaded.files["main.jsonnet"].Symbols, 1)
+	assert.Equal(t, "util", loaded.files["main.jsonnet"].Symbols[0].Name)
+
+	// changed files are indexed again
+	path := filepath.Join(s.rootURI.Filename(), "lib", "util.libsonnet")
+	require.NoError(t, os.WriteFile(path, []byte("{ makeOther: 1 }"), 0o644))
+	require.NoError(t, s.DidChangeWatchedFiles(context.Background(), &protocol.DidChangeWatchedFilesParams{
+		Changes: []*protocol.FileEvent{{URI: uri.File(path), Type: protocol.FileChangeTypeChanged}},
+	}))
+	assert.Equal(t, []string{"lib/util.libsonnet:makeOther"}, symbols("make"))
+
+	// the workspace is not walked again, files are added and removed by the watched file events
+	created := filepath.Join(s.rootURI.Filename(), "lib", "new.libsonnet")
+	require.NoError(t, os.WriteFile(created, []byte("{ makeNew: 1 }"), 0o644))
+	assert.Equal(t, []string{"lib/util.libsonnet:makeOther"}, symbols("make"))
+	require.NoError(t, os.Remove(path))
+	require.NoError(t, s.DidChangeWatchedFiles(context.Background(), &protocol.DidChangeWatchedFilesParams{
+		Changes: []*protocol.FileEvent{
+			{URI: uri.File(created), Type: protocol.FileChangeTypeCreated},
+			{URI: uri.File(path), Type: protocol.FileChangeTypeDeleted},
+		},
+	}))
+	assert.Equal(t, []string{"lib/new.libsonnet:makeNew"}, symbols("make"))
+	require.NoError(t, s.symbols.save())
+	loaded = newSymbolIndex()
+	loaded.load(cacheFile)
+	assert.NotContains(t, loaded.files, "lib/util.libsonnet")
+
+	// ignored files are not added
+	ignored := filepath.Join(s.rootURI.Filename(), "node_modules", "dep", "y.libsonnet")
+	require.NoError(t, os.WriteFile(ignored, []byte("{ makeIgnored: 1 }"), 0o644))
+	require.NoError(t, s.DidChangeWatchedFiles(context.Background(), &protocol.DidChangeWatchedFilesParams{
+		Changes: []*protocol.FileEvent{{URI: uri.File(ignored), Type: protocol.FileChangeTypeCreated}},
+	}))
+	assert.Equal(t, []string{"lib/new.libsonnet:makeNew"}, symbols("make"))
+}
+
+func TestDocumentColor(t *testing.T) {
+	s := newTestServer(t, nil)
+	u := s.open(t, "main.jsonnet", "{\n  a: '#ff0000',\n  b: \"rgba(0, 128, 255, 0.5)\",\n  c: '#0f08',\n  d: 'red',\n  e: '\\u0023fff',\n  f: |||\n    #fff\n  |||,\n}\n")
+
+	colors, err := s.DocumentColor(context.Background(), &protocol.DocumentColorParams{TextDocument: protocol.TextDocumentIdentifier{URI: u}})
+	require.NoError(t, err)
+	require.Len(t, colors, 3)
+	assert.Equal(t, protocol.Range{Start: protocol.Position{Line: 1, Character: 6}, End: protocol.Position{Line: 1, Character: 13}}, colors[0].Range)
+	assert.Equal(t, protocol.Color{Red: 1, Alpha: 1}, colors[0].Color)
+	assert.Equal(t, protocol.Color{Green: 128.0 / 255, Blue: 1, Alpha: 0.5}, colors[1].Color)
+	assert.Equal(t, protocol.Color{Green: 1, Alpha: 136.0 / 255}, colors[2].Color)
+
+	presentations := func(rng protocol.Range, color protocol.Color) []string {
+		res, err := s.ColorPresentation(context.Background(), &protocol.ColorPresentationParams{
+			TextDocument: protocol.TextDocumentIdentifier{URI: u},
+			Color:        color,
+			Range:        rng,
+		})
+		require.NoError(t, err)
+		labels := []string{}
+		for _, p := range res {
+			assert.Equal(t, rng, p.TextEdit.Range)
+			labels = append(labels, p.TextEdit.NewText)
+		}
+		return labels
+	}
+	assert.Equal(t, []string{"#00ff00", "rgb(0, 255, 0)"}, presentations(colors[0].Range, protocol.Color{Green: 1, Alpha: 1}))
+	assert.Equal(t, []string{"rgba(255, 0, 0, 0.25)", "#ff000040"}, presentations(colors[1].Range, protocol.Color{Red: 1, Alpha: 0.25}))
+}
+
+func TestSymbolID(t *testing.T) {
+	s := newTestServer(t, map[string]string{"lib/util.libsonnet": "local helpers = {fmt(x): x, 'a b': 1};\n{helpers: helpers}\n"})
+	u := s.open(t, "main.jsonnet", "local util = import 'lib/util.libsonnet';\nlocal f(p) = p + 1;\n{\n  x: util.helpers.fmt(1),\n  y: f(2),\n  z: std.map(function(e) e, []),\n}\n")
+
+	symbolID := func(line, col int) string {
+		res, err := s.SymbolID(context.Background(), &SymbolIDParams{TextDocument: &protocol.TextDocumentIdentifier{URI: u}, Position: protocol.Position{Line: uint32(line - 1), Character: uint32(col - 1)}})
+		require.NoError(t, err)
+		if res == nil {
+			return ""
+		}
+		return res.ID
+	}
+
+	// definitions
+	assert.Equal(t, "main.jsonnet#local:util", symbolID(1, 7))
+	assert.Equal(t, "main.jsonnet#field:x", symbolID(4, 3))
+	assert.Equal(t, "main.jsonnet#local:f/param:p", symbolID(2, 9))
+	// uses, in the same file and imported files
+	assert.Equal(t, "main.jsonnet#local:f/param:p", symbolID(2, 14))
+	assert.Equal(t, "main.jsonnet#local:f", symbolID(5, 6))
+	assert.Equal(t, "lib/util.libsonnet#local:helpers/field:fmt", symbolID(4, 21))
+	assert.Equal(t, "main.jsonnet#field:z/arg:0/param:e", symbolID(6, 26))
+	// not a symbol
+	assert.Equal(t, "", symbolID(5, 8))
+}
+
+func TestUnresolvedImports(t *testing.T) {
+	s := newTestServer(t, map[string]string{"lib/util.libsonnet": "{}"})
+	s.importer.SetJPaths([]string{"vendor"})
+	u := s.open(t, "app/main.jsonnet", "local util = import 'lib/util.libsonnet';\nlocal k = import 'k.libsonnet';\n{data: importstr 'data.txt'}\n")
+
+	res, err := s.UnresolvedImports(context.Background(), &UnresolvedImportsParams{TextDocument: &protocol.TextDocumentIdentifier{URI: u}})
+	require.NoError(t, err)
+	root := s.rootURI.Filename()
+	candidates := func(path string) []string {
+		return []string{filepath.Join(root, "app", path), filepath.Join(root, path), filepath.Join(root, "vendor", path)}
+	}
+	assert.Equal(t, []UnresolvedImport{
+		{
+			Path:       "k.libsonnet",
+			Range:      protocol.Range{Start: protocol.Position{Line: 1, Character: 10}, End: protocol.Position{Line: 1, Character: 30}},
+			Candidates: candidates("k.libsonnet"),
+		},
+		{
+			Path:       "data.txt",
+			Range:      protocol.Range{Start: protocol.Position{Line: 2, Character: 7}, End: protocol.Position{Line: 2, Character: 27}},
+			Candidates: candidates("data.txt"),
+		},
+	}, res.Imports)
+}
+
+func TestFileExtensions(t *testing.T) {
+	s := newTestServer(t, nil)
+	s.config.FileExtensions = []string{".jsonnet", ".libsonnet", ".jsonnet.TEMPLATE"}
+	open := func(name string, language protocol.LanguageIdentifier) uri.URI {
+		u := uri.File(filepath.Join(s.rootURI.Filename(), name))
+		s.setDocumentLanguage(u, language)
+		s.overlay.ReplaceSync(u, 1, "{a: 1}\n", s.documentParseFn(u))
+		return u
+	}
+
+	assert.NotNil(t, s.getCurrentAST(open("main.jsonnet", "plaintext")))
+	assert.NotNil(t, s.getCurrentAST(open("app.jsonnet.TEMPLATE", "plaintext")))
+	assert.NotNil(t, s.getCurrentAST(open("config.txt", "jsonnet")))
+	plain := open("notes.txt", "plaintext")
+	assert.Nil(t, s.getCurrentAST(plain))
+	assert.Equal(t, "{a: 1}\n", s.overlay.Current(plain).Contents)
+
+	// closing forgets the language
+	require.NoError(t, s.DidClose(context.Background(), &protocol.DidCloseTextDocumentParams{TextDocument: protocol.TextDocumentIdentifier{URI: plain}}))
+	assert.Empty(t, s.plainDocs)
+}
+
+func TestDirConfig(t *testing.T) {
+	s := newTestServer(t, map[string]string{
+		"team/.jsonnet-lsp.json":     `{"jpaths": ["lib"], "diag": {"maxStringConcat": 1}}`,
+		"team/lib/util.libsonnet":    "{}",
+		"team/app/.jsonnet-lsp.json": `{"diag": {"linter": false}}`,
+		"team/bad/.jsonnet-lsp.json": `{"diag": `,
+	})
+	s.importer.dirJPaths = s.dirJPaths
+	root := s.rootURI.Filename()
+	file := func(name string) uri.URI { return uri.File(filepath.Join(root, name)) }
+
+	assert.Same(t, s.config, s.fileConfig(file("main.jsonnet")))
+
+	team := s.fileConfig(file("team/main.jsonnet"))
+	assert.Equal(t, 1, team.Diag.MaxStringConcat)
+	assert.True(t, team.Diag.ImportNotFound)
+	assert.Equal(t, []string{filepath.Join(root, "team/lib")}, s.dirJPaths(file("team/main.jsonnet").Filename()))
+
+	// the nearest configuration wins, and keeps the settings of its parents
+	app := s.fileConfig(file("team/app/nested/main.jsonnet"))
+	assert.False(t, app.Diag.Linter)
+	assert.Equal(t, 1, app.Diag.MaxStringConcat)
+	// invalid configuration files are ignored
+	assert.Equal(t, team.Diag, s.fileConfig(file("team/bad/main.jsonnet")).Diag)
+
+	// jpaths are only searched from the files below the configuration
+	_, foundAt, err := s.importer.Import(file("team/app/main.jsonnet").Filename(), "util.libsonnet")
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(root, "team/lib/util.libsonnet"), foundAt)
+	_, _, err = s.importer.Import(file("main.jsonnet").Filename(), "util.libsonnet")
+	assert.Error(t, err)
+	// only the jpaths of configuration files are searched before the search paths of the workspace
+	cfg := *s.config
+	cfg.JPaths = []string{"/global"}
+	s.config = &cfg
+	assert.Empty(t, s.dirJPaths(file("main.jsonnet").Filename()))
+	assert.Equal(t, []string{filepath.Join(root, "team/lib")}, s.dirJPaths(file("team/main.jsonnet").Filename()))
+	assert.Equal(t, []string{"/global"}, s.fileConfig(file("team/main.jsonnet")).JPaths)
+
+	// changed configuration files are read again
+	require.NoError(t, os.WriteFile(filepath.Join(root, "team/.jsonnet-lsp.json"), []byte(`{"diag": {"maxStringConcat": 2}}`), 0o644))
+	require.NoError(t, s.DidChangeWatchedFiles(context.Background(), &protocol.DidChangeWatchedFilesParams{
+		Changes: []*protocol.FileEvent{{URI: file("team/.jsonnet-lsp.json"), Type: protocol.FileChangeTypeChanged}},
+	}))
+	assert.Equal(t, 2, s.fileConfig(file("team/app/main.jsonnet")).Diag.MaxStringConcat)
+}
+
+func TestDirConfigCannotRelaxEvaluation(t *testing.T) {
+	s := newTestServer(t, map[string]string{
+		"untrusted/.jsonnet-lsp.json": `{
+			"sandboxEvaluate": false,
+			"extVars": {"secret": "x"},
+			"diag": {"evaluate": true, "evaluateOn": "save", "evaluateGate": "none", "maxStringConcat": 1}
+		}`,
+	})
+	s.config.SandboxEvaluate = true
+	cfg := s.fileConfig(uri.File(filepath.Join(s.rootURI.Filename(), "untrusted/main.jsonnet")))
+	assert.True(t, cfg.SandboxEvaluate)
+	assert.Empty(t, cfg.ExtVars)
+	assert.Equal(t, s.config.Diag.Evaluate, cfg.Diag.Evaluate)
+	assert.Equal(t, s.config.Diag.EvaluateOn, cfg.Diag.EvaluateOn)
+	assert.Equal(t, s.config.Diag.EvaluateGate, cfg.Diag.EvaluateGate)
+	// the lint settings still apply
+	assert.Equal(t, 1, cfg.Diag.MaxStringConcat)
+}
+
+func TestInlayHintImplicitPlus(t *testing.T) {
+	s := newTestServer(t, nil)
+	u := s.open(t, "main.jsonnet", "local base = {a: 1};\n{\n  x: base {b: 2},\n  y: base + {c: 3},\n  z: (base) /* merged */ {d: 4},\n}\n")
+	params := map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": string(u)},
+		"range":        map[string]interface{}{"start": map[string]interface{}{"line": 0, "character": 0}, "end": map[string]interface{}{"line": 10, "character": 0}},
+	}
+
+	// disabled by default
+	res, err := s.Request(context.Background(), "textDocument/inlayHint", params)
+	require.NoError(t, err)
+	assert.Empty(t, res)
+
+	s.config.InlayHints.ImplicitPlus = true
+	res, err = s.Request(context.Background(), "textDocument/inlayHint", params)
+	require.NoError(t, err)
+	positions := []protocol.Position{}
+	for _, hint := range res.([]InlayHint) {
+		assert.Equal(t, "+", hint.Label)
+		positions = append(positions, hint.Position)
+	}
+	assert.Equal(t, []protocol.Position{{Line: 2, Character: 10}, {Line: 4, Character: 25}}, positions)
+}
+
+func TestInlayHintCapability(t *testing.T) {
+	var result interface{}
+	reply := withInlayHintCapability(func(_ context.Context, res interface{}, _ error) error {
+		result = res
+		return nil
+	})
+	require.NoError(t, reply(context.Background(), &protocol.InitializeResult{Capabilities: protocol.ServerCapabilities{HoverProvider: true}}, nil))
+	caps := result.(map[string]interface{})["capabilities"].(map[string]interface{})
+	assert.Equal(t, true, caps["inlayHintProvider"])
+	assert.Equal(t, true, caps["hoverProvider"])
+}
+
+func TestImportedFunctions(t *testing.T) {
+	s := newTestServer(t, map[string]string{"lib.libsonnet": "local greet(name, greeting='hello') = greeting + ' ' + name;\n{\n  greet: greet,\n  // Formats a port\n  port:: function(num/*:number*/) 'port-' + num,\n}\n"})
+	u := s.open(t, "main.jsonnet", "local lib = import 'lib.libsonnet';\n{\n  a: lib.greet('x'),\n  b: lib.port(80),\n}\n")
+
+	// hidden helpers are completed when accessed explicitly
+	assert.Equal(t, []string{"greet", "port"}, completionLabels(t, s, u, 3, 9, "."))
+
+	help := func(line, col int) string {
+		res, err := s.SignatureHelp(context.Background(), &protocol.SignatureHelpParams{
+			TextDocumentPositionParams: textDocumentPosition(u, line, col),
+		})
+		require.NoError(t, err)
+		if len(res.Signatures) == 0 {
+			return ""
+		}
+		return res.Signatures[0].Label
+	}
+	// only the parameters are checked, defaults are not rendered with their value
+	greet := help(3, 20)
+	assert.True(t, strings.HasPrefix(greet, "greet(name, greeting"), greet)
+	assert.Equal(t, "port(num: number)", help(4, 18))
+}
@@ -0,0 +1,109 @@
+package lsp
+
+import (
+	"io/fs"
+	"path"
+	"strings"
+)
+
+// ignorePattern is a single line of a .gitignore style pattern list
+type ignorePattern struct {
+	glob string
+	// only match directories (trailing `/`)
+	dirOnly bool
+	// match against the full path instead of the base name (contains a `/`)
+	anchored bool
+	// re-include paths matched by an earlier pattern (leading `!`)
+	negate bool
+}
+
+// ignoreMatcher skips files and directories when scanning the workspace. It supports the
+// common subset of .gitignore syntax: globs, negation, directory-only and anchored patterns.
+type ignoreMatcher struct {
+	patterns []ignorePattern
+}
+
+func parseIgnorePatterns(lines []string) []ignorePattern {
+	res := []ignorePattern{}
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		p := ignorePattern{}
+		if strings.HasPrefix(line, "!") {
+			p.negate = true
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			p.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		// `**/name` matches at any depth, which is the same as an unanchored pattern
+		line = strings.TrimPrefix(line, "**/")
+		if strings.Contains(line, "/") {
+			p.anchored = true
+			line = strings.TrimPrefix(line, "/")
+		}
+		if line == "" {
+			continue
+		}
+		p.glob = line
+		res = append(res, p)
+	}
+	return res
+}
+
+// loadIgnoreMatcher reads the .gitignore at the root of `fsys` (if any) followed by `excludes`
+func loadIgnoreMatcher(fsys fs.FS, excludes []string) *ignoreMatcher {
+	m := &ignoreMatcher{}
+	if fsys != nil {
+		if data, err := fs.ReadFile(fsys, ".gitignore"); err == nil {
+			m.patterns = append(m.patterns, parseIgnorePatterns(strings.Split(string(data), "\n"))...)
+		}
+	}
+	m.patterns = append(m.patterns, parseIgnorePatterns(excludes)...)
+	return m
+}
+
+// workspaceIgnore returns the matcher of the .gitignore of the workspace and the excluded paths,
+// which is cached until the configuration or the .gitignore changes
+func (s *Server) workspaceIgnore() *ignoreMatcher {
+	s.ignoreLock.Lock()
+	defer s.ignoreLock.Unlock()
+	if s.ignore == nil || s.ignoreCfg != s.config {
+		s.ignore, s.ignoreCfg = loadIgnoreMatcher(s.rootFS, s.config.ExcludeGlobs), s.config
+	}
+	return s.ignore
+}
+
+// invalidateIgnore drops the cached matcher, when the .gitignore of the workspace changes
+func (s *Server) invalidateIgnore() {
+	s.ignoreLock.Lock()
+	defer s.ignoreLock.Unlock()
+	s.ignore = nil
+}
+
+// Match returns true if the slash separated path relative to the root should be skipped.
+// Patterns are applied in order, and the last matching pattern wins.
+func (m *ignoreMatcher) Match(rel string, isDir bool) bool {
+	if m == nil {
+		return false
+	}
+	rel = strings.Trim(path.Clean(rel), "/")
+	base := path.Base(rel)
+	ignored := false
+	for _, p := range m.patterns {
+		if p.dirOnly && !isDir {
+			continue
+		}
+		target := base
+		if p.anchored {
+			target = rel
+		}
+		if ok, _ := path.Match(p.glob, target); ok {
+			ignored = !p.negate
+		}
+	}
+	return ignored
+}
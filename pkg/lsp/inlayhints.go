@@ -0,0 +1,137 @@
+package lsp
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	"github.com/carlverge/jsonnet-lsp/pkg/analysis"
+	"github.com/google/go-jsonnet/ast"
+	"go.lsp.dev/jsonrpc2"
+	"go.lsp.dev/protocol"
+)
+
+// Inlay hints are newer than the protocol package, so the request is served by Request, and the
+// capability is added to the initialize result by Handler.
+const methodInlayHint = "textDocument/inlayHint"
+
+type InlayHintParams struct {
+	TextDocument protocol.TextDocumentIdentifier `json:"textDocument"`
+	Range        protocol.Range                  `json:"range"`
+}
+
+type InlayHint struct {
+	Position     protocol.Position `json:"position"`
+	Label        string            `json:"label"`
+	Tooltip      string            `json:"tooltip,omitempty"`
+	PaddingLeft  bool              `json:"paddingLeft,omitempty"`
+	PaddingRight bool              `json:"paddingRight,omitempty"`
+}
+
+// withInlayHintCapability adds the inlay hint provider to the capabilities of the initialize result
+func withInlayHintCapability(reply jsonrpc2.Replier) jsonrpc2.Replier {
+	return func(ctx context.Context, result interface{}, err error) error {
+		if _, ok := result.(*protocol.InitializeResult); !ok || err != nil {
+			return reply(ctx, result, err)
+		}
+		data, merr := json.Marshal(result)
+		raw := map[string]interface{}{}
+		if merr != nil || json.Unmarshal(data, &raw) != nil {
+			return reply(ctx, result, err)
+		}
+		if caps, ok := raw["capabilities"].(map[string]interface{}); ok {
+			caps["inlayHintProvider"] = true
+		}
+		return reply(ctx, raw, err)
+	}
+}
+
+// Request serves the requests the protocol package does not know about
+func (s *Server) Request(ctx context.Context, method string, params interface{}) (interface{}, error) {
+	switch method {
+	case methodInlayHint:
+		args := &InlayHintParams{}
+		data, err := json.Marshal(params)
+		if err != nil || json.Unmarshal(data, args) != nil {
+			return nil, jsonrpc2.ErrInvalidParams
+		}
+		return s.InlayHint(ctx, args)
+	}
+	return s.FallbackServer.Request(ctx, method, params)
+}
+
+// onlyFodderBetween checks if the source between two locations only has whitespace, comments and
+// closing parentheses, which are not part of the location of a parenthesized expression
+func onlyFodderBetween(lines []string, begin, end ast.Location) bool {
+	if begin.Line < 1 || end.Line > len(lines) || begin.Line > end.Line ||
+		begin.Column-1 > len([]rune(lines[begin.Line-1])) || end.Column-1 > len([]rune(lines[end.Line-1])) {
+		return false
+	}
+	src := sourceSpan(lines, ast.LocationRange{Begin: begin, End: end})
+	for src != "" {
+		switch {
+		case strings.HasPrefix(src, "//") || strings.HasPrefix(src, "#"):
+			if i := strings.IndexByte(src, '\n'); i >= 0 {
+				src = src[i:]
+			} else {
+				src = ""
+			}
+		case strings.HasPrefix(src, "/*"):
+			i := strings.Index(src, "*/")
+			if i < 0 {
+				return false
+			}
+			src = src[i+2:]
+		case strings.ContainsAny(src[:1], " \t\r\n)"):
+			src = src[1:]
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// implicitPlusHints shows a `+` where an object is merged without one, like `base { ... }`
+func implicitPlusHints(root ast.Node, lines []string) []InlayHint {
+	res := []InlayHint{}
+	analysis.WalkStack(root, func(n ast.Node, _ []ast.Node) bool {
+		bin, ok := n.(*ast.Binary)
+		if !ok || bin.Op != ast.BopPlus {
+			return true
+		}
+		obj, ok := bin.Right.(*ast.DesugaredObject)
+		left := analysis.NodeRange(bin.Left)
+		if !ok || !obj.LocRange.IsSet() || !left.IsSet() || !onlyFodderBetween(lines, left.End, obj.LocRange.Begin) {
+			return true
+		}
+		res = append(res, InlayHint{
+			Position:     posToProto(obj.LocRange.Begin),
+			Label:        "+",
+			Tooltip:      "`a { ... }` is the same as `a + { ... }`",
+			PaddingRight: true,
+		})
+		return true
+	})
+	return res
+}
+
+// InlayHint serves the hints enabled in the `inlayHints` configuration in the range
+func (s *Server) InlayHint(ctx context.Context, params *InlayHintParams) ([]InlayHint, error) {
+	res := []InlayHint{}
+	parsed := s.overlay.Parsed(params.TextDocument.URI)
+	if parsed == nil || !s.fileConfig(params.TextDocument.URI).InlayHints.ImplicitPlus {
+		return res, nil
+	}
+	pr, _ := parsed.Data.(*ParseResult)
+	if pr == nil || pr.Root == nil {
+		return res, nil
+	}
+
+	lines := strings.Split(parsed.Contents, "\n")
+	for _, hint := range implicitPlusHints(pr.Root, lines) {
+		if hint.Position.Line >= params.Range.Start.Line && hint.Position.Line <= params.Range.End.Line {
+			res = append(res, hint)
+		}
+	}
+	return res, nil
+}
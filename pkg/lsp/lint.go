@@ -0,0 +1,135 @@
+package lsp
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/carlverge/jsonnet-lsp/pkg/linter"
+	"github.com/carlverge/jsonnet-lsp/pkg/overlay"
+	"go.lsp.dev/protocol"
+	"go.lsp.dev/uri"
+)
+
+// Output formats of Lint
+const (
+	LintFormatText  = "text"
+	LintFormatSARIF = "sarif"
+)
+
+// LintParams are the files checked by Lint
+type LintParams struct {
+	// Root is the workspace root. If empty, it is the nearest directory above the first file (or
+	// the working directory) containing a root marker.
+	Root string
+	// JPaths are additional search paths, like the `jpaths` setting
+	JPaths []string
+	// ImportRoots map absolute import paths to directories, like the `importRoots` setting
+	ImportRoots map[string]string
+	Files       []string
+	// Format is LintFormatText (the default) or LintFormatSARIF
+	Format string
+}
+
+// Lint writes the diagnostics the server would publish for each file with the default
+// configuration, without evaluating the files. Returns an error if any of the diagnostics is
+// an error.
+func Lint(w io.Writer, params LintParams) error {
+	if params.Format == "" {
+		params.Format = LintFormatText
+	}
+	if params.Format != LintFormatText && params.Format != LintFormatSARIF {
+		return fmt.Errorf("unknown format '%s', expected '%s' or '%s'", params.Format, LintFormatText, LintFormatSARIF)
+	}
+
+	s := &Server{
+		FallbackServer: &FallbackServer{},
+		overlay:        overlay.NewOverlay(),
+		symbols:        newSymbolIndex(),
+		config:         defaultConfiguration(),
+	}
+	s.config.ImportRoots = params.ImportRoots
+	first := ""
+	if len(params.Files) > 0 {
+		first, _ = filepath.Abs(params.Files[0])
+	}
+	root, err := workspaceRoot(params.Root, first, s.config.RootMarkers)
+	if err != nil {
+		return err
+	}
+	s.rootURI = uri.File(root)
+	s.rootFS = os.DirFS(root)
+	s.searchPaths = workspaceSearchPaths(s.rootFS)
+	s.importer = newOverlayImporter(s.overlay, s.rootURI, s.rootFS, s.searchPaths, s.config)
+	s.importer.dirJPaths = s.dirJPaths
+	s.importer.SetJPaths(params.JPaths)
+
+	// diagnostics by the path of the file relative to the root
+	diags := map[string][]protocol.Diagnostic{}
+	paths := []string{}
+	errors := 0
+	for _, file := range params.Files {
+		abs, err := filepath.Abs(file)
+		if err != nil {
+			return err
+		}
+		contents, err := os.ReadFile(abs)
+		if err != nil {
+			return err
+		}
+		u := uri.File(abs)
+		s.overlay.ReplaceSync(u, 1, string(contents), parseJsonnetFn(u))
+
+		fileDiags := []protocol.Diagnostic{}
+		if fileAST := s.getCurrentAST(u); fileAST != nil {
+			fileDiags = append(fileDiags, s.lintDiags(u, s.newResolver(u, fileAST), string(contents), s.fileConfig(u))...)
+		} else if pr, _ := s.overlay.Current(u).Data.(*ParseResult); pr.StaticErr() != nil {
+			fileDiags = append(fileDiags, protocol.Diagnostic{
+				Severity: protocol.DiagnosticSeverityError,
+				Range:    rangeToProto(pr.StaticErr().Loc()),
+				Message:  pr.StaticErr().Error(),
+				Source:   "jsonnet",
+			})
+		}
+		if s.fileConfig(u).Diag.MixedIndentation {
+			fileDiags = append(fileDiags, linter.LintMixedIndentation(string(contents))...)
+		}
+
+		path := abs
+		if rel, err := filepath.Rel(root, abs); err == nil && !strings.HasPrefix(rel, "..") {
+			path = rel
+		}
+		path = filepath.ToSlash(path)
+		if _, ok := diags[path]; !ok {
+			paths = append(paths, path)
+		}
+		diags[path] = fileDiags
+		for _, d := range fileDiags {
+			if d.Severity == protocol.DiagnosticSeverityError {
+				errors++
+			}
+		}
+	}
+
+	if params.Format == LintFormatSARIF {
+		if err := linter.WriteSARIF(w, diags); err != nil {
+			return err
+		}
+	} else {
+		for _, path := range paths {
+			for _, d := range diags[path] {
+				code := ""
+				if d.Code != nil {
+					code = fmt.Sprintf(" [%s]", d.Code)
+				}
+				fmt.Fprintf(w, "%s:%d:%d: %s: %s%s\n", path, d.Range.Start.Line+1, d.Range.Start.Character+1, strings.ToLower(d.Severity.String()), d.Message, code)
+			}
+		}
+	}
+	if errors > 0 {
+		return fmt.Errorf("%d errors found", errors)
+	}
+	return nil
+}
@@ -8,6 +8,7 @@ import (
 	"io/fs"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -45,18 +46,49 @@ type Server struct {
 
 	overlay  *overlay.Overlay
 	importer *OverlayImporter
+	symbols  *symbolIndex
 	vmlock   sync.Mutex
 	config   *Configuration
 
-	// intentionally only keep one active VM at once
-	// when an operation needs a full VM (f.ex if it needs to
-	// traverse imports) then dump the VM and create a new one.
-	// This usually only happens when users switch and then edit a file,
-	// and the latency is usually on the order of <1s. Not acceptable on
-	// every operation, but acceptable on file change. This helps keep
-	// memory usage low as we don't keep a VM in memory for every active
+	// the VMs of the most recently used files, most recent first.
+	// When an operation needs a full VM (f.ex if it needs to
+	// traverse imports) on a file without one, the least recently
+	// used VM is dropped and a new one is created. Creating a VM
+	// usually takes <1s, which is acceptable when switching files but
+	// not on every operation. Keeping only a few (`vmCacheSize`) bounds
+	// memory usage, as we don't keep a VM in memory for every active
 	// file we're editing.
-	vm *vmCache
+	vms []*vmCache
+
+	// Configuration files of subdirectories, see fileConfig
+	dirConfigs dirConfigs
+
+	// Cancels in-flight evaluations for diagnostics, by the file being evaluated.
+	// An evaluation is canceled when a newer version of the file arrives.
+	evalLock    sync.Mutex
+	evalCancels map[uri.URI]*evalCancel
+	// Abandoned evaluations that are still running in the background, by the file being
+	// evaluated. The channel is closed when the evaluation finishes, and new evaluations of
+	// the file wait for it, so abandoned evaluations do not pile up.
+	abandonedEvals map[uri.URI]chan struct{}
+
+	// The document symbols of each file, by the version of the file they were computed from.
+	// Outline and breadcrumb views request these on every cursor move.
+	docSymbolLock sync.Mutex
+	docSymbols    map[uri.URI]*cachedDocumentSymbols
+
+	// The diagnostics last published for each file, explained when hovering them
+	diagLock  sync.Mutex
+	published map[uri.URI][]protocol.Diagnostic
+
+	// Open documents that are not jsonnet, which are kept in the overlay without being parsed
+	docLock   sync.Mutex
+	plainDocs map[uri.URI]bool
+
+	// The paths of the workspace skipped when listing files, see workspaceIgnore
+	ignoreLock sync.Mutex
+	ignore     *ignoreMatcher
+	ignoreCfg  *Configuration
 
 	// set to true if the last edit to the document was a '.'
 	// used to change autocomplete behaviour
@@ -85,6 +117,7 @@ func RunServer(ctx context.Context, stdout *os.File) error {
 	srv := &Server{
 		FallbackServer: &FallbackServer{},
 		overlay:        overlay.NewOverlay(),
+		symbols:        newSymbolIndex(),
 		cancel:         cancel,
 		notifier:       notifier,
 		config:         defaultConfiguration(),
@@ -169,7 +202,7 @@ func (imp *cachedImporter) Import(from, path string) (contents jsonnet.Contents,
 		return jsonnet.Contents{}, "", err
 	}
 
-	contents, foundAt, err = imp.real.Import(from, path)
+	contents, foundAt, err = safeImport(imp.real, from, path)
 	if err != nil {
 		imp.notFound[key] = err
 		return contents, foundAt, err
@@ -185,15 +218,61 @@ func (imp *cachedImporter) Import(from, path string) (contents jsonnet.Contents,
 	return imp.cache[foundAt], foundAt, nil
 }
 
+// imported returns true if the contents of the file at `path` are cached
+func (imp *cachedImporter) imported(path string) bool {
+	imp.lock.Lock()
+	defer imp.lock.Unlock()
+	_, ok := imp.cache[path]
+	return ok
+}
+
+// missing returns true if an import that was not found would be searched for at `path`, so
+// creating the file changes the result of the import
+func (imp *cachedImporter) missing(path string, candidates func(from, path string) []uri.URI) bool {
+	imp.lock.Lock()
+	defer imp.lock.Unlock()
+	for key := range imp.notFound {
+		for _, candidate := range candidates(key[0], key[1]) {
+			if candidate.Filename() == path {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// safeImport calls the importer, converting any panic into an error. go-jsonnet can
+// panic on some malformed inputs, and a single bad file should not take down the
+// handler goroutine for the whole session.
+func safeImport(imp jsonnet.Importer, from, path string) (contents jsonnet.Contents, foundAt string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			logf("recovered panic importing '%s' from '%s': %v", path, from, r)
+			contents, foundAt, err = jsonnet.Contents{}, "", fmt.Errorf("panic importing '%s': %v", path, r)
+		}
+	}()
+	return imp.Import(from, path)
+}
+
 type OverlayImporter struct {
 	overlay *overlay.Overlay
 	rootURI uri.URI
 	rootFS  fs.FS
 	paths   []string
 
-	// Additional user specified paths (can change at runtime)
-	jpathLock sync.Mutex
-	jpaths    []string
+	// Additional user specified paths and root markers (can change at runtime)
+	jpathLock   sync.Mutex
+	jpaths      []string
+	markers     []string
+	importRoots map[string]string
+	// The jpaths of the directory configuration files of a file, see Server.fileConfig
+	dirJPaths func(from string) []string
+}
+
+// newOverlayImporter returns the importer of the workspace at `rootURI`, with the root markers
+// and import roots of the configuration
+func newOverlayImporter(ov *overlay.Overlay, rootURI uri.URI, rootFS fs.FS, searchPaths []string, cfg *Configuration) *OverlayImporter {
+	return &OverlayImporter{overlay: ov, rootURI: rootURI, rootFS: rootFS, paths: searchPaths, markers: cfg.RootMarkers, importRoots: cfg.ImportRoots}
 }
 
 func (imp *OverlayImporter) readURI(uri uri.URI) (res []byte, err error) {
@@ -227,42 +306,213 @@ func (imp *OverlayImporter) SetJPaths(jpaths []string) {
 	imp.jpaths = jpaths
 }
 
-func (imp *OverlayImporter) Import(from, path string) (jsonnet.Contents, string, error) {
+func (imp *OverlayImporter) SetRootMarkers(markers []string) {
+	imp.jpathLock.Lock()
+	defer imp.jpathLock.Unlock()
+	imp.markers = markers
+}
+
+func (imp *OverlayImporter) SetImportRoots(roots map[string]string) {
+	imp.jpathLock.Lock()
+	defer imp.jpathLock.Unlock()
+	imp.importRoots = roots
+}
+
+// mapAbsoluteImport returns the files an absolute import path maps to through the import roots,
+// trying the longest matching prefix first. Roots are relative to the workspace root.
+func (imp *OverlayImporter) mapAbsoluteImport(path string) []string {
+	imp.jpathLock.Lock()
+	roots := imp.importRoots
+	imp.jpathLock.Unlock()
+
+	matches := map[string]string{}
+	prefixes := []string{}
+	for prefix, root := range roots {
+		prefix = filepath.Clean(prefix)
+		if rel, err := filepath.Rel(prefix, path); err == nil && !strings.HasPrefix(rel, "..") {
+			matches[prefix] = root
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	sort.Slice(prefixes, func(i, j int) bool {
+		if len(prefixes[i]) != len(prefixes[j]) {
+			return len(prefixes[i]) > len(prefixes[j])
+		}
+		return prefixes[i] < prefixes[j]
+	})
+
+	res := []string{}
+	for _, prefix := range prefixes {
+		root := matches[prefix]
+		if !filepath.IsAbs(root) {
+			root = filepath.Join(imp.rootURI.Filename(), root)
+		}
+		rel, _ := filepath.Rel(prefix, path)
+		res = append(res, filepath.Join(root, rel))
+	}
+	return res
+}
+
+// projectRoot walks up from the directory of `from` to find the nearest directory containing a
+// root marker (like `jsonnetfile.json`) inside the workspace. Returns an empty string if there
+// is no marker below the workspace root.
+func (imp *OverlayImporter) projectRoot(from string) string {
+	imp.jpathLock.Lock()
+	markers := imp.markers
+	imp.jpathLock.Unlock()
+
 	rootPath := imp.rootURI.Filename()
+	if len(markers) == 0 || from == "" || !filepath.IsAbs(from) {
+		return ""
+	}
+	for dir := filepath.Dir(from); dir != rootPath; dir = filepath.Dir(dir) {
+		rel, err := filepath.Rel(rootPath, dir)
+		if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+			return ""
+		}
+		for _, marker := range markers {
+			if _, err := fs.Stat(imp.rootFS, filepath.Join(rel, marker)); err == nil {
+				return dir
+			}
+		}
+	}
+	return ""
+}
 
-	// if absolute, rel it to the workspace root
-	if filepath.IsAbs(path) {
-		path, _ = filepath.Rel(rootPath, path)
+const jpathDirective = "jsonnet-lsp:jpath"
+
+// parseJPathDirectives finds `//jsonnet-lsp:jpath <path>` comments in the leading
+// comments of a file, which add import search paths for that file only.
+func parseJPathDirectives(contents string) []string {
+	res := []string{}
+	for _, line := range strings.Split(contents, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		var comment string
+		switch {
+		case strings.HasPrefix(line, "//"):
+			comment = strings.TrimPrefix(line, "//")
+		case strings.HasPrefix(line, "#"):
+			comment = strings.TrimPrefix(line, "#")
+		default:
+			// only the head of the file is searched
+			return res
+		}
+		comment = strings.TrimSpace(comment)
+		if !strings.HasPrefix(comment, jpathDirective+" ") {
+			continue
+		}
+		if jpath := strings.TrimSpace(strings.TrimPrefix(comment, jpathDirective)); jpath != "" {
+			res = append(res, jpath)
+		}
 	}
+	return res
+}
 
-	// the path to the importer, relative to the root
-	fromPath, err := filepath.Rel(rootPath, filepath.Dir(from))
-	if err != nil {
-		return jsonnet.Contents{}, "", fmt.Errorf("failed to open '%s' -- could not relativize '%s' to root '%s' %v", path, from, imp.rootURI, err)
+// fileJPaths returns the absolute search paths declared by jpath directives in the file. The
+// directives of open files are parsed with the file, other files are read from disk.
+func (imp *OverlayImporter) fileJPaths(from string) []string {
+	if from == "" || !filepath.IsAbs(from) {
+		return nil
+	}
+	var directives []string
+	if ent := imp.overlay.Parsed(uri.File(from)); ent != nil {
+		if pr, ok := ent.Data.(*ParseResult); ok {
+			directives = pr.JPathDirectives
+		}
+	} else if data, err := imp.readURI(uri.File(from)); err == nil {
+		directives = parseJPathDirectives(string(data))
+	}
+	res := []string{}
+	for _, jpath := range directives {
+		if !filepath.IsAbs(jpath) {
+			jpath = filepath.Join(filepath.Dir(from), jpath)
+		}
+		res = append(res, jpath)
+	}
+	return res
+}
+
+// SearchDirs returns the absolute directories imports from `from` are resolved against, in order
+// of precedence without duplicates:
+//   - the directory of the importing file
+//   - the nearest nested project root (see projectRoot)
+//   - the workspace root
+//   - jpath directives in the importing file
+//   - the jpaths of the directory configuration files of the importing file, nearest first
+//   - the workspace search paths (like `vendor`)
+//   - the configured jpaths
+func (imp *OverlayImporter) SearchDirs(from string) []string {
+	rootPath := imp.rootURI.Filename()
+	res := []string{}
+	seen := map[string]bool{}
+	add := func(dir string) {
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(rootPath, dir)
+		}
+		dir = filepath.Clean(dir)
+		if !seen[dir] {
+			seen[dir] = true
+			res = append(res, dir)
+		}
 	}
 
-	// Build a list of candidate URIs to try for the file
-	candidates := []uri.URI{
-		uri.File(filepath.Join(rootPath, path)),
-		uri.File(filepath.Join(rootPath, fromPath, path)),
+	if from != "" {
+		add(filepath.Dir(from))
+	}
+	if projectPath := imp.projectRoot(from); projectPath != "" {
+		add(projectPath)
+	}
+	add(rootPath)
+	for _, search := range imp.fileJPaths(from) {
+		add(search)
+	}
+	if imp.dirJPaths != nil {
+		for _, search := range imp.dirJPaths(from) {
+			add(search)
+		}
 	}
 	for _, search := range imp.paths {
-		candidates = append(candidates, uri.File(filepath.Join(rootPath, search, path)))
+		add(search)
 	}
 
-	// JPaths feel very hacked in here.
-	// They need to be reconfigurable at runtime.
 	imp.jpathLock.Lock()
 	jpaths := imp.jpaths
 	imp.jpathLock.Unlock()
 	for _, search := range jpaths {
-		if filepath.IsAbs(search) {
-			candidates = append(candidates, uri.File(filepath.Join(search, path)))
-		} else {
-			candidates = append(candidates, uri.File(filepath.Join(rootPath, search, path)))
+		add(search)
+	}
+	return res
+}
+
+// Candidates returns the files an import of `path` from `from` is searched at, in order
+func (imp *OverlayImporter) Candidates(from, path string) []uri.URI {
+	rootPath := imp.rootURI.Filename()
+
+	candidates := []uri.URI{}
+	// if absolute, try the configured import roots, then rel it to the workspace root
+	if filepath.IsAbs(path) {
+		for _, mapped := range imp.mapAbsoluteImport(path) {
+			candidates = append(candidates, uri.File(mapped))
 		}
+		path, _ = filepath.Rel(rootPath, path)
 	}
 
+	// relative imports are resolved from the importing file, not the process working directory
+	if !filepath.IsAbs(from) {
+		from = filepath.Join(rootPath, from)
+	}
+
+	for _, dir := range imp.SearchDirs(from) {
+		candidates = append(candidates, uri.File(filepath.Join(dir, path)))
+	}
+	return candidates
+}
+
+func (imp *OverlayImporter) Import(from, path string) (jsonnet.Contents, string, error) {
+	candidates := imp.Candidates(from, path)
 	tracef("read-path: path='%s' from='%s' candidates=%v", path, from, candidates)
 	tracef("searching for path '%s' in candidates %v", path, candidates)
 	for _, candidate := range candidates {
@@ -275,6 +525,55 @@ func (imp *OverlayImporter) Import(from, path string) (jsonnet.Contents, string,
 	return jsonnet.Contents{}, "", fmt.Errorf("path '%s' not found in candidates %v", path, candidates)
 }
 
+// outsideWorkspace returns true if `foundAt` is outside of the workspace and was not found
+// through an absolute jpath, either configured or declared by a directive in `from`
+func (imp *OverlayImporter) outsideWorkspace(from, foundAt string) bool {
+	if rel, err := filepath.Rel(imp.rootURI.Filename(), foundAt); err != nil || !strings.HasPrefix(rel, "..") {
+		return false
+	}
+
+	imp.jpathLock.Lock()
+	searchPaths := append([]string{}, imp.jpaths...)
+	imp.jpathLock.Unlock()
+	searchPaths = append(searchPaths, imp.fileJPaths(from)...)
+	if imp.dirJPaths != nil {
+		searchPaths = append(searchPaths, imp.dirJPaths(from)...)
+	}
+	for _, search := range searchPaths {
+		if !filepath.IsAbs(search) {
+			continue
+		}
+		if rel, err := filepath.Rel(search, foundAt); err == nil && !strings.HasPrefix(rel, "..") {
+			return false
+		}
+	}
+	return true
+}
+
+// importOutsideWorkspaceDiags flags imports that are only found by reading outside of the
+// workspace. These work by accident of the local filesystem layout, and usually break elsewhere.
+func (s *Server) importOutsideWorkspaceDiags(from uri.URI, root ast.Node) []protocol.Diagnostic {
+	diags := []protocol.Diagnostic{}
+	analysis.WalkStack(root, func(n ast.Node, _ []ast.Node) bool {
+		file := importedFile(n)
+		if file == "" {
+			return true
+		}
+		_, foundAt, err := s.importer.Import(from.Filename(), file)
+		if err != nil || !s.importer.outsideWorkspace(from.Filename(), foundAt) {
+			return true
+		}
+		diags = append(diags, protocol.Diagnostic{
+			Range:    rangeToProto(*n.Loc()),
+			Code:     linter.ImportOutsideWorkspace,
+			Severity: protocol.DiagnosticSeverityInformation,
+			Message:  fmt.Sprintf("import '%s' resolved outside of the workspace at '%s', consider adding its directory to the jpaths", file, foundAt),
+		})
+		return true
+	})
+	return diags
+}
+
 func posToProto(p ast.Location) protocol.Position {
 	line, col := p.Line, p.Column
 	if line > 0 {
@@ -311,6 +610,8 @@ type vmCache struct {
 	// from is the file that created the VM
 	from uri.URI
 	vm   *jsonnet.VM
+	// importer keeps the contents of the files imported through the VM
+	importer *cachedImporter
 }
 
 func (c *vmCache) Use(fn func(vm *jsonnet.VM)) {
@@ -319,9 +620,16 @@ func (c *vmCache) Use(fn func(vm *jsonnet.VM)) {
 	fn(c.vm)
 }
 
-func (c *vmCache) ImportAST(from, path string) (ast.Node, uri.URI) {
+func (c *vmCache) ImportAST(from, path string) (node ast.Node, found uri.URI) {
 	c.lock.Lock()
 	defer c.lock.Unlock()
+	// Treat a panic in go-jsonnet the same as a failed import
+	defer func() {
+		if r := recover(); r != nil {
+			logf("recovered panic importing AST '%s' from '%s': %v", path, from, r)
+			node, found = nil, uri.URI("")
+		}
+	}()
 	contents, foundAt, err := c.vm.ImportAST(from, path)
 	if err != nil {
 		return nil, uri.URI("")
@@ -329,25 +637,83 @@ func (c *vmCache) ImportAST(from, path string) (ast.Node, uri.URI) {
 	return contents, uri.File(foundAt)
 }
 
+// dropVM removes the VM from the cache if it is still active, so the next operation creates a new one
+func (s *Server) dropVM(c *vmCache) {
+	s.vmlock.Lock()
+	defer s.vmlock.Unlock()
+	for i, vm := range s.vms {
+		if vm == c {
+			tracef("dropping jsonnet vm cache (from %s)", c.from)
+			s.vms = append(s.vms[:i:i], s.vms[i+1:]...)
+			return
+		}
+	}
+}
+
+// invalidateVMs drops the VMs of other files that imported the file, as they keep its previous
+// contents, and the VMs that did not find an import that could be the file, as they keep the error
+func (s *Server) invalidateVMs(changed uri.URI) {
+	s.vmlock.Lock()
+	defer s.vmlock.Unlock()
+	keep := s.vms[:0:0]
+	for _, vm := range s.vms {
+		if vm.from != changed && vm.importer != nil && vm.importer.imported(changed.Filename()) {
+			tracef("dropping jsonnet vm cache (from %s, imported %s changed)", vm.from, changed)
+			continue
+		}
+		if vm.importer != nil && vm.importer.missing(changed.Filename(), s.importer.Candidates) {
+			tracef("dropping jsonnet vm cache (from %s, missing import %s changed)", vm.from, changed)
+			continue
+		}
+		keep = append(keep, vm)
+	}
+	s.vms = keep
+}
+
 func (s *Server) getVM(uri uri.URI) *vmCache {
 	s.vmlock.Lock()
 	defer s.vmlock.Unlock()
 
-	// still on the same file, keep the vm cache
-	if s.vm != nil && uri == s.vm.from {
-		return s.vm
+	// already have a vm cache for the file, move it to the front
+	for i, vm := range s.vms {
+		if vm.from == uri {
+			copy(s.vms[1:i+1], s.vms[:i])
+			s.vms[0] = vm
+			return vm
+		}
 	}
 
-	tracef("flusing jsonnet vm cache (changed file to %s)", uri)
-	vm := &vmCache{from: uri, vm: jsonnet.MakeVM()}
-	vm.vm.Importer(&cachedImporter{
+	tracef("creating jsonnet vm cache for %s", uri)
+	importer := &cachedImporter{
 		notFound: map[[2]string]error{},
 		foundAt:  map[[2]string]string{},
 		cache:    map[string]jsonnet.Contents{},
 		real:     s.importer,
-	})
+	}
+	cfg := s.fileConfig(uri)
+	// No native functions are registered, so the importer is the only way to read files
+	if cfg.SandboxEvaluate {
+		importer.real = &sandboxImporter{real: s.importer}
+	}
+	vm := &vmCache{from: uri, vm: jsonnet.MakeVM(), importer: importer}
+	vm.vm.Importer(importer)
 	vm.vm.SetTraceOut(io.Discard)
-	s.vm = vm
+	for name, val := range cfg.ExtVars {
+		vm.vm.ExtVar(name, val)
+	}
+	s.vms = append([]*vmCache{vm}, s.vms...)
+
+	size := s.config.VMCacheSize
+	if size < 1 {
+		size = 1
+	}
+	if len(s.vms) > size {
+		for i := size; i < len(s.vms); i++ {
+			tracef("flushing jsonnet vm cache (from %s)", s.vms[i].from)
+			s.vms[i] = nil
+		}
+		s.vms = s.vms[:size]
+	}
 
 	return vm
 }
@@ -370,6 +736,8 @@ func convChangeEvents(events []protocol.TextDocumentContentChangeEvent) []gotext
 type ParseResult struct {
 	Root ast.Node
 	Err  error
+	// The jpath directives of the file, see parseJPathDirectives
+	JPathDirectives []string
 }
 
 func (p *ParseResult) StaticErr() staticError {
@@ -410,7 +778,7 @@ func tryRecoverAST(uri uri.URI, contents string, lastEdit *gotextdiff.TextEdit)
 func parseJsonnetFn(uri uri.URI) overlay.ParseFunc {
 	return func(contents string, lastEdit *gotextdiff.TextEdit) (result interface{}, success bool) {
 		defer func(t time.Time) { tracef("parsed ast uri=%s len=%d in %s", uri, len(contents), time.Since(t)) }(time.Now())
-		res := &ParseResult{}
+		res := &ParseResult{JPathDirectives: parseJPathDirectives(contents)}
 		res.Root, res.Err = jsonnet.SnippetToAST(uri.Filename(), contents)
 
 		if res.Root == nil && lastEdit != nil {
@@ -421,22 +789,253 @@ func parseJsonnetFn(uri uri.URI) overlay.ParseFunc {
 	}
 }
 
-func (s *Server) processFileUpdateFn(ctx context.Context, uri uri.URI) overlay.UpdateFunc {
-	resv := &valueResolver{
-		rootURI:    uri,
-		rootAST:    nil,
-		roots:      map[string]ast.Node{},
-		stackCache: map[ast.Node][]ast.Node{},
-		getvm:      func() *vmCache { return s.getVM(uri) },
+// setDocumentLanguage records if the opened document is jsonnet, by the language the editor opened
+// it with or its extension. An empty language forgets the document when it is closed.
+func (s *Server) setDocumentLanguage(u uri.URI, languageID protocol.LanguageIdentifier) {
+	s.docLock.Lock()
+	defer s.docLock.Unlock()
+	if s.plainDocs == nil {
+		s.plainDocs = map[uri.URI]bool{}
+	}
+	if languageID == "" || languageID == "jsonnet" || s.config.isJsonnetFile(u.Filename()) {
+		delete(s.plainDocs, u)
+		return
+	}
+	s.plainDocs[u] = true
+}
+
+// documentParseFn returns the parse function for the open document, documents that are not
+// jsonnet are kept in the overlay without being parsed or linted
+func (s *Server) documentParseFn(u uri.URI) overlay.ParseFunc {
+	s.docLock.Lock()
+	plain := s.plainDocs[u]
+	s.docLock.Unlock()
+	if plain {
+		return overlay.EmptyParseFunc
+	}
+	return parseJsonnetFn(u)
+}
+
+var errEvaluateTimeout = errors.New("evaluation timed out")
+
+// evaluate evaluates the AST with the VM, until the context is done. The jsonnet VM cannot be
+// interrupted, so when the context is done the evaluation is abandoned to finish in the background,
+// and the VM is dropped from the cache so that other operations do not block on it. An evaluation
+// canceled before it started keeps the VM, as nothing runs on it. Evaluations of a file wait until
+// its abandoned evaluation finishes before starting.
+func (s *Server) evaluate(ctx context.Context, cache *vmCache, root ast.Node) (string, error) {
+	type result struct {
+		out string
+		err error
+	}
+	evalErr := func() error {
+		if ctx.Err() == context.DeadlineExceeded {
+			return errEvaluateTimeout
+		}
+		return ctx.Err()
 	}
 
+	s.evalLock.Lock()
+	abandoned := s.abandonedEvals[cache.from]
+	s.evalLock.Unlock()
+	if abandoned != nil {
+		select {
+		case <-abandoned:
+		case <-ctx.Done():
+			return "", evalErr()
+		}
+	}
+
+	done := make(chan result, 1)
+	finished := make(chan struct{})
+	// set once the VM starts evaluating, evaluations waiting for the VM are skipped when the
+	// context is done first
+	var startLock sync.Mutex
+	started := false
+	go func() {
+		defer close(finished)
+		cache.Use(func(vm *jsonnet.VM) {
+			startLock.Lock()
+			if ctx.Err() != nil {
+				startLock.Unlock()
+				return
+			}
+			started = true
+			startLock.Unlock()
+			out, err := vm.Evaluate(root)
+			done <- result{out, err}
+		})
+	}()
+
+	select {
+	case res := <-done:
+		return res.out, res.err
+	case <-ctx.Done():
+		startLock.Lock()
+		running := started
+		startLock.Unlock()
+		if running || ctx.Err() == context.DeadlineExceeded {
+			s.dropVM(cache)
+		}
+		if running {
+			s.abandonEvaluation(cache.from, finished)
+		}
+		return "", evalErr()
+	}
+}
+
+// abandonEvaluation records the evaluation of the file as running in the background until
+// `finished` is closed
+func (s *Server) abandonEvaluation(u uri.URI, finished chan struct{}) {
+	s.evalLock.Lock()
+	defer s.evalLock.Unlock()
+	if s.abandonedEvals == nil {
+		s.abandonedEvals = map[uri.URI]chan struct{}{}
+	}
+	s.abandonedEvals[u] = finished
+	go func() {
+		<-finished
+		s.evalLock.Lock()
+		defer s.evalLock.Unlock()
+		if s.abandonedEvals[u] == finished {
+			delete(s.abandonedEvals, u)
+		}
+	}()
+}
+
+type evalCancel struct{ cancel context.CancelFunc }
+
+// startEvaluation creates a context for evaluating the file, that is canceled by cancelEvaluation.
+// The returned CancelFunc must be called when the evaluation is done.
+func (s *Server) startEvaluation(ctx context.Context, u uri.URI) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	ec := &evalCancel{cancel: cancel}
+
+	s.evalLock.Lock()
+	defer s.evalLock.Unlock()
+	if s.evalCancels == nil {
+		s.evalCancels = map[uri.URI]*evalCancel{}
+	}
+	if prev := s.evalCancels[u]; prev != nil {
+		prev.cancel()
+	}
+	s.evalCancels[u] = ec
+
+	return ctx, func() {
+		cancel()
+		s.evalLock.Lock()
+		defer s.evalLock.Unlock()
+		if s.evalCancels[u] == ec {
+			delete(s.evalCancels, u)
+		}
+	}
+}
+
+// cancelEvaluation abandons any in-flight evaluation of the file, as its results would be stale
+func (s *Server) cancelEvaluation(u uri.URI) {
+	s.evalLock.Lock()
+	defer s.evalLock.Unlock()
+	if ec := s.evalCancels[u]; ec != nil {
+		tracef("canceling evaluation of %s", u)
+		ec.cancel()
+		delete(s.evalCancels, u)
+	}
+}
+
+// evaluateContext limits the context by the configured evaluation timeout
+func (s *Server) evaluateContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if timeout := s.config.EvaluateTimeoutMs; timeout > 0 {
+		return context.WithTimeout(ctx, time.Duration(timeout)*time.Millisecond)
+	}
+	return context.WithCancel(ctx)
+}
+
+// evaluateDiags evaluates the root AST, and converts any runtime error into diagnostics
+// highlighting the stack trace in this file. If the evaluation was canceled by a newer version
+// of the file, context.Canceled is returned and the diagnostics should be discarded.
+func (s *Server) evaluateDiags(ctx context.Context, resv *valueResolver) ([]protocol.Diagnostic, error) {
+	defer func(t time.Time) { tracef("evaluation %s done diags in %s", resv.rootURI, time.Since(t)) }(time.Now())
+	diags := []protocol.Diagnostic{}
+
+	ctx, cancelEval := s.startEvaluation(ctx, resv.rootURI)
+	defer cancelEval()
+	ctx, cancel := s.evaluateContext(ctx)
+	defer cancel()
+	_, err := s.evaluate(ctx, resv.getvm(), resv.Root())
+	if err == errEvaluateTimeout {
+		return append(diags, protocol.Diagnostic{
+			Range:    rangeToProto(*resv.Root().Loc()),
+			Severity: protocol.DiagnosticSeverityWarning,
+			Code:     "EvaluateTimeout",
+			Source:   "jsonnet",
+			Message:  fmt.Sprintf("evaluation timed out after %dms", s.config.EvaluateTimeoutMs),
+		}), nil
+	}
+	if err == context.Canceled {
+		return nil, err
+	}
+	rterr, ok := err.(jsonnet.RuntimeError)
+	if !ok {
+		return diags, nil
+	}
+
+	// Grab the stack trace from the error, and highlight
+	// each line.
+	fname := resv.Root().Loc().FileName
+	seenRootCause := false
+	for _, frame := range rterr.StackTrace {
+		if frame.Loc.FileName != fname {
+			continue
+		}
+		// Each implicated line of the stack trace is a diagnostic to be highlighted.
+		// The most specific stack frame in this file is highlighted as an error
+		// to draw user attention to the clostest known root cause.
+		sev := protocol.DiagnosticSeverityError
+		if seenRootCause {
+			sev = protocol.DiagnosticSeverityWarning
+		}
+		seenRootCause = true
+
+		diags = append(diags, protocol.Diagnostic{
+			Range:    rangeToProto(frame.Loc),
+			Severity: sev,
+			Code:     "RuntimeError",
+			Source:   "jsonnet",
+			Message:  rterr.Msg,
+		})
+	}
+	return diags, nil
+}
+
+// lintDiags runs the linters enabled in the configuration on the parsed file
+func (s *Server) lintDiags(uri uri.URI, resv *valueResolver, contents string, cfg *Configuration) []protocol.Diagnostic {
+	diags := linter.LintASTWithOptions(resv.Root(), resv, cfg.Diag.LinterOptions())
+	if cfg.Diag.Indentation {
+		diags = append(diags, linter.LintIndentation(resv.Root(), contents, cfg.Fmt.Indent)...)
+	}
+	if cfg.Diag.ImportOutsideWorkspace {
+		diags = append(diags, s.importOutsideWorkspaceDiags(uri, resv.Root())...)
+	}
+	return diags
+}
+
+// processFileUpdateFn publishes diagnostics for the file. The trigger is the reason for the
+// update (EvaluateOnChange or EvaluateOnSave), and evaluation diagnostics are only produced if
+// it matches the configured `diag.evaluateOn`.
+func (s *Server) processFileUpdateFn(ctx context.Context, uri uri.URI, trigger string) overlay.UpdateFunc {
 	diags := []protocol.Diagnostic{}
 	return func(ur overlay.UpdateResult) {
 		defer func(t time.Time) { tracef("linting %s done diags in %s", uri, time.Since(t)) }(time.Now())
 		if ur.Current == nil {
 			return
 		}
+		s.invalidateVMs(uri)
+		if _, ok := ur.Current.Data.(*ParseResult); !ok {
+			// not a jsonnet document, see documentParseFn
+			return
+		}
 
+		cfg := s.fileConfig(uri)
 		if pr, _ := ur.Current.Data.(*ParseResult); pr.StaticErr() != nil {
 			// AST failed to parse, do not run lints
 			se := pr.StaticErr()
@@ -446,54 +1045,31 @@ func (s *Server) processFileUpdateFn(ctx context.Context, uri uri.URI) overlay.U
 				Message:  se.Error(),
 				Source:   "jsonnet",
 			})
-		} else if ur.Parsed != nil && s.config.Diag.Linter && ur.Current.Version == ur.Parsed.Version {
+		} else if ur.Parsed != nil && cfg.Diag.Linter && ur.Current.Version == ur.Parsed.Version {
 			// AST did parse, run linter
 			parseResult := ur.Parsed.Data.(*ParseResult)
-			resv.rootAST = parseResult.Root
-			resv.roots[resv.rootAST.Loc().FileName] = resv.rootAST
-			diags = append(diags, linter.LintAST(resv.rootAST, resv)...)
+			resv := s.newResolver(uri, parseResult.Root)
+			diags = append(diags, s.lintDiags(uri, resv, ur.Parsed.Contents, cfg)...)
 
-			// If the linter has detected no fatal errors, then evaluate the file.
-			// This is to avoid evaluations of obviously bad files, which will just
+			// If the linter has detected no fatal errors (per `diag.evaluateGate`), then evaluate
+			// the file. This is to avoid evaluations of obviously bad files, which will just
 			// burn CPU as the user is typing.
-			if !linter.HasErrors(diags) && s.config.Diag.Evaluate {
-				resv.getvm().Use(func(vm *jsonnet.VM) {
-					defer func(t time.Time) { tracef("evaluation %s done diags in %s", uri, time.Since(t)) }(time.Now())
-					_, err := vm.Evaluate(resv.rootAST)
-					rterr, ok := err.(jsonnet.RuntimeError)
-					if !ok {
-						return
-					}
-
-					// Grab the stack trace from the error, and highlight
-					// each line.
-					fname := resv.rootAST.Loc().FileName
-					seenRootCause := false
-					for _, frame := range rterr.StackTrace {
-						if frame.Loc.FileName != fname {
-							continue
-						}
-						// Each implicated line of the stack trace is a diagnostic to be highlighted.
-						// The most specific stack frame in this file is highlighted as an error
-						// to draw user attention to the clostest known root cause.
-						sev := protocol.DiagnosticSeverityError
-						if seenRootCause {
-							sev = protocol.DiagnosticSeverityWarning
-						}
-						seenRootCause = true
-
-						diags = append(diags, protocol.Diagnostic{
-							Range:    rangeToProto(frame.Loc),
-							Severity: sev,
-							Code:     "RuntimeError",
-							Source:   "jsonnet",
-							Message:  rterr.Msg,
-						})
-					}
-				})
+			if !cfg.Diag.evaluationGated(diags) && cfg.Diag.Evaluate && cfg.Diag.EvaluateOn == trigger {
+				evalDiags, err := s.evaluateDiags(ctx, resv)
+				if err != nil {
+					// a newer version of the file will publish its own diagnostics
+					tracef("discarding diagnostics for %s version %d: %v", uri, ur.Current.Version, err)
+					return
+				}
+				diags = append(diags, evalDiags...)
 			}
 		}
+		// Text based lints also run on files that do not parse
+		if cfg.Diag.Linter && cfg.Diag.MixedIndentation {
+			diags = append(diags, linter.LintMixedIndentation(ur.Current.Contents)...)
+		}
 
+		s.setPublishedDiagnostics(uri, diags)
 		_ = s.notifier.PublishDiagnostics(ctx, &protocol.PublishDiagnosticsParams{
 			URI:         uri,
 			Version:     uint32(ur.Current.Version),
@@ -502,59 +1078,34 @@ func (s *Server) processFileUpdateFn(ctx context.Context, uri uri.URI) overlay.U
 	}
 }
 
+// valueResolver is the analysis resolver for an open file, which only creates a VM if the file
+// imports something
 type valueResolver struct {
+	*analysis.RootResolver
 	rootURI uri.URI
-	rootAST ast.Node
-	// A map of filenames from node.Loc().Filename to the root AST node
-	// This is used to find the root AST node of any node.
-	stackCache map[ast.Node][]ast.Node
-	roots      map[string]ast.Node
-	getvm      func() *vmCache
-	vm         *vmCache
+	getvm   func() *vmCache
+	vm      *vmCache
 }
 
-var _ = (analysis.Resolver)(new(valueResolver))
-
 func (s *Server) NewResolver(uri uri.URI) *valueResolver {
 	root := s.getCurrentAST(uri)
 	if root == nil {
 		return nil
 	}
-	return &valueResolver{
-		rootURI:    uri,
-		rootAST:    root,
-		roots:      map[string]ast.Node{root.Loc().FileName: root},
-		stackCache: map[ast.Node][]ast.Node{},
-		getvm:      func() *vmCache { return s.getVM(uri) },
-	}
-}
-
-func (r *valueResolver) NodeAt(loc ast.Location) (node ast.Node, stack []ast.Node) {
-	stack = analysis.StackAtLoc(r.rootAST, loc)
-	if len(stack) == 0 {
-		return nil, nil
-	}
-	node = stack[len(stack)-1]
-	r.stackCache[node] = stack
-	return node, stack
+	return s.newResolver(uri, root)
 }
 
-func (r *valueResolver) Vars(from ast.Node) analysis.VarMap {
-	if from == nil || from.Loc() == nil {
-		return analysis.VarMap{}
-	}
-	root := r.roots[from.Loc().FileName]
-	if root == nil {
-		panic(fmt.Errorf("invariant: resolving var from %s where no root was imported", analysis.FmtNode(from)))
-	}
-	if stk := r.stackCache[from]; len(stk) > 0 {
-		return analysis.StackVars(stk)
+func (s *Server) newResolver(uri uri.URI, root ast.Node) *valueResolver {
+	r := &valueResolver{
+		rootURI: uri,
+		getvm:   func() *vmCache { return s.getVM(uri) },
 	}
-	stk := analysis.StackAtNode(root, from)
-	return analysis.StackVars(stk)
+	r.RootResolver = analysis.NewRootResolver(root, r.importAST)
+	r.RootResolver.SetExtVars(s.fileConfig(uri).ExtVars)
+	return r
 }
 
-func (r *valueResolver) Import(from, path string) ast.Node {
+func (r *valueResolver) importAST(from, path string) ast.Node {
 	// The reason for this dance is to only grab a VM and importer
 	// if we need to import something. This allows us to avoid thrashing the
 	// vm cache when we don't actually need a full VM to perform analysis
@@ -565,9 +1116,6 @@ func (r *valueResolver) Import(from, path string) ast.Node {
 		r.vm = r.getvm()
 	}
 	root, _ := r.vm.ImportAST(from, path)
-	if root != nil {
-		r.roots[root.Loc().FileName] = root
-	}
 	return root
 }
 
@@ -0,0 +1,383 @@
+package lsp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/carlverge/jsonnet-lsp/pkg/linter"
+	"github.com/google/go-jsonnet"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.lsp.dev/protocol"
+)
+
+// mapImporter serves file contents from memory, and panics on any path in `panics`
+type mapImporter struct {
+	files  map[string]string
+	panics map[string]bool
+}
+
+func (m *mapImporter) Import(from, path string) (jsonnet.Contents, string, error) {
+	if m.panics[path] {
+		panic("malformed import: " + path)
+	}
+	data, ok := m.files[path]
+	if !ok {
+		return jsonnet.Contents{}, "", assert.AnError
+	}
+	return jsonnet.MakeContents(data), path, nil
+}
+
+func newTestCachedImporter(real jsonnet.Importer) *cachedImporter {
+	return &cachedImporter{
+		notFound: map[[2]string]error{},
+		foundAt:  map[[2]string]string{},
+		cache:    map[string]jsonnet.Contents{},
+		real:     real,
+	}
+}
+
+func TestCachedImporterRecoversPanic(t *testing.T) {
+	imp := newTestCachedImporter(&mapImporter{panics: map[string]bool{"bad.libsonnet": true}})
+
+	require.NotPanics(t, func() {
+		_, _, err := imp.Import("main.jsonnet", "bad.libsonnet")
+		assert.Error(t, err)
+	})
+
+	// the failure is cached like any other import error
+	_, _, err := imp.Import("main.jsonnet", "bad.libsonnet")
+	assert.Error(t, err)
+}
+
+func TestVMCacheImportASTMalformed(t *testing.T) {
+	real := &mapImporter{
+		files: map[string]string{
+			"good.libsonnet":      "{a: 1}",
+			"malformed.libsonnet": "{a: ",
+		},
+		panics: map[string]bool{"bad.libsonnet": true},
+	}
+	vm := jsonnet.MakeVM()
+	vm.Importer(newTestCachedImporter(real))
+	cache := &vmCache{vm: vm}
+
+	for _, path := range []string{"malformed.libsonnet", "bad.libsonnet"} {
+		require.NotPanics(t, func() {
+			node, found := cache.ImportAST("main.jsonnet", path)
+			assert.Nil(t, node, path)
+			assert.Empty(t, found, path)
+		})
+	}
+
+	node, _ := cache.ImportAST("main.jsonnet", "good.libsonnet")
+	assert.NotNil(t, node)
+}
+
+func TestParseJPathDirectives(t *testing.T) {
+	src := `// A library entrypoint
+//jsonnet-lsp:jpath ../shared/lib
+# jsonnet-lsp:jpath /abs/lib
+
+// jsonnet-lsp:jpathnot a directive
+local x = 1;
+//jsonnet-lsp:jpath ignored/after/code
+x
+`
+	assert.Equal(t, []string{"../shared/lib", "/abs/lib"}, parseJPathDirectives(src))
+}
+
+func TestImportJPathDirective(t *testing.T) {
+	s := newTestServer(t, map[string]string{
+		"app/main.jsonnet":          "//jsonnet-lsp:jpath ../shared/lib\nimport 'util.libsonnet'\n",
+		"shared/lib/util.libsonnet": "{util: true}",
+		"other/main.jsonnet":        "import 'util.libsonnet'\n",
+		"alt/util.libsonnet":        "{alt: true}",
+	})
+	root := s.rootURI.Filename()
+
+	_, foundAt, err := s.importer.Import(filepath.Join(root, "app/main.jsonnet"), "util.libsonnet")
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(root, "shared/lib/util.libsonnet"), foundAt)
+
+	// the directive only applies to the file that declares it
+	_, _, err = s.importer.Import(filepath.Join(root, "other/main.jsonnet"), "util.libsonnet")
+	assert.Error(t, err)
+
+	// the directives of open files are the ones of their current contents
+	s.open(t, "app/main.jsonnet", "//jsonnet-lsp:jpath ../alt\nimport 'util.libsonnet'\n")
+	_, foundAt, err = s.importer.Import(filepath.Join(root, "app/main.jsonnet"), "util.libsonnet")
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(root, "alt/util.libsonnet"), foundAt)
+}
+
+func TestImportProjectRootMarker(t *testing.T) {
+	s := newTestServer(t, map[string]string{
+		"lib/util.libsonnet":         "{workspace: true}",
+		"sub/jsonnetfile.json":       "{}",
+		"sub/lib/util.libsonnet":     "{nested: true}",
+		"sub/app/main.jsonnet":       "import 'lib/util.libsonnet'",
+		"other/app/main.jsonnet":     "import 'lib/util.libsonnet'",
+		"other/lib/unused.libsonnet": "{}",
+	})
+	s.importer.SetRootMarkers(defaultConfiguration().RootMarkers)
+	root := s.rootURI.Filename()
+
+	_, foundAt, err := s.importer.Import(filepath.Join(root, "sub/app/main.jsonnet"), "lib/util.libsonnet")
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(root, "sub/lib/util.libsonnet"), foundAt)
+
+	// without a marker, imports resolve from the workspace root
+	_, foundAt, err = s.importer.Import(filepath.Join(root, "other/app/main.jsonnet"), "lib/util.libsonnet")
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(root, "lib/util.libsonnet"), foundAt)
+}
+
+func TestImportAbsoluteRoots(t *testing.T) {
+	s := newTestServer(t, map[string]string{
+		"third_party/lib/foo.libsonnet":      "{}",
+		"third_party/lib/nested/x.libsonnet": "{}",
+		"nested/x.libsonnet":                 "{}",
+		"main.jsonnet":                       "import '/lib/foo.libsonnet'\n",
+	})
+	root := s.rootURI.Filename()
+	s.importer.SetImportRoots(map[string]string{
+		"/lib":        "third_party/lib",
+		"/lib/nested": "nested",
+	})
+	main := filepath.Join(root, "main.jsonnet")
+
+	_, foundAt, err := s.importer.Import(main, "/lib/foo.libsonnet")
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(root, "third_party/lib/foo.libsonnet"), foundAt)
+
+	// the longest prefix wins
+	_, foundAt, err = s.importer.Import(main, "/lib/nested/x.libsonnet")
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(root, "nested/x.libsonnet"), foundAt)
+
+	// prefixes only match whole path components
+	_, _, err = s.importer.Import(main, "/library/foo.libsonnet")
+	assert.Error(t, err)
+}
+
+func TestImportSearchOrder(t *testing.T) {
+	s := newTestServer(t, map[string]string{
+		"app/main.jsonnet":     "import 'a.libsonnet'\n",
+		"app/a.libsonnet":      "{}",
+		"a.libsonnet":          "{}",
+		"b.libsonnet":          "{}",
+		"vendor/b.libsonnet":   "{}",
+		"vendor/c.libsonnet":   "{}",
+		"jpath/c.libsonnet":    "{}",
+		"jpath/d.libsonnet":    "{}",
+		"sub/jsonnetfile.json": "{}",
+		"sub/app/main.jsonnet": "//jsonnet-lsp:jpath ../../jpath\nimport 'a.libsonnet'\n",
+		"sub/app/e.libsonnet":  "{}",
+		"sub/e.libsonnet":      "{}",
+		"sub/d.libsonnet":      "{}",
+	})
+	root := s.rootURI.Filename()
+	s.importer.paths = []string{"vendor"}
+	s.importer.SetRootMarkers(defaultConfiguration().RootMarkers)
+	// duplicates of earlier directories keep their first position
+	s.importer.SetJPaths([]string{"jpath", root, filepath.Join(root, "vendor")})
+
+	main := filepath.Join(root, "app/main.jsonnet")
+	assert.Equal(t, []string{
+		filepath.Join(root, "app"),
+		root,
+		filepath.Join(root, "vendor"),
+		filepath.Join(root, "jpath"),
+	}, s.importer.SearchDirs(main))
+
+	nested := filepath.Join(root, "sub/app/main.jsonnet")
+	assert.Equal(t, []string{
+		filepath.Join(root, "sub/app"),
+		filepath.Join(root, "sub"),
+		root,
+		filepath.Join(root, "jpath"),
+		filepath.Join(root, "vendor"),
+	}, s.importer.SearchDirs(nested))
+
+	cases := []struct {
+		From, Path, FoundAt string
+	}{
+		{main, "a.libsonnet", "app/a.libsonnet"},
+		{main, "b.libsonnet", "b.libsonnet"},
+		{main, "c.libsonnet", "vendor/c.libsonnet"},
+		{main, "d.libsonnet", "jpath/d.libsonnet"},
+		{nested, "e.libsonnet", "sub/app/e.libsonnet"},
+		{nested, "d.libsonnet", "sub/d.libsonnet"},
+		{nested, "c.libsonnet", "jpath/c.libsonnet"},
+	}
+	for _, c := range cases {
+		_, foundAt, err := s.importer.Import(c.From, c.Path)
+		require.NoError(t, err)
+		assert.Equal(t, filepath.Join(root, c.FoundAt), foundAt, "importing %s from %s", c.Path, c.From)
+	}
+}
+
+func TestImportOutsideWorkspaceDiags(t *testing.T) {
+	outside := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(outside, "ext.libsonnet"), []byte("{}"), 0o644))
+	s := newTestServer(t, map[string]string{"lib.libsonnet": "{}"})
+	rel, err := filepath.Rel(s.rootURI.Filename(), filepath.Join(outside, "ext.libsonnet"))
+	require.NoError(t, err)
+
+	u := s.open(t, "main.jsonnet", "[\n  import 'lib.libsonnet',\n  importstr '"+rel+"',\n]\n")
+	diags := s.importOutsideWorkspaceDiags(u, s.getCurrentAST(u))
+	require.Len(t, diags, 1)
+	assert.Equal(t, uint32(2), diags[0].Range.Start.Line)
+	assert.Equal(t, linter.ImportOutsideWorkspace, diags[0].Code)
+
+	// found through a configured jpath or a directive is intended
+	s.importer.SetJPaths([]string{outside})
+	u = s.open(t, "main.jsonnet", "import 'ext.libsonnet'\n")
+	assert.Empty(t, s.importOutsideWorkspaceDiags(u, s.getCurrentAST(u)))
+
+	s.importer.SetJPaths(nil)
+	u = s.open(t, "main.jsonnet", "//jsonnet-lsp:jpath "+outside+"\nimport 'ext.libsonnet'\n")
+	assert.Empty(t, s.importOutsideWorkspaceDiags(u, s.getCurrentAST(u)))
+}
+
+func TestDoctor(t *testing.T) {
+	root := t.TempDir()
+	for name, contents := range map[string]string{
+		"WORKSPACE":            "",
+		"vendor/lib.libsonnet": "{}",
+		"app/main.jsonnet":     "local lib = import 'lib.libsonnet';\nlocal data = importstr 'missing.txt';\nlocal abs = import '/company/lib.libsonnet';\n[lib, data, abs]\n",
+	} {
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(root, name)), 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(root, name), []byte(contents), 0o644))
+	}
+
+	out := &bytes.Buffer{}
+	err := Doctor(out, DoctorParams{JPaths: []string{"vendor"}, ImportRoots: map[string]string{"/company": "vendor"}, File: filepath.Join(root, "app/main.jsonnet")})
+	assert.EqualError(t, err, "1 imports not found")
+	assert.Contains(t, out.String(), "root:         "+root+"\n")
+	assert.Contains(t, out.String(), "jpaths:       vendor\n")
+	assert.Contains(t, out.String(), "import roots: /company=vendor\n")
+	assert.Contains(t, out.String(), "parse:        ok\n")
+	assert.Contains(t, out.String(), "  1: 'lib.libsonnet' -> "+filepath.Join(root, "vendor/lib.libsonnet")+"\n")
+	assert.Contains(t, out.String(), "  2: 'missing.txt' not found")
+	assert.Contains(t, out.String(), "  3: '/company/lib.libsonnet' -> "+filepath.Join(root, "vendor/lib.libsonnet")+"\n")
+}
+
+func TestLint(t *testing.T) {
+	root := t.TempDir()
+	for name, contents := range map[string]string{
+		"WORKSPACE":            "",
+		"vendor/lib.libsonnet": "{}",
+		"app/main.jsonnet":     "local lib = import 'lib.libsonnet';\nlocal unused = 1;\nlib\n",
+		"app/bad.jsonnet":      "{a: }\n",
+	} {
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(root, name)), 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(root, name), []byte(contents), 0o644))
+	}
+	main := filepath.Join(root, "app/main.jsonnet")
+
+	out := &bytes.Buffer{}
+	require.NoError(t, Lint(out, LintParams{JPaths: []string{"vendor"}, Files: []string{main}}))
+	assert.Equal(t, "app/main.jsonnet:2:7: warning: unused local variable 'unused' [UnusedVar]\n", out.String())
+
+	out.Reset()
+	err := Lint(out, LintParams{JPaths: []string{"vendor"}, Files: []string{main, filepath.Join(root, "app/bad.jsonnet")}, Format: LintFormatSARIF})
+	assert.EqualError(t, err, "1 errors found")
+	assert.Contains(t, out.String(), `"ruleId": "UnusedVar"`)
+	assert.Contains(t, out.String(), `"uri": "app/bad.jsonnet"`)
+
+	assert.Error(t, Lint(out, LintParams{Files: []string{main}, Format: "xml"}))
+
+	// absolute imports are mapped through the import roots
+	abs := filepath.Join(root, "app/abs.jsonnet")
+	require.NoError(t, os.WriteFile(abs, []byte("import '/company/lib.libsonnet'\n"), 0o644))
+	out.Reset()
+	require.NoError(t, Lint(out, LintParams{Files: []string{abs}}))
+	assert.Contains(t, out.String(), "import not found: '/company/lib.libsonnet'")
+	out.Reset()
+	require.NoError(t, Lint(out, LintParams{ImportRoots: map[string]string{"/company": "vendor"}, Files: []string{abs}}))
+	assert.Empty(t, out.String())
+}
+
+// diagsClient records published diagnostics
+type diagsClient struct {
+	protocol.Client
+	published chan *protocol.PublishDiagnosticsParams
+}
+
+func (c *diagsClient) PublishDiagnostics(_ context.Context, params *protocol.PublishDiagnosticsParams) error {
+	c.published <- params
+	return nil
+}
+
+func TestToggleDiagnostics(t *testing.T) {
+	s := newTestServer(t, nil)
+	client := &diagsClient{published: make(chan *protocol.PublishDiagnosticsParams, 1)}
+	s.notifier = client
+	s.config.Diag.Evaluate = false
+	u := s.open(t, "main.jsonnet", "local x = 1;\n{}\n")
+
+	toggle := func(command string) (interface{}, []protocol.Diagnostic) {
+		res, err := s.ExecuteCommand(context.Background(), &protocol.ExecuteCommandParams{Command: command})
+		require.NoError(t, err)
+		published := <-client.published
+		assert.Equal(t, u, published.URI)
+		return res, published.Diagnostics
+	}
+
+	enabled, diags := toggle("jsonnet.lsp.toggleLinter")
+	assert.Equal(t, false, enabled)
+	assert.Empty(t, diags)
+
+	enabled, diags = toggle("jsonnet.lsp.toggleLinter")
+	assert.Equal(t, true, enabled)
+	require.Len(t, diags, 1)
+	assert.Equal(t, linter.UnusedVar, diags[0].Code)
+
+	enabled, _ = toggle("jsonnet.lsp.toggleEvaluate")
+	assert.Equal(t, true, enabled)
+	assert.True(t, s.config.Diag.Evaluate)
+}
+
+func TestExportSchema(t *testing.T) {
+	s := newTestServer(t, nil)
+	u := s.open(t, "main.jsonnet", `local port = 80;
+{
+  name: 'svc',
+  port: port,
+  tags: ['a', 'b'],
+  owner: /*:string | null*/ null,
+  limits: /*:object[number]*/ {},
+  nested: {enabled: true, mixed: [1, 'a']},
+  hidden:: 1,
+  fn(x):: x,
+}
+`)
+
+	res, err := s.ExportSchema(context.Background(), &ExportSchemaParams{TextDocument: &protocol.TextDocumentIdentifier{URI: u}})
+	require.NoError(t, err)
+	data, err := json.Marshal(res)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+		"$schema": "https://json-schema.org/draft/2020-12/schema",
+		"type": "object",
+		"properties": {
+			"name": {"type": "string"},
+			"port": {"type": "number"},
+			"tags": {"type": "array", "items": {"type": "string"}},
+			"owner": {"anyOf": [{"type": "string"}, {"type": "null"}]},
+			"limits": {"type": "object", "additionalProperties": {"type": "number"}},
+			"nested": {
+				"type": "object",
+				"properties": {"enabled": {"type": "boolean"}, "mixed": {"type": "array"}},
+				"required": ["enabled", "mixed"]
+			}
+		},
+		"required": ["name", "port", "tags", "owner", "limits", "nested"]
+	}`, string(data))
+}
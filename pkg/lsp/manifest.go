@@ -0,0 +1,183 @@
+package lsp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/carlverge/jsonnet-lsp/pkg/analysis"
+	"github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/ast"
+)
+
+const (
+	// The maximum number of AST nodes in the arguments of a manifest call to preview
+	maxManifestPreviewNodes = 200
+	// Previews longer than this are truncated
+	maxManifestPreviewLines = 20
+)
+
+// constantValue converts a statically known value of only literals, arrays and objects into its
+// JSON equivalent. `budget` is the number of AST nodes left to convert.
+func constantValue(node ast.Node, resolver analysis.Resolver, budget *int) (interface{}, bool) {
+	if *budget--; *budget < 0 || node == nil {
+		return nil, false
+	}
+
+	switch n := node.(type) {
+	case *ast.LiteralNull:
+		return nil, true
+	case *ast.Var:
+		// the default of a parameter is not its value when an argument is given
+		v := resolver.Vars(n).Get(string(n.Id))
+		if v == nil || v.Node == nil || v.Param {
+			return nil, false
+		}
+		return constantValue(v.Node, resolver, budget)
+	case *ast.Local:
+		return constantValue(n.Body, resolver, budget)
+	case *ast.Array:
+		res := []interface{}{}
+		for _, elem := range n.Elements {
+			v, ok := constantValue(elem.Expr, resolver, budget)
+			if !ok {
+				return nil, false
+			}
+			res = append(res, v)
+		}
+		return res, true
+	case *ast.DesugaredObject:
+		// only the implicit `$` local is allowed, as other locals may be used by fields
+		if len(n.Asserts) > 0 || len(n.Locals) > 1 || (len(n.Locals) == 1 && n.Locals[0].Variable != "$") {
+			return nil, false
+		}
+		res := map[string]interface{}{}
+		for _, fld := range n.Fields {
+			name, ok := fld.Name.(*ast.LiteralString)
+			if !ok || fld.PlusSuper {
+				return nil, false
+			}
+			v, ok := constantValue(fld.Body, resolver, budget)
+			if !ok {
+				return nil, false
+			}
+			if fld.Hide != ast.ObjectFieldHidden {
+				res[name.Value] = v
+			}
+		}
+		return res, true
+	default:
+		// strings, numbers and booleans, including folded constants
+		if refersToParam(n, resolver, budget) {
+			return nil, false
+		}
+		val := analysis.NodeToValue(n, resolver)
+		switch {
+		case val.StringValue != nil:
+			return *val.StringValue, true
+		case val.NumberValue != nil:
+			return *val.NumberValue, true
+		case val.BooleanValue != nil:
+			return *val.BooleanValue, true
+		}
+		return nil, false
+	}
+}
+
+// refersToParam checks if the expression uses a function parameter, directly or through locals,
+// as constants are folded with the defaults of parameters
+func refersToParam(node ast.Node, resolver analysis.Resolver, budget *int) bool {
+	found := false
+	analysis.WalkStack(node, func(n ast.Node, _ []ast.Node) bool {
+		if *budget--; found || *budget < 0 {
+			found = true
+			return false
+		}
+		v, ok := n.(*ast.Var)
+		if !ok {
+			return true
+		}
+		bind := resolver.Vars(v).Get(string(v.Id))
+		if bind != nil && (bind.Param || (bind.Node != nil && refersToParam(bind.Node, resolver, budget))) {
+			found = true
+		}
+		return !found
+	})
+	return found
+}
+
+// manifestPreview evaluates a `std.manifest*` call in the stack if the hovered node is the
+// function being called, and all of its arguments are constant. The call is evaluated with the VM
+// of the file, limited by the evaluation timeout.
+func (s *Server) manifestPreview(ctx context.Context, resolver *valueResolver, stack []ast.Node) (string, bool) {
+	var call *ast.Apply
+	for i := len(stack) - 1; i >= 0; i-- {
+		if app, ok := stack[i].(*ast.Apply); ok {
+			if i+1 < len(stack) && stack[i+1] == app.Target {
+				call = app
+			}
+			break
+		}
+	}
+	if call == nil {
+		return "", false
+	}
+
+	idx, _ := call.Target.(*ast.Index)
+	if idx == nil {
+		return "", false
+	}
+	target, _ := idx.Target.(*ast.Var)
+	name, _ := idx.Index.(*ast.LiteralString)
+	if target == nil || name == nil || target.Id != "std" || !strings.HasPrefix(name.Value, "manifest") {
+		return "", false
+	}
+
+	budget := maxManifestPreviewNodes
+	args := []string{}
+	toJSON := func(node ast.Node) (string, bool) {
+		v, ok := constantValue(node, resolver, &budget)
+		if !ok {
+			return "", false
+		}
+		data, err := json.Marshal(v)
+		return string(data), err == nil
+	}
+	for _, arg := range call.Arguments.Positional {
+		data, ok := toJSON(arg.Expr)
+		if !ok {
+			return "", false
+		}
+		args = append(args, data)
+	}
+	for _, arg := range call.Arguments.Named {
+		data, ok := toJSON(arg.Arg)
+		if !ok {
+			return "", false
+		}
+		args = append(args, fmt.Sprintf("%s=%s", arg.Name, data))
+	}
+
+	snippet, err := jsonnet.SnippetToAST("manifest-preview", fmt.Sprintf("std.%s(%s)", name.Value, strings.Join(args, ", ")))
+	if err != nil {
+		return "", false
+	}
+	ctx, cancel := s.evaluateContext(ctx)
+	defer cancel()
+	res, err := s.evaluate(ctx, resolver.getvm(), snippet)
+	if err != nil {
+		return "", false
+	}
+	// the manifest functions return strings, which are evaluated to JSON strings
+	out := ""
+	if err := json.Unmarshal([]byte(res), &out); err != nil {
+		return "", false
+	}
+
+	lines := strings.Split(strings.TrimRight(out, "\n"), "\n")
+	if len(lines) > maxManifestPreviewLines {
+		lines = append(lines[:maxManifestPreviewLines], "...")
+	}
+	return strings.Join(lines, "\n"), true
+}
@@ -0,0 +1,119 @@
+package lsp
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/carlverge/jsonnet-lsp/pkg/analysis"
+	"github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/ast"
+)
+
+// ObjectPadding sets the spaces inside the braces of objects separately for objects on a single
+// line like `{ a: 1 }` and objects spanning multiple lines, where it applies to the fields on the
+// same line as a brace like `{ a: 1,\n  b: 2 }`. The formatter only has a single setting for both.
+type ObjectPadding struct {
+	Inline    bool
+	Multiline bool
+}
+
+// ObjectPadding returns the object padding, where unset options follow `padObjects`
+func (c *Configuration) ObjectPadding() ObjectPadding {
+	if c == nil {
+		return ObjectPadding{Inline: true, Multiline: true}
+	}
+	res := ObjectPadding{Inline: c.Fmt.PadObjects, Multiline: c.Fmt.PadObjects}
+	if c.Fmt.PadInlineObjects != nil {
+		res.Inline = *c.Fmt.PadInlineObjects
+	}
+	if c.Fmt.PadMultilineObjects != nil {
+		res.Multiline = *c.Fmt.PadMultilineObjects
+	}
+	return res
+}
+
+// padObjects sets the padding inside the braces of the objects in formatted contents. Empty
+// objects and braces next to a comment or a line break are left alone. Only literal objects are
+// changed, object comprehensions keep the padding of the formatter.
+func padObjects(filename, contents string, padding ObjectPadding) string {
+	root, err := jsonnet.SnippetToAST(filename, contents)
+	if err != nil {
+		return contents
+	}
+	lines := [][]rune{}
+	for _, line := range strings.Split(contents, "\n") {
+		lines = append(lines, []rune(line))
+	}
+
+	// replace the whitespace between the 0-based columns `begin` and `end` of the line
+	type edit struct {
+		line, begin, end int
+		pad              bool
+	}
+	edits := []edit{}
+	validLoc := func(loc ast.Location) bool {
+		return loc.Line >= 1 && loc.Line <= len(lines) && loc.Column >= 1 && loc.Column <= len(lines[loc.Line-1])+1
+	}
+	analysis.WalkStack(root, func(n ast.Node, _ []ast.Node) bool {
+		obj, ok := n.(*ast.DesugaredObject)
+		if !ok || !validLoc(obj.LocRange.Begin) || !validLoc(obj.LocRange.End) {
+			return true
+		}
+		begin, end := obj.LocRange.Begin, obj.LocRange.End
+		pad := padding.Inline
+		if begin.Line != end.Line {
+			pad = padding.Multiline
+		}
+
+		// after the opening brace
+		line := lines[begin.Line-1]
+		open := begin.Column - 1
+		if open >= len(line) || line[open] != '{' {
+			return true
+		}
+		next := open + 1
+		for next < len(line) && (line[next] == ' ' || line[next] == '\t') {
+			next++
+		}
+		if next < len(line) && !strings.ContainsRune("}/#", line[next]) {
+			edits = append(edits, edit{line: begin.Line - 1, begin: open + 1, end: next, pad: pad})
+		}
+
+		// before the closing brace
+		line = lines[end.Line-1]
+		close := end.Column - 2
+		if close < 0 || line[close] != '}' {
+			return true
+		}
+		prev := close
+		for prev > 0 && (line[prev-1] == ' ' || line[prev-1] == '\t') {
+			prev--
+		}
+		// the brace is on its own line, ends an empty object or follows a comment
+		if prev == 0 || line[prev-1] == '{' || strings.HasSuffix(string(line[:prev]), "*/") {
+			return true
+		}
+		edits = append(edits, edit{line: end.Line - 1, begin: prev, end: close, pad: pad})
+		return true
+	})
+
+	// apply the edits from the end, so that the columns of the others stay valid
+	sort.Slice(edits, func(i, j int) bool {
+		return edits[i].line < edits[j].line || (edits[i].line == edits[j].line && edits[i].begin < edits[j].begin)
+	})
+	for i := len(edits) - 1; i >= 0; i-- {
+		e := edits[i]
+		space := []rune{}
+		if e.pad {
+			space = []rune{' '}
+		}
+		line := lines[e.line]
+		lines[e.line] = append(append(append([]rune{}, line[:e.begin]...), space...), line[e.end:]...)
+	}
+
+	res := make([]string, len(lines))
+	for i, line := range lines {
+		res[i] = string(line)
+	}
+	return strings.Join(res, "\n")
+}
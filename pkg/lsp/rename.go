@@ -0,0 +1,366 @@
+package lsp
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/carlverge/jsonnet-lsp/pkg/analysis"
+	"github.com/google/go-jsonnet/ast"
+	"go.lsp.dev/protocol"
+	"go.lsp.dev/uri"
+)
+
+// fieldBody returns the body of the field `name` of the object
+func fieldBody(obj *ast.DesugaredObject, name string) ast.Node {
+	for _, fld := range obj.Fields {
+		if fn, ok := fld.Name.(*ast.LiteralString); ok && fn.Value == name {
+			return fld.Body
+		}
+	}
+	return nil
+}
+
+// definingObject finds the object in `root` whose field `name` is defined by `def`
+func definingObject(root ast.Node, name string, def ast.Node) *ast.DesugaredObject {
+	var res *ast.DesugaredObject
+	analysis.WalkStack(root, func(n ast.Node, _ []ast.Node) bool {
+		if obj, ok := n.(*ast.DesugaredObject); ok && res == nil && sameDefinition(fieldBody(obj, name), def) {
+			res = obj
+		}
+		return res == nil
+	})
+	return res
+}
+
+// baseDefiningObject follows overrides of the field in objects merged onto another, like
+// `base + {name: ...}`, back to the object in the file defining the field first
+func baseDefiningObject(root ast.Node, resolver analysis.Resolver, obj *ast.DesugaredObject, name string) *ast.DesugaredObject {
+	for depth := 0; depth < 10; depth++ {
+		var base *ast.DesugaredObject
+		analysis.WalkStack(root, func(n ast.Node, _ []ast.Node) bool {
+			bin, ok := n.(*ast.Binary)
+			if !ok || bin.Op != ast.BopPlus || bin.Right != obj {
+				return base == nil
+			}
+			if lhs := analysis.NodeToValue(bin.Left, resolver); lhs.Object != nil && lhs.Object.FieldMap[name] != nil {
+				base = definingObject(root, name, lhs.Object.FieldMap[name].Node)
+			}
+			return false
+		})
+		if base == nil || base == obj {
+			return obj
+		}
+		obj = base
+	}
+	return obj
+}
+
+// renameFieldAt finds the field name under the cursor, either in its definition or in a dotted
+// access, and the object in the file defining it
+func renameFieldAt(pos ast.Location, root ast.Node, node ast.Node, stack []ast.Node, resolver analysis.Resolver) (*ast.DesugaredObject, string, ast.LocationRange, error) {
+	switch n := node.(type) {
+	case *ast.DesugaredObject:
+		fld := analysis.FieldNameAt(n, pos)
+		if fld == nil {
+			break
+		}
+		name, rng, ok := identFieldName(fld)
+		if !ok || !analysis.LocInRange(rng, pos) {
+			return nil, "", rng, fmt.Errorf("only fields with identifier names can be renamed")
+		}
+		return n, name, rng, nil
+	case *ast.Index:
+		name, rng, ok := dottedIndexName(n)
+		if !ok || !analysis.LocInRange(rng, pos) {
+			break
+		}
+		if obj := selfReferencedObject(n, stack); obj != nil && fieldBody(obj, name) != nil {
+			return obj, name, rng, nil
+		}
+		target := analysis.NodeToValue(n.Target, resolver)
+		if target.Object == nil || target.Object.FieldMap[name] == nil {
+			return nil, "", rng, fmt.Errorf("cannot resolve the definition of field '%s'", name)
+		}
+		if obj := definingObject(root, name, target.Object.FieldMap[name].Node); obj != nil {
+			return obj, name, rng, nil
+		}
+		return nil, "", rng, fmt.Errorf("field '%s' is not defined in this file", name)
+	}
+	return nil, "", ast.LocationRange{}, fmt.Errorf("no field at position")
+}
+
+// fieldRenameRanges finds the ranges of every reference to the field `name` of `obj` in the file:
+// its definition, overrides of it in objects merged onto `obj`, and dotted accesses that resolve
+// to any of these. Returns an error if the field is referenced in a way that cannot be renamed,
+// like `obj['name']` or `std.objectHas(obj, 'name')`, if it is exported by the file, or if
+// `newName` is already a field of the objects, so that a rename never breaks the file.
+func fieldRenameRanges(root ast.Node, resolver analysis.Resolver, obj *ast.DesugaredObject, name, newName string) ([]ast.LocationRange, error) {
+	def := fieldBody(obj, name)
+	objs := []*ast.DesugaredObject{obj}
+	defs := []ast.Node{def}
+	var err error
+
+	// overrides of the field, `obj + {name: ...}`
+	analysis.WalkStack(root, func(n ast.Node, _ []ast.Node) bool {
+		bin, ok := n.(*ast.Binary)
+		if !ok || bin.Op != ast.BopPlus {
+			return true
+		}
+		rhs, ok := bin.Right.(*ast.DesugaredObject)
+		if !ok || rhs == obj {
+			return true
+		}
+		override := fieldBody(rhs, name)
+		if override == nil {
+			return true
+		}
+		lhs := analysis.NodeToValue(bin.Left, resolver)
+		if lhs.Object == nil || lhs.Object.FieldMap[name] == nil || !sameDefinition(lhs.Object.FieldMap[name].Node, def) {
+			return true
+		}
+		objs = append(objs, rhs)
+		defs = append(defs, override)
+		return true
+	})
+
+	isDef := func(n ast.Node) bool {
+		for _, d := range defs {
+			if sameDefinition(n, d) {
+				return true
+			}
+		}
+		return false
+	}
+	isObj := func(o *ast.DesugaredObject) bool {
+		for _, obj := range objs {
+			if o == obj {
+				return true
+			}
+		}
+		return false
+	}
+
+	// the objects of the file are visible to the files importing it, which are not searched
+	if rootVal := analysis.NodeToValue(root, resolver); rootVal.Object != nil && rootVal.Object.FieldMap[name] != nil && isDef(rootVal.Object.FieldMap[name].Node) {
+		return nil, fmt.Errorf("field '%s' is exported by the file, and may be used by the files importing it", name)
+	}
+	if err := checkRenameConflicts(root, resolver, objs, isDef, name, newName); err != nil {
+		return nil, err
+	}
+	// an object with an unknown field may be the object defining it
+	mayBeDef := func(target *analysis.Value) bool {
+		if target.Object == nil || target.Object.FieldMap[name] == nil {
+			return target.Object == nil || !target.Object.AllFieldsKnown
+		}
+		return isDef(target.Object.FieldMap[name].Node)
+	}
+
+	res := []ast.LocationRange{}
+	for _, o := range objs {
+		for i := range o.Fields {
+			fn, ok := o.Fields[i].Name.(*ast.LiteralString)
+			if !ok || fn.Value != name {
+				continue
+			}
+			_, rng, ok := identFieldName(&o.Fields[i])
+			if !ok {
+				return nil, fmt.Errorf("field '%s' is defined with a string name", name)
+			}
+			res = append(res, rng)
+		}
+	}
+
+	analysis.WalkStack(root, func(n ast.Node, stack []ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.Index:
+			idxName, rng, dotted := dottedIndexName(n)
+			if dotted && idxName != name {
+				return true
+			}
+			if selfObj := selfReferencedObject(n, stack); dotted && selfObj != nil {
+				switch {
+				case isObj(selfObj) || inheritsField(selfObj, stack, resolver, name, isDef):
+					res = append(res, rng)
+				case fieldBody(selfObj, name) == nil:
+					// the field may be provided by an object this one is merged onto elsewhere
+					err = fmt.Errorf("field '%s' may be inherited at %s", name, n.LocRange.String())
+				}
+				return err == nil
+			}
+			key := analysis.NodeToValue(n.Index, resolver)
+			if key.StringValue == nil || *key.StringValue != name {
+				return true
+			}
+			target := analysis.NodeToValue(n.Target, resolver)
+			if !dotted && mayBeDef(target) {
+				err = fmt.Errorf("field '%s' may be accessed with a computed or string index at %s", name, n.LocRange.String())
+				return false
+			}
+			if dotted && target.Object != nil && target.Object.FieldMap[name] != nil && isDef(target.Object.FieldMap[name].Node) {
+				res = append(res, rng)
+			}
+		case *ast.Apply:
+			// stdlib functions taking field names, like `std.objectHas(obj, 'name')` or `'name' in obj`
+			if !isStdlibCall(n, resolver) {
+				return true
+			}
+			byString, objArg := false, false
+			for _, arg := range callArgs(n) {
+				val := analysis.NodeToValue(arg, resolver)
+				if val.StringValue != nil && *val.StringValue == name {
+					byString = true
+				} else if val.Type == analysis.ObjectType || val.Type == analysis.AnyType {
+					objArg = objArg || mayBeDef(val)
+				}
+			}
+			if byString && objArg {
+				err = fmt.Errorf("field '%s' may be referenced by string at %s", name, n.LocRange.String())
+				return false
+			}
+		case *ast.SuperIndex:
+			// `super.name` is only located at the `super` keyword, so the name cannot be edited
+			key := analysis.NodeToValue(n.Index, resolver)
+			if key.StringValue == nil || *key.StringValue != name {
+				return true
+			}
+			sup := resolver.Vars(n).Get("super")
+			if sup == nil || sup.Node == nil {
+				err = fmt.Errorf("field '%s' may be accessed through super at %s", name, n.LocRange.String())
+				return false
+			}
+			if val := analysis.NodeToValue(sup.Node, resolver); val.Object != nil && val.Object.FieldMap[name] != nil && isDef(val.Object.FieldMap[name].Node) {
+				err = fmt.Errorf("field '%s' is accessed through super at %s", name, n.LocRange.String())
+				return false
+			}
+		}
+		return err == nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// checkRenameConflicts returns an error if `newName` is already a field of the objects being
+// renamed, of objects merged onto them, or of objects they are merged onto, as the renamed field
+// would then replace or be replaced by it
+func checkRenameConflicts(root ast.Node, resolver analysis.Resolver, objs []*ast.DesugaredObject, isDef func(ast.Node) bool, name, newName string) error {
+	conflict := fmt.Errorf("field '%s' already exists", newName)
+	for _, o := range objs {
+		if fieldBody(o, newName) != nil {
+			return conflict
+		}
+	}
+	var err error
+	analysis.WalkStack(root, func(n ast.Node, _ []ast.Node) bool {
+		bin, ok := n.(*ast.Binary)
+		if !ok || bin.Op != ast.BopPlus {
+			return true
+		}
+		lhs := analysis.NodeToValue(bin.Left, resolver)
+		rhs := analysis.NodeToValue(bin.Right, resolver)
+		if lhs.Object == nil || rhs.Object == nil {
+			return true
+		}
+		lhsDefines := lhs.Object.FieldMap[name] != nil && isDef(lhs.Object.FieldMap[name].Node)
+		rhsDefines := rhs.Object.FieldMap[name] != nil && isDef(rhs.Object.FieldMap[name].Node)
+		if (lhsDefines && rhs.Object.FieldMap[newName] != nil) || (rhsDefines && lhs.Object.FieldMap[newName] != nil) {
+			err = conflict
+		}
+		return err == nil
+	})
+	return err
+}
+
+// isStdlibCall checks if the call is to a function of the standard library, either through `std`
+// or the desugared `$std`
+func isStdlibCall(call *ast.Apply, resolver analysis.Resolver) bool {
+	idx, ok := call.Target.(*ast.Index)
+	if !ok {
+		return false
+	}
+	if v, ok := idx.Target.(*ast.Var); ok && v.Id == "$std" {
+		return true
+	}
+	return analysis.NodeToValue(idx.Target, resolver) == analysis.StdLibValue
+}
+
+// callArgs returns the positional and named arguments of the call
+func callArgs(call *ast.Apply) []ast.Node {
+	res := []ast.Node{}
+	for _, arg := range call.Arguments.Positional {
+		res = append(res, arg.Expr)
+	}
+	for _, arg := range call.Arguments.Named {
+		res = append(res, arg.Arg)
+	}
+	return res
+}
+
+// inheritsField checks if `obj` is merged onto an object defining the field, like `base + obj`,
+// and does not override it
+func inheritsField(obj *ast.DesugaredObject, stack []ast.Node, resolver analysis.Resolver, name string, isDef func(ast.Node) bool) bool {
+	if fieldBody(obj, name) != nil {
+		return false
+	}
+	for i := len(stack) - 1; i > 0; i-- {
+		if stack[i] != obj {
+			continue
+		}
+		bin, ok := stack[i-1].(*ast.Binary)
+		if !ok || bin.Op != ast.BopPlus || bin.Right != obj {
+			return false
+		}
+		lhs := analysis.NodeToValue(bin.Left, resolver)
+		return lhs.Object != nil && lhs.Object.FieldMap[name] != nil && isDef(lhs.Object.FieldMap[name].Node)
+	}
+	return false
+}
+
+func (s *Server) PrepareRename(ctx context.Context, params *protocol.PrepareRenameParams) (*protocol.Range, error) {
+	resolver := s.NewResolver(params.TextDocument.URI)
+	if resolver == nil {
+		return nil, fmt.Errorf("cannot parse file '%s'", params.TextDocument.URI.Filename())
+	}
+
+	pos := protoToPos(params.Position)
+	node, stack := resolver.NodeAt(pos)
+	_, _, rng, err := renameFieldAt(pos, resolver.Root(), node, stack, resolver)
+	if err != nil {
+		return nil, err
+	}
+	res := rangeToProto(rng)
+	return &res, nil
+}
+
+// Rename renames object fields. Only the current file is searched for references, so fields of
+// the object the file evaluates to are not renamed.
+func (s *Server) Rename(ctx context.Context, params *protocol.RenameParams) (*protocol.WorkspaceEdit, error) {
+	resolver := s.NewResolver(params.TextDocument.URI)
+	if resolver == nil {
+		return nil, fmt.Errorf("cannot parse file '%s'", params.TextDocument.URI.Filename())
+	}
+	if analysis.SafeIdent(params.NewName) != params.NewName {
+		return nil, fmt.Errorf("'%s' is not a valid field name", params.NewName)
+	}
+
+	pos := protoToPos(params.Position)
+	node, stack := resolver.NodeAt(pos)
+	obj, name, _, err := renameFieldAt(pos, resolver.Root(), node, stack, resolver)
+	if err != nil {
+		return nil, err
+	}
+	obj = baseDefiningObject(resolver.Root(), resolver, obj, name)
+	ranges, err := fieldRenameRanges(resolver.Root(), resolver, obj, name, params.NewName)
+	if err != nil {
+		return nil, err
+	}
+
+	edits := []protocol.TextEdit{}
+	for _, rng := range ranges {
+		edits = append(edits, protocol.TextEdit{Range: rangeToProto(rng), NewText: params.NewName})
+	}
+	return &protocol.WorkspaceEdit{
+		Changes: map[uri.URI][]protocol.TextEdit{params.TextDocument.URI: edits},
+	}, nil
+}
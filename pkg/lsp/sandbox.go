@@ -0,0 +1,45 @@
+package lsp
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/go-jsonnet"
+)
+
+// sandboxImporter resolves imports like the OverlayImporter, but never reads files outside of the
+// workspace. Evaluating an untrusted file could otherwise read any file the editor can, through
+// an absolute import or a jpath directive.
+type sandboxImporter struct {
+	real *OverlayImporter
+}
+
+func (imp *sandboxImporter) Import(from, path string) (jsonnet.Contents, string, error) {
+	candidates := imp.real.Candidates(from, path)
+	for _, candidate := range candidates {
+		if !imp.inWorkspace(candidate.Filename()) {
+			tracef("sandbox refused import of '%s' from '%s'", candidate.Filename(), from)
+			continue
+		}
+		data, err := imp.real.readURI(candidate)
+		if err == nil {
+			return jsonnet.MakeContentsRaw(data), candidate.Filename(), nil
+		}
+	}
+	return jsonnet.Contents{}, "", fmt.Errorf("path '%s' not found inside the workspace (evaluation is sandboxed)", path)
+}
+
+// inWorkspace returns true if the file is inside the workspace root, following symlinks of files
+// on disk so that a link inside the workspace cannot point outside of it
+func (imp *sandboxImporter) inWorkspace(file string) bool {
+	root := imp.real.rootURI.Filename()
+	if resolved, err := filepath.EvalSymlinks(file); err == nil {
+		file = resolved
+		if r, err := filepath.EvalSymlinks(root); err == nil {
+			root = r
+		}
+	}
+	rel, err := filepath.Rel(root, file)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, "../")
+}
@@ -0,0 +1,139 @@
+package lsp
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/carlverge/jsonnet-lsp/pkg/analysis"
+	"github.com/carlverge/jsonnet-lsp/pkg/typing/annotation"
+	"github.com/google/go-jsonnet/ast"
+	"go.lsp.dev/protocol"
+)
+
+const (
+	jsonSchemaDialect = "https://json-schema.org/draft/2020-12/schema"
+	// Nested values deeper than this are exported as any value
+	maxSchemaDepth = 10
+)
+
+type ExportSchemaParams struct {
+	TextDocument *protocol.TextDocumentIdentifier `json:"textDocument"`
+}
+
+type jsonSchema = map[string]interface{}
+
+// annotationSchema converts a `/*:type*/` annotation to a schema. Type parameters and named
+// types cannot be resolved, so they allow any value.
+func annotationSchema(hint annotation.Node) jsonSchema {
+	switch hint := hint.(type) {
+	case *annotation.StringNode:
+		return jsonSchema{"type": "string"}
+	case *annotation.NumberNode:
+		return jsonSchema{"type": "number"}
+	case *annotation.BooleanNode:
+		return jsonSchema{"type": "boolean"}
+	case *annotation.NullNode:
+		return jsonSchema{"type": "null"}
+	case *annotation.UnionNode:
+		anyOf := []jsonSchema{}
+		for _, t := range hint.Types {
+			anyOf = append(anyOf, annotationSchema(t))
+		}
+		return jsonSchema{"anyOf": anyOf}
+	case *annotation.ArrayNode:
+		res := jsonSchema{"type": "array"}
+		if hint.ElementType != nil {
+			res["items"] = annotationSchema(hint.ElementType)
+		}
+		return res
+	case *annotation.ObjectNode:
+		res := jsonSchema{"type": "object"}
+		if hint.ElementType != nil {
+			res["additionalProperties"] = annotationSchema(hint.ElementType)
+		}
+		if len(hint.Fields) > 0 {
+			props := jsonSchema{}
+			for _, fld := range hint.Fields {
+				props[fld.Name] = annotationSchema(fld.Type)
+			}
+			res["properties"] = props
+		}
+		return res
+	default:
+		return jsonSchema{}
+	}
+}
+
+// valueSchema converts the statically known shape of a value to a schema. A type annotation in
+// the comments takes precedence over the value, as `null` is commonly a placeholder for a field
+// typed by its annotation. Hidden fields and functions are not manifested, so they are left out.
+func valueSchema(v *analysis.Value, comments []string, resolver analysis.Resolver, depth int) jsonSchema {
+	if v == nil || depth > maxSchemaDepth {
+		return jsonSchema{}
+	}
+	if hint := analysis.CommentsToTypeHint(append(append([]string{}, comments...), v.Comment...)); hint != nil {
+		return annotationSchema(hint)
+	}
+
+	res := jsonSchema{}
+	switch v.Type {
+	case analysis.StringType, analysis.NumberType, analysis.BooleanType, analysis.NullType:
+		res["type"] = v.Type.String()
+	case analysis.ArrayType:
+		res["type"] = "array"
+		if items := arraySchema(v, resolver, depth); items != nil {
+			res["items"] = items
+		}
+	case analysis.ObjectType:
+		res["type"] = "object"
+		if v.Object == nil {
+			break
+		}
+		props := jsonSchema{}
+		required := []string{}
+		for _, fld := range v.Object.Fields {
+			if fld.Hidden {
+				continue
+			}
+			fldVal := analysis.FieldToValue(&fld, resolver)
+			if fldVal.Type == analysis.FunctionType {
+				continue
+			}
+			props[fld.Name] = valueSchema(fldVal, fld.Comment, resolver, depth+1)
+			required = append(required, fld.Name)
+		}
+		res["properties"] = props
+		res["required"] = required
+	}
+	return res
+}
+
+// arraySchema returns the schema of the elements if all elements have the same schema
+func arraySchema(v *analysis.Value, resolver analysis.Resolver, depth int) jsonSchema {
+	arr, _ := v.Node.(*ast.Array)
+	if arr == nil || len(arr.Elements) == 0 {
+		return nil
+	}
+	var items jsonSchema
+	for _, elem := range arr.Elements {
+		schema := valueSchema(analysis.NodeToValue(elem.Expr, resolver), nil, resolver, depth+1)
+		if items != nil && fmt.Sprint(schema) != fmt.Sprint(items) {
+			return nil
+		}
+		items = schema
+	}
+	return items
+}
+
+// ExportSchema returns a JSON schema of the statically known shape of the document's value
+func (s *Server) ExportSchema(ctx context.Context, params *ExportSchemaParams) (interface{}, error) {
+	resolver := s.NewResolver(params.TextDocument.URI)
+	if resolver == nil {
+		return nil, fmt.Errorf("cannot parse file '%s'", params.TextDocument.URI.Filename())
+	}
+
+	_, ret := analysis.UnwindLocals(resolver.Root())
+	res := valueSchema(analysis.NodeToValue(ret, resolver), nil, resolver, 0)
+	res["$schema"] = jsonSchemaDialect
+	return res, nil
+}
@@ -0,0 +1,239 @@
+package lsp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/carlverge/jsonnet-lsp/pkg/linter"
+	"github.com/google/go-jsonnet/ast"
+	"go.lsp.dev/protocol"
+	"go.lsp.dev/uri"
+)
+
+type SortFieldsParams struct {
+	TextDocument *protocol.TextDocumentIdentifier `json:"textDocument"`
+	Position     protocol.Position                `json:"position"`
+	// GroupHidden sorts visible fields before hidden fields
+	GroupHidden bool `json:"groupHidden,omitempty"`
+}
+
+// fieldChunk is the source lines of a field, including the comments above it
+type fieldChunk struct {
+	name   string
+	hidden bool
+	lines  []string
+}
+
+// objectAtPos returns the innermost object containing the position
+func objectAtPos(stack []ast.Node) *ast.DesugaredObject {
+	for i := len(stack) - 1; i >= 0; i-- {
+		if obj, ok := stack[i].(*ast.DesugaredObject); ok {
+			return obj
+		}
+	}
+	return nil
+}
+
+// sortFieldsEdit reorders the fields of `obj` alphabetically. Each field must be on its own lines,
+// and comments on the lines above a field move with it. Locals and asserts stay in place, and only
+// the fields between them are sorted.
+func sortFieldsEdit(contents string, obj *ast.DesugaredObject, groupHidden bool) (edit *protocol.TextEdit, moved bool, err error) {
+	lines := strings.Split(contents, "\n")
+
+	type member struct {
+		rng   ast.LocationRange
+		field *ast.DesugaredObjectField
+	}
+	members := []member{}
+	for i := range obj.Fields {
+		fld := &obj.Fields[i]
+		if !fld.LocRange.IsSet() {
+			return nil, false, fmt.Errorf("cannot sort fields of a generated object")
+		}
+		members = append(members, member{rng: fld.LocRange, field: fld})
+	}
+	for _, b := range obj.Locals {
+		if b.LocRange.IsSet() {
+			members = append(members, member{rng: b.LocRange})
+		}
+	}
+	for _, a := range obj.Asserts {
+		if a.Loc() != nil && a.Loc().IsSet() {
+			members = append(members, member{rng: *a.Loc()})
+		}
+	}
+	sort.Slice(members, func(i, j int) bool {
+		return members[i].rng.Begin.Line < members[j].rng.Begin.Line ||
+			(members[i].rng.Begin.Line == members[j].rng.Begin.Line && members[i].rng.Begin.Column < members[j].rng.Begin.Column)
+	})
+
+	// Split the members into runs of fields, separated by locals and asserts
+	prevEnd := obj.LocRange.Begin.Line
+	runs := [][]fieldChunk{{}}
+	// the first line of the first chunk of each run
+	runStarts := []int{}
+	for _, m := range members {
+		if m.rng.Begin.Line <= prevEnd {
+			return nil, false, fmt.Errorf("cannot sort fields that share a line")
+		}
+		if m.field == nil {
+			runs = append(runs, []fieldChunk{})
+			prevEnd = m.rng.End.Line
+			continue
+		}
+
+		name, ok := m.field.Name.(*ast.LiteralString)
+		if !ok {
+			return nil, false, fmt.Errorf("cannot sort computed field names")
+		}
+		// the columns of the parser are byte offsets
+		endLine := lines[m.rng.End.Line-1]
+		rest := strings.TrimSpace(endLine[m.rng.End.Column-1:])
+		if strings.HasPrefix(rest, "}") || strings.HasPrefix(rest, ",}") {
+			return nil, false, fmt.Errorf("cannot sort fields that share a line with the end of the object")
+		}
+		if !strings.HasPrefix(rest, ",") {
+			// every field needs a trailing comma once it is moved
+			endLine = endLine[:m.rng.End.Column-1] + "," + endLine[m.rng.End.Column-1:]
+		}
+
+		chunk := fieldChunk{name: name.Value, hidden: m.field.Hide == ast.ObjectFieldHidden}
+		chunk.lines = append(chunk.lines, lines[prevEnd:m.rng.End.Line-1]...)
+		chunk.lines = append(chunk.lines, endLine)
+		if run := &runs[len(runs)-1]; len(*run) == 0 {
+			runStarts = append(runStarts, prevEnd+1)
+		}
+		runs[len(runs)-1] = append(runs[len(runs)-1], chunk)
+		prevEnd = m.rng.End.Line
+	}
+	if prevEnd >= obj.LocRange.End.Line {
+		return nil, false, fmt.Errorf("cannot sort fields that share a line with the end of the object")
+	}
+
+	// Replace every line from the first field to the last, as locals are kept in place
+	var first, last int
+	out := []string{}
+	runIdx := 0
+	for _, run := range runs {
+		if len(run) == 0 {
+			continue
+		}
+		start := runStarts[runIdx]
+		runIdx++
+		if first == 0 {
+			first = start
+		} else {
+			// keep the lines between runs (locals and asserts)
+			out = append(out, lines[last:start-1]...)
+		}
+		sorted := make([]fieldChunk, len(run))
+		copy(sorted, run)
+		sort.SliceStable(sorted, func(i, j int) bool {
+			if groupHidden && sorted[i].hidden != sorted[j].hidden {
+				return !sorted[i].hidden
+			}
+			return sorted[i].name < sorted[j].name
+		})
+		for i, chunk := range sorted {
+			out = append(out, chunk.lines...)
+			moved = moved || chunk.name != run[i].name
+		}
+		last = start - 1
+		for _, chunk := range run {
+			last += len(chunk.lines)
+		}
+	}
+	if first == 0 {
+		return nil, false, fmt.Errorf("object has no fields")
+	}
+
+	return &protocol.TextEdit{
+		Range: protocol.Range{
+			Start: protocol.Position{Line: uint32(first - 1)},
+			End:   protocol.Position{Line: uint32(last - 1), Character: uint32(len(lines[last-1]))},
+		},
+		NewText: strings.Join(out, "\n"),
+	}, moved, nil
+}
+
+// SortFields sorts the fields of the object at the position alphabetically
+func (s *Server) SortFields(ctx context.Context, params *SortFieldsParams) (*protocol.WorkspaceEdit, error) {
+	resolver := s.NewResolver(params.TextDocument.URI)
+	current := s.overlay.Parsed(params.TextDocument.URI)
+	if resolver == nil || current == nil {
+		return nil, fmt.Errorf("cannot parse file '%s'", params.TextDocument.URI.Filename())
+	}
+
+	_, stack := resolver.NodeAt(protoToPos(params.Position))
+	obj := objectAtPos(stack)
+	if obj == nil {
+		return nil, fmt.Errorf("no object at position")
+	}
+
+	edit, _, err := sortFieldsEdit(current.Contents, obj, params.GroupHidden)
+	if err != nil {
+		return nil, err
+	}
+	return &protocol.WorkspaceEdit{
+		Changes: map[uri.URI][]protocol.TextEdit{params.TextDocument.URI: {*edit}},
+	}, nil
+}
+
+// quickFixes offers the fixes carried in the data of linter diagnostics, like the replacement
+// of a deprecated stdlib function
+func quickFixes(u uri.URI, diags []protocol.Diagnostic) []protocol.CodeAction {
+	res := []protocol.CodeAction{}
+	for _, diag := range diags {
+		if fmt.Sprint(diag.Code) != string(linter.Deprecated) || diag.Data == nil {
+			continue
+		}
+		// the data is decoded from json by the client, so convert it back
+		data, err := json.Marshal(diag.Data)
+		edit := protocol.TextEdit{}
+		if err != nil || json.Unmarshal(data, &edit) != nil || edit.NewText == "" {
+			continue
+		}
+		res = append(res, protocol.CodeAction{
+			Title:       fmt.Sprintf("Replace with 'std.%s'", edit.NewText),
+			Kind:        protocol.QuickFix,
+			Diagnostics: []protocol.Diagnostic{diag},
+			IsPreferred: true,
+			Edit: &protocol.WorkspaceEdit{
+				Changes: map[uri.URI][]protocol.TextEdit{u: {edit}},
+			},
+		})
+	}
+	return res
+}
+
+func (s *Server) CodeAction(ctx context.Context, params *protocol.CodeActionParams) ([]protocol.CodeAction, error) {
+	res := quickFixes(params.TextDocument.URI, params.Context.Diagnostics)
+	resolver := s.NewResolver(params.TextDocument.URI)
+	current := s.overlay.Parsed(params.TextDocument.URI)
+	if resolver == nil || current == nil {
+		return res, nil
+	}
+
+	_, stack := resolver.NodeAt(protoToPos(params.Range.Start))
+	obj := objectAtPos(stack)
+	if obj == nil || len(obj.Fields) < 2 {
+		return res, nil
+	}
+	// Only offer the action if the fields can be sorted, and are not already sorted
+	edit, moved, err := sortFieldsEdit(current.Contents, obj, false)
+	if err != nil || !moved {
+		return res, nil
+	}
+
+	res = append(res, protocol.CodeAction{
+		Title: "Sort object fields",
+		Kind:  protocol.RefactorRewrite,
+		Edit: &protocol.WorkspaceEdit{
+			Changes: map[uri.URI][]protocol.TextEdit{params.TextDocument.URI: {*edit}},
+		},
+	})
+	return res, nil
+}
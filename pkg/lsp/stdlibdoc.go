@@ -0,0 +1,84 @@
+package lsp
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/carlverge/jsonnet-lsp/pkg/analysis"
+	"github.com/google/go-jsonnet/ast"
+	"go.lsp.dev/protocol"
+	"go.lsp.dev/uri"
+)
+
+// stdlibScheme is the scheme of the generated read-only documents of stdlib functions, like
+// `jsonnet-stdlib://std/map`. The editor reads them with the `jsonnet.lsp.stdlibDocument` command.
+const stdlibScheme = "jsonnet-stdlib"
+
+type StdlibDocumentParams struct {
+	URI uri.URI `json:"uri"`
+}
+
+type StdlibDocumentResult struct {
+	Contents string `json:"contents"`
+}
+
+func stdlibDocumentURI(name string) uri.URI {
+	return uri.URI(stdlibScheme + "://std/" + name)
+}
+
+// stdlibDocument generates the document of a stdlib function: its documentation as comments,
+// followed by its signature. Returns the document and the range of the function name.
+func stdlibDocument(name string) (string, protocol.Range, bool) {
+	fn := analysis.StdLibFunctions[name]
+	if fn == nil {
+		return "", protocol.Range{}, false
+	}
+	lines := []string{}
+	for _, c := range fn.Comment {
+		for _, line := range strings.Split(c, "\n") {
+			lines = append(lines, strings.TrimRight("// "+line, " "))
+		}
+	}
+	if len(lines) > 0 {
+		lines = append(lines, "")
+	}
+	line := uint32(len(lines))
+	lines = append(lines, "std."+name+fn.String())
+	rng := protocol.Range{
+		Start: protocol.Position{Line: line, Character: uint32(len("std."))},
+		End:   protocol.Position{Line: line, Character: uint32(len("std." + name))},
+	}
+	return strings.Join(lines, "\n") + "\n", rng, true
+}
+
+// stdlibDefinition returns the location of the stdlib function accessed by `std.name` in its
+// generated document
+func stdlibDefinition(node ast.Node, resolver analysis.Resolver) []protocol.Location {
+	idx, ok := node.(*ast.Index)
+	if !ok {
+		return nil
+	}
+	name, ok := idx.Index.(*ast.LiteralString)
+	if !ok || analysis.NodeToValue(idx.Target, resolver) != analysis.StdLibValue {
+		return nil
+	}
+	_, rng, ok := stdlibDocument(name.Value)
+	if !ok {
+		return nil
+	}
+	return []protocol.Location{{URI: stdlibDocumentURI(name.Value), Range: rng}}
+}
+
+// StdlibDocument serves the generated documents of stdlib functions
+func (s *Server) StdlibDocument(ctx context.Context, params *StdlibDocumentParams) (*StdlibDocumentResult, error) {
+	name := strings.TrimPrefix(string(params.URI), stdlibScheme+"://std/")
+	if name == string(params.URI) {
+		return nil, fmt.Errorf("not a stdlib document: '%s'", params.URI)
+	}
+	contents, _, ok := stdlibDocument(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown stdlib function '%s'", name)
+	}
+	return &StdlibDocumentResult{Contents: contents}, nil
+}
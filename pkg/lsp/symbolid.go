@@ -0,0 +1,233 @@
+package lsp
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/carlverge/jsonnet-lsp/pkg/analysis"
+	"github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/ast"
+	"go.lsp.dev/protocol"
+	"go.lsp.dev/uri"
+)
+
+// Symbol identifiers are `<path>#<scope>`, for indexing tools that need to refer to the same
+// symbol across files and edits. The path is the file defining the symbol, relative to the
+// workspace root (absolute if it is outside of the workspace). The scope is the `/` separated
+// chain of bindings from the root of the file to the symbol, each one of:
+//   - `local:name` for a local
+//   - `field:name` for an object field
+//   - `param:name` for a function parameter
+//   - `arg:N` or `arg:name` for an argument of a call, like a function passed to `std.map`
+//   - `index:N` for an element of an array
+//
+// Names are escaped as URL path segments. For example the field `fmt` of the object bound to
+// the local `helpers` in `lib/util.libsonnet` is `lib/util.libsonnet#local:helpers/field:fmt`.
+// Identifiers do not change when the file is reformatted or unrelated code moves, only when a
+// binding in the chain is renamed or moved.
+
+type SymbolIDParams struct {
+	TextDocument *protocol.TextDocumentIdentifier `json:"textDocument"`
+	Position     protocol.Position                `json:"position"`
+}
+
+type SymbolIDResult struct {
+	ID string `json:"id"`
+	// Location of the definition of the symbol
+	Location protocol.Location `json:"location"`
+}
+
+// scopeSegment returns the segment of the symbol identifier for `child` bound in `parent`, or an
+// empty string if the child is not a binding of the parent
+func scopeSegment(parent, child ast.Node) string {
+	bindSegment := func(binds ast.LocalBinds) string {
+		for _, b := range binds {
+			if b.Body == child || (b.Fun != nil && b.Fun == child) {
+				return "local:" + url.PathEscape(string(b.Variable))
+			}
+		}
+		return ""
+	}
+
+	switch parent := parent.(type) {
+	case *ast.Local:
+		return bindSegment(parent.Binds)
+	case *ast.DesugaredObject:
+		if seg := bindSegment(parent.Locals); seg != "" {
+			return seg
+		}
+		for i := range parent.Fields {
+			if parent.Fields[i].Body != child {
+				continue
+			}
+			if name, ok := parent.Fields[i].Name.(*ast.LiteralString); ok {
+				return "field:" + url.PathEscape(name.Value)
+			}
+			return ""
+		}
+	case *ast.Apply:
+		for i, arg := range parent.Arguments.Positional {
+			if arg.Expr == child {
+				return fmt.Sprintf("arg:%d", i)
+			}
+		}
+		for _, arg := range parent.Arguments.Named {
+			if arg.Arg == child {
+				return "arg:" + url.PathEscape(string(arg.Name))
+			}
+		}
+	case *ast.Array:
+		for i, elem := range parent.Elements {
+			if elem.Expr == child {
+				return fmt.Sprintf("index:%d", i)
+			}
+		}
+	}
+	return ""
+}
+
+// scopePath returns the segments of the bindings from the root of the stack to its last node
+func scopePath(stack []ast.Node) []string {
+	res := []string{}
+	for i := 0; i+1 < len(stack); i++ {
+		if seg := scopeSegment(stack[i], stack[i+1]); seg != "" {
+			res = append(res, seg)
+		}
+	}
+	return res
+}
+
+// fileAST returns the AST of the file, from the overlay if it is open
+func (s *Server) fileAST(filename string) ast.Node {
+	if root := s.getCurrentAST(uri.File(filename)); root != nil {
+		return root
+	}
+	contents, err := os.ReadFile(filename)
+	if err != nil {
+		return nil
+	}
+	root, _ := jsonnet.SnippetToAST(filename, string(contents))
+	return root
+}
+
+// symbolID formats the identifier of the symbol in the file with the scope
+func (s *Server) symbolID(filename string, scope []string) string {
+	path := filename
+	if rel, err := filepath.Rel(s.rootURI.Filename(), filename); err == nil && !strings.HasPrefix(rel, "..") {
+		path = rel
+	}
+	return filepath.ToSlash(path) + "#" + strings.Join(scope, "/")
+}
+
+// definitionID returns the identifier of the binding defining `def`, by finding it in the AST of
+// its file. Returns false if `def` is not bound to a name.
+func (s *Server) definitionID(def ast.Node, resolver *valueResolver) (string, bool) {
+	rng := analysis.NodeRange(def)
+	if !rng.IsSet() {
+		return "", false
+	}
+	root := resolver.Root()
+	if analysis.NodeRange(root).FileName != rng.FileName {
+		root = s.fileAST(rng.FileName)
+	}
+	if root == nil {
+		return "", false
+	}
+
+	var found []ast.Node
+	analysis.WalkStack(root, func(n ast.Node, stack []ast.Node) bool {
+		if found != nil {
+			return false
+		}
+		if sameDefinition(n, def) {
+			found = append([]ast.Node{}, stack...)
+			return false
+		}
+		return true
+	})
+	if len(found) < 2 || scopeSegment(found[len(found)-2], found[len(found)-1]) == "" {
+		return "", false
+	}
+	return s.symbolID(rng.FileName, scopePath(found)), true
+}
+
+// paramID returns the identifier of the parameter of a function in the stack defined at `loc`
+func (s *Server) paramID(stack []ast.Node, loc ast.LocationRange) (string, bool) {
+	for i := len(stack) - 1; i >= 0; i-- {
+		fn, ok := stack[i].(*ast.Function)
+		if !ok {
+			continue
+		}
+		for _, p := range fn.Parameters {
+			if p.LocRange == loc {
+				scope := append(scopePath(stack[:i+1]), "param:"+url.PathEscape(string(p.Name)))
+				return s.symbolID(loc.FileName, scope), true
+			}
+		}
+	}
+	return "", false
+}
+
+// SymbolID returns the stable identifier of the symbol at the position: a local, field or
+// parameter, either where it is defined or where it is used
+func (s *Server) SymbolID(ctx context.Context, params *SymbolIDParams) (*SymbolIDResult, error) {
+	resolver := s.NewResolver(params.TextDocument.URI)
+	if resolver == nil {
+		return nil, nil
+	}
+	pos := protoToPos(params.Position)
+	node, stack := resolver.NodeAt(pos)
+	if node == nil {
+		return nil, nil
+	}
+
+	result := func(id string, rng ast.LocationRange) *SymbolIDResult {
+		return &SymbolIDResult{ID: id, Location: protocol.Location{URI: uri.File(rng.FileName), Range: rangeToProto(rng)}}
+	}
+
+	var def ast.Node
+	if _, fld := fieldAtCursor(pos, node, stack, resolver); fld != nil {
+		def = fld
+	}
+	switch n := node.(type) {
+	case *ast.Var:
+		v := resolver.Vars(n).Get(string(n.Id))
+		switch {
+		case v == nil || n.Id == "self" || n.Id == "$" || n.Id == "super":
+			return nil, nil
+		case v.Loc.IsSet():
+			// parameters are only bound to their default value
+			if id, ok := s.paramID(stack, v.Loc); ok {
+				return result(id, v.Loc), nil
+			}
+		}
+		def = v.Node
+	case *ast.Local:
+		for _, b := range n.Binds {
+			if b.LocRange.IsSet() && analysis.LocInRange(b.LocRange, pos) && !analysis.LocInRange(analysis.NodeRange(b.Body), pos) {
+				def = b.Body
+			}
+		}
+	case *ast.Function:
+		for _, p := range n.Parameters {
+			if analysis.LocInRange(p.LocRange, pos) {
+				if id, ok := s.paramID(stack, p.LocRange); ok {
+					return result(id, p.LocRange), nil
+				}
+			}
+		}
+	}
+	if def == nil {
+		return nil, nil
+	}
+
+	id, ok := s.definitionID(def, resolver)
+	if !ok {
+		return nil, nil
+	}
+	return result(id, analysis.NodeRange(def)), nil
+}
@@ -0,0 +1,410 @@
+package lsp
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/carlverge/jsonnet-lsp/pkg/analysis"
+	"github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/ast"
+	"go.lsp.dev/protocol"
+	"go.lsp.dev/uri"
+)
+
+// symbolIndexVersion is bumped when the format of the persisted index changes, to discard
+// indexes written by older versions
+const symbolIndexVersion = 1
+
+// indexedSymbol is a top level symbol of a file: a local of the file, or a field of the object
+// the file evaluates to
+type indexedSymbol struct {
+	Name  string              `json:"name"`
+	Kind  protocol.SymbolKind `json:"kind"`
+	Range protocol.Range      `json:"range"`
+}
+
+// indexedFile is the index of a file, valid as long as its modification time is unchanged
+type indexedFile struct {
+	ModTime int64           `json:"modTime"`
+	Symbols []indexedSymbol `json:"symbols"`
+}
+
+// symbolIndex caches the top level symbols of the workspace files, by the path
+// relative to the workspace root. Entries are validated against the file modification time
+// when the workspace is walked, so a persisted index can be loaded without checking every file
+// up front. After the walk, the index is kept up to date from the watched file events instead.
+type symbolIndex struct {
+	lock sync.Mutex
+	// a nil entry is a file that is indexed again when next used
+	files map[string]*indexedFile
+	// the matcher the workspace was walked with, nil if it was not walked yet
+	walkedWith *ignoreMatcher
+	// where the index is persisted, empty if it is only kept in memory
+	cacheFile string
+	dirty     bool
+}
+
+type persistedSymbolIndex struct {
+	Version int                     `json:"version"`
+	Files   map[string]*indexedFile `json:"files"`
+}
+
+func newSymbolIndex() *symbolIndex {
+	return &symbolIndex{files: map[string]*indexedFile{}}
+}
+
+// symbolCacheFile returns the file the index of the workspace is persisted to, under the user
+// cache directory. Returns an empty string if there is no cache directory.
+func symbolCacheFile(root uri.URI) string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(root.Filename()))
+	return filepath.Join(dir, "jsonnet-lsp", "symbols-"+hex.EncodeToString(sum[:8])+".json")
+}
+
+// load reads a persisted index from `cacheFile`, and persists the index there on save. A missing
+// or unreadable index is not an error, the files are indexed again as they are used.
+func (idx *symbolIndex) load(cacheFile string) {
+	idx.lock.Lock()
+	defer idx.lock.Unlock()
+	idx.cacheFile = cacheFile
+	if cacheFile == "" {
+		return
+	}
+
+	data, err := os.ReadFile(cacheFile)
+	if err != nil {
+		return
+	}
+	persisted := &persistedSymbolIndex{}
+	if err := json.Unmarshal(data, persisted); err != nil || persisted.Version != symbolIndexVersion {
+		logf("discarding symbol index '%s': version=%d err=%v", cacheFile, persisted.Version, err)
+		return
+	}
+	for path, file := range persisted.Files {
+		if file != nil {
+			idx.files[path] = file
+		}
+	}
+	logf("loaded symbol index '%s' with %d files", cacheFile, len(idx.files))
+}
+
+// save persists the index if it changed since it was loaded or last saved
+func (idx *symbolIndex) save() error {
+	idx.lock.Lock()
+	defer idx.lock.Unlock()
+	if idx.cacheFile == "" || !idx.dirty {
+		return nil
+	}
+
+	data, err := json.Marshal(&persistedSymbolIndex{Version: symbolIndexVersion, Files: idx.files})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(idx.cacheFile), 0o755); err != nil {
+		return err
+	}
+	// write then rename, so that a concurrent server never reads a partial index
+	tmp := idx.cacheFile + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, idx.cacheFile); err != nil {
+		return err
+	}
+	idx.dirty = false
+	return nil
+}
+
+// invalidate drops the symbols of the file, so that it is indexed again when next used. Files
+// not in the index yet are added, like files created since the workspace was walked.
+func (idx *symbolIndex) invalidate(path string) {
+	idx.lock.Lock()
+	defer idx.lock.Unlock()
+	idx.files[path] = nil
+	idx.dirty = true
+}
+
+// remove drops the file from the index, and every file below it if it is a directory
+func (idx *symbolIndex) remove(path string) {
+	idx.lock.Lock()
+	defer idx.lock.Unlock()
+	for file := range idx.files {
+		if file == path || strings.HasPrefix(file, path+"/") {
+			delete(idx.files, file)
+			idx.dirty = true
+		}
+	}
+}
+
+// walked returns the entries of the index if the workspace was walked with `ignore`
+func (idx *symbolIndex) walked(ignore *ignoreMatcher) (map[string]*indexedFile, bool) {
+	idx.lock.Lock()
+	defer idx.lock.Unlock()
+	if idx.walkedWith == nil || idx.walkedWith != ignore {
+		return nil, false
+	}
+	res := make(map[string]*indexedFile, len(idx.files))
+	for path, ent := range idx.files {
+		res[path] = ent
+	}
+	return res, true
+}
+
+// setWalked drops the entries of the files not `found` by walking the workspace with `ignore`,
+// like the files deleted while the index was persisted
+func (idx *symbolIndex) setWalked(ignore *ignoreMatcher, found map[string]*indexedFile) {
+	idx.lock.Lock()
+	defer idx.lock.Unlock()
+	for path := range idx.files {
+		if _, ok := found[path]; !ok {
+			delete(idx.files, path)
+			idx.dirty = true
+		}
+	}
+	idx.walkedWith = ignore
+}
+
+// file returns the entry of the file if it was indexed at `modTime`, otherwise it indexes the
+// file with `build` and stores the result
+func (idx *symbolIndex) file(path string, modTime int64, build func() *indexedFile) *indexedFile {
+	idx.lock.Lock()
+	ent := idx.files[path]
+	idx.lock.Unlock()
+	if ent != nil && ent.ModTime == modTime {
+		return ent
+	}
+
+	ent = build()
+	ent.ModTime = modTime
+	idx.lock.Lock()
+	idx.files[path] = ent
+	idx.dirty = true
+	idx.lock.Unlock()
+	return ent
+}
+
+// fileSymbols returns the top level locals of the file, and the fields of the object it
+// evaluates to
+func fileSymbols(root ast.Node) []indexedSymbol {
+	res := []indexedSymbol{}
+	locals, body := analysis.UnwindLocals(root)
+	for _, name := range locals.Names() {
+		v := locals.Get(name)
+		// skip the `std` local added by the parser
+		if v.Node == nil {
+			continue
+		}
+		kind, rng := protocol.SymbolKindVariable, v.Loc
+		// function locals are not located, use the location of the function
+		if _, ok := v.Node.(*ast.Function); ok {
+			kind = protocol.SymbolKindFunction
+		}
+		if !rng.IsSet() {
+			rng = *v.Node.Loc()
+		}
+		res = append(res, indexedSymbol{Name: name, Kind: kind, Range: rangeToProto(rng)})
+	}
+
+	obj, ok := body.(*ast.DesugaredObject)
+	if !ok {
+		return res
+	}
+	for i := range obj.Fields {
+		name, rng, ok := identFieldName(&obj.Fields[i])
+		if !ok {
+			// string names are located at the field
+			fn, isString := obj.Fields[i].Name.(*ast.LiteralString)
+			if !isString {
+				continue
+			}
+			name, rng = fn.Value, obj.Fields[i].LocRange
+		}
+		kind := protocol.SymbolKindField
+		if _, ok := obj.Fields[i].Body.(*ast.Function); ok {
+			kind = protocol.SymbolKindMethod
+		}
+		res = append(res, indexedSymbol{Name: name, Kind: kind, Range: rangeToProto(rng)})
+	}
+	return res
+}
+
+// indexFile parses the file and collects its symbols
+func indexFile(path string, contents []byte) *indexedFile {
+	res := &indexedFile{Symbols: []indexedSymbol{}}
+	root, err := jsonnet.SnippetToAST(path, string(contents))
+	if err != nil || root == nil {
+		return res
+	}
+	res.Symbols = fileSymbols(root)
+	return res
+}
+
+// indexWorkspace returns the index of every jsonnet file in the workspace, by the path relative
+// to the root. Files open in the editor are indexed from their current contents. The workspace
+// is only walked once, or again when the files to skip change.
+func (s *Server) indexWorkspace(ctx context.Context) map[string]*indexedFile {
+	res := map[string]*indexedFile{}
+	ignore := s.workspaceIgnore()
+	if files, ok := s.symbols.walked(ignore); ok {
+		for rel, ent := range files {
+			if ctx.Err() != nil {
+				break
+			}
+			if ent == nil || s.overlay.Current(s.workspaceURI(rel)) != nil {
+				ent = s.indexPath(rel, nil)
+			}
+			if ent != nil {
+				res[rel] = ent
+			}
+		}
+		return res
+	}
+
+	err := fs.WalkDir(s.rootFS, ".", func(rel string, ent fs.DirEntry, err error) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil || rel == "." {
+			return nil
+		}
+		if strings.HasPrefix(ent.Name(), ".") || ignore.Match(rel, ent.IsDir()) {
+			if ent.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if ent.IsDir() || !s.config.isJsonnetFile(rel) {
+			return nil
+		}
+		info, err := ent.Info()
+		if err != nil {
+			return nil
+		}
+		if indexed := s.indexPath(rel, info); indexed != nil {
+			res[rel] = indexed
+		}
+		return nil
+	})
+	if err == nil {
+		s.symbols.setWalked(ignore, res)
+	}
+	return res
+}
+
+// indexPath returns the index of the file at the slash separated path relative to the root,
+// stating the file if `info` is nil. Returns nil if the file does not exist anymore.
+func (s *Server) indexPath(rel string, info fs.FileInfo) *indexedFile {
+	u := s.workspaceURI(rel)
+	path := u.Filename()
+	if cur := s.overlay.Current(u); cur != nil {
+		return indexFile(path, []byte(cur.Contents))
+	}
+	if info == nil {
+		var err error
+		if info, err = fs.Stat(s.rootFS, rel); err != nil {
+			s.symbols.remove(rel)
+			return nil
+		}
+	}
+	return s.symbols.file(rel, info.ModTime().UnixNano(), func() *indexedFile {
+		contents, err := fs.ReadFile(s.rootFS, rel)
+		if err != nil {
+			return &indexedFile{Symbols: []indexedSymbol{}}
+		}
+		return indexFile(path, contents)
+	})
+}
+
+// indexable returns true if walking the workspace indexes the file at the slash separated path
+// relative to the root, see indexWorkspace
+func (s *Server) indexable(rel string) bool {
+	ignore := s.workspaceIgnore()
+	parts := strings.Split(rel, "/")
+	for i, part := range parts {
+		if strings.HasPrefix(part, ".") || ignore.Match(strings.Join(parts[:i+1], "/"), i < len(parts)-1) {
+			return false
+		}
+	}
+	return s.config.isJsonnetFile(rel)
+}
+
+// workspaceURI returns the URI of the slash separated path relative to the workspace root
+func (s *Server) workspaceURI(rel string) uri.URI {
+	return uri.File(filepath.Join(s.rootURI.Filename(), filepath.FromSlash(rel)))
+}
+
+// Symbols searches the top level symbols of the jsonnet files in the workspace. The query is
+// matched case insensitively against the symbol names, and an empty query matches everything.
+func (s *Server) Symbols(ctx context.Context, params *protocol.WorkspaceSymbolParams) ([]protocol.SymbolInformation, error) {
+	res := []protocol.SymbolInformation{}
+	files := s.indexWorkspace(ctx)
+	if s.config.SymbolCache {
+		if err := s.symbols.save(); err != nil {
+			logf("failed to save symbol index: %v", err)
+		}
+	}
+
+	paths := make([]string, 0, len(files))
+	for path := range files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	query := strings.ToLower(params.Query)
+	for _, path := range paths {
+		for _, sym := range files[path].Symbols {
+			if !strings.Contains(strings.ToLower(sym.Name), query) {
+				continue
+			}
+			res = append(res, protocol.SymbolInformation{
+				Name: sym.Name,
+				Kind: sym.Kind,
+				Location: protocol.Location{
+					URI:   s.workspaceURI(path),
+					Range: sym.Range,
+				},
+				ContainerName: path,
+			})
+		}
+	}
+	return res, nil
+}
+
+// DidChangeWatchedFiles updates the index entries and drops the cached VMs of files changed
+// outside of the editor
+func (s *Server) DidChangeWatchedFiles(ctx context.Context, params *protocol.DidChangeWatchedFilesParams) error {
+	for _, change := range params.Changes {
+		if filepath.Base(change.URI.Filename()) == dirConfigFile {
+			s.invalidateDirConfig(ctx, change.URI)
+			continue
+		}
+		s.invalidateVMs(change.URI)
+		rel, err := filepath.Rel(s.rootURI.Filename(), change.URI.Filename())
+		if err != nil || strings.HasPrefix(rel, "..") {
+			continue
+		}
+		if rel == ".gitignore" {
+			s.invalidateIgnore()
+			continue
+		}
+		rel = filepath.ToSlash(rel)
+		switch {
+		case change.Type == protocol.FileChangeTypeDeleted:
+			s.symbols.remove(rel)
+		case s.indexable(rel):
+			s.symbols.invalidate(rel)
+		}
+	}
+	return nil
+}
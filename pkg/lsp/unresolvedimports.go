@@ -0,0 +1,54 @@
+package lsp
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/carlverge/jsonnet-lsp/pkg/analysis"
+	"github.com/google/go-jsonnet/ast"
+	"go.lsp.dev/protocol"
+)
+
+type UnresolvedImportsParams struct {
+	TextDocument *protocol.TextDocumentIdentifier `json:"textDocument"`
+}
+
+type UnresolvedImport struct {
+	// Path as written in the import
+	Path  string         `json:"path"`
+	Range protocol.Range `json:"range"`
+	// Files the import was searched at, in order
+	Candidates []string `json:"candidates"`
+}
+
+type UnresolvedImportsResult struct {
+	Imports []UnresolvedImport `json:"imports"`
+}
+
+// UnresolvedImports lists the imports in the file that are not found, with the files each one was
+// searched at, to debug the jpaths and import roots
+func (s *Server) UnresolvedImports(ctx context.Context, params *UnresolvedImportsParams) (*UnresolvedImportsResult, error) {
+	root := s.getCurrentAST(params.TextDocument.URI)
+	if root == nil {
+		return nil, fmt.Errorf("cannot parse file '%s'", params.TextDocument.URI.Filename())
+	}
+
+	from := params.TextDocument.URI.Filename()
+	res := &UnresolvedImportsResult{Imports: []UnresolvedImport{}}
+	analysis.WalkStack(root, func(n ast.Node, _ []ast.Node) bool {
+		file := importedFile(n)
+		if file == "" {
+			return true
+		}
+		if _, _, err := s.importer.Import(from, file); err == nil {
+			return true
+		}
+		candidates := []string{}
+		for _, c := range s.importer.Candidates(from, file) {
+			candidates = append(candidates, c.Filename())
+		}
+		res.Imports = append(res.Imports, UnresolvedImport{Path: file, Range: rangeToProto(*n.Loc()), Candidates: candidates})
+		return true
+	})
+	return res, nil
+}
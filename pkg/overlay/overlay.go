@@ -156,6 +156,18 @@ func (o *Overlay) Update(u uri.URI, version int64, edits []gotextdiff.TextEdit,
 	o.update(fileUpdate{URI: u, Version: version, Edits: edits}, parse, done)
 }
 
+// ReplaceSync is like Replace, but applies the update before returning instead of in the
+// background. Used by tests and the CLI.
+func (o *Overlay) ReplaceSync(u uri.URI, version int64, data string, parse ParseFunc) UpdateResult {
+	return o.updateSync(fileUpdate{URI: u, Version: version, Replace: &data}, parse)
+}
+
+// UpdateSync is like Update, but applies the edits before returning instead of in the
+// background. Used by tests and the CLI.
+func (o *Overlay) UpdateSync(u uri.URI, version int64, edits []gotextdiff.TextEdit, parse ParseFunc) UpdateResult {
+	return o.updateSync(fileUpdate{URI: u, Version: version, Edits: edits}, parse)
+}
+
 func (o *Overlay) Current(u uri.URI) *Entry {
 	o.fileLock.Lock()
 	ent := o.files[u]
@@ -180,6 +192,47 @@ func (o *Overlay) Parsed(u uri.URI) *Entry {
 	return ent.parsed
 }
 
+// Open returns the files with contents, sorted by URI
+func (o *Overlay) Open() []uri.URI {
+	o.fileLock.Lock()
+	files := make(map[uri.URI]*overlayFile, len(o.files))
+	for u, f := range o.files {
+		files[u] = f
+	}
+	o.fileLock.Unlock()
+
+	res := []uri.URI{}
+	for u, f := range files {
+		f.entryLock.Lock()
+		if f.current != nil {
+			res = append(res, u)
+		}
+		f.entryLock.Unlock()
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i] < res[j] })
+	return res
+}
+
+// Refresh calls `done` with the current state of the file without changing it. The call
+// is linearized with updates to the file.
+func (o *Overlay) Refresh(u uri.URI, done UpdateFunc) {
+	go func() {
+		f := o.getFile(u)
+		f.updateLock.Lock()
+		defer f.updateLock.Unlock()
+
+		f.entryLock.Lock()
+		res := UpdateResult{Current: f.current, Parsed: f.parsed}
+		f.entryLock.Unlock()
+
+		// file was closed or never opened
+		if res.Current == nil {
+			return
+		}
+		done(res)
+	}()
+}
+
 // getFile always returns non nil -- it will create an entry if it doesnt exist
 func (o *Overlay) getFile(u uri.URI) *overlayFile {
 	o.fileLock.Lock()
@@ -193,10 +246,7 @@ func (o *Overlay) getFile(u uri.URI) *overlayFile {
 }
 
 func (o *Overlay) update(u fileUpdate, parse ParseFunc, done UpdateFunc) {
-	// put the updates in queue as soon as possible to help make sure they are ordered
-	o.updateLock.Lock()
-	o.updateQueue[u.URI] = append(o.updateQueue[u.URI], u)
-	o.updateLock.Unlock()
+	o.enqueue(u)
 
 	// run asynchronously
 	go func() {
@@ -210,26 +260,61 @@ func (o *Overlay) update(u fileUpdate, parse ParseFunc, done UpdateFunc) {
 		f.updateLock.Lock()
 		defer f.updateLock.Unlock()
 
-		o.updateLock.Lock()
-		pending := o.updateQueue[u.URI]
-		delete(o.updateQueue, u.URI)
-		o.updateLock.Unlock()
-
 		// another goroutine processed the updates
-		if len(pending) == 0 {
+		res, ok := o.applyPending(f, u.URI, parse)
+		if !ok {
 			return
 		}
 
-		// if somehow a batch of updates came in out of order, try to remediate it
-		sort.Slice(pending, func(i, j int) bool {
-			return pending[i].Version < pending[j].Version
-		})
-
-		applyFileUpdates(f, pending, parse)
-
 		// callback to user code
 		// Note: this is intentionally called under lock to linearize updates
 		// and allow user to control batching of things like diagnostics.
-		done(UpdateResult{Current: f.current, Parsed: f.parsed})
+		done(res)
 	}()
 }
+
+// updateSync applies the update on the calling goroutine, along with any other pending updates
+// to the file, and returns the state of the file after them.
+func (o *Overlay) updateSync(u fileUpdate, parse ParseFunc) UpdateResult {
+	o.enqueue(u)
+
+	f := o.getFile(u.URI)
+	f.updateLock.Lock()
+	defer f.updateLock.Unlock()
+
+	if res, ok := o.applyPending(f, u.URI, parse); ok {
+		return res
+	}
+	// another goroutine processed the update while this one waited for the lock
+	f.entryLock.Lock()
+	defer f.entryLock.Unlock()
+	return UpdateResult{Current: f.current, Parsed: f.parsed}
+}
+
+// enqueue puts the update in queue as soon as possible to help make sure they are ordered
+func (o *Overlay) enqueue(u fileUpdate) {
+	o.updateLock.Lock()
+	o.updateQueue[u.URI] = append(o.updateQueue[u.URI], u)
+	o.updateLock.Unlock()
+}
+
+// applyPending applies the queued updates of the file, run with f.updateLock already locked.
+// Returns false if there were no pending updates.
+func (o *Overlay) applyPending(f *overlayFile, u uri.URI, parse ParseFunc) (UpdateResult, bool) {
+	o.updateLock.Lock()
+	pending := o.updateQueue[u]
+	delete(o.updateQueue, u)
+	o.updateLock.Unlock()
+
+	if len(pending) == 0 {
+		return UpdateResult{}, false
+	}
+
+	// if somehow a batch of updates came in out of order, try to remediate it
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i].Version < pending[j].Version
+	})
+
+	applyFileUpdates(f, pending, parse)
+	return UpdateResult{Current: f.current, Parsed: f.parsed}, true
+}
@@ -0,0 +1,41 @@
+package overlay
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hexops/gotextdiff"
+	"github.com/hexops/gotextdiff/span"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.lsp.dev/uri"
+)
+
+// upperParse records the contents in upper case, and fails to parse contents with a '!'
+func upperParse(contents string, _ *gotextdiff.TextEdit) (interface{}, bool) {
+	return strings.ToUpper(contents), !strings.Contains(contents, "!")
+}
+
+func TestUpdateSync(t *testing.T) {
+	o := NewOverlay()
+	u := uri.File("/test/main.jsonnet")
+
+	res := o.ReplaceSync(u, 1, "hello", upperParse)
+	require.NotNil(t, res.Current)
+	assert.Equal(t, "hello", res.Current.Contents)
+	assert.Equal(t, "HELLO", res.Current.Data)
+	assert.Same(t, res.Current, res.Parsed)
+	assert.Same(t, res.Current, o.Current(u))
+
+	edit := gotextdiff.TextEdit{Span: span.New(span.URI(u), span.NewPoint(1, 6, 5), span.NewPoint(1, 6, 5)), NewText: "!"}
+	res = o.UpdateSync(u, 2, []gotextdiff.TextEdit{edit}, upperParse)
+	assert.Equal(t, "hello!", res.Current.Contents)
+	assert.Equal(t, int64(2), res.Current.Version)
+	// the last successfully parsed version is kept
+	assert.Equal(t, int64(1), res.Parsed.Version)
+	assert.Same(t, res.Parsed, o.Parsed(u))
+
+	o.Close(u)
+	assert.Eventually(t, func() bool { return o.Current(u) == nil }, time.Second, time.Millisecond)
+}